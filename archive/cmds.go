@@ -0,0 +1,405 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/twitter"
+	"github.com/jdevoo/nucoll/util"
+)
+
+func init() {
+	sns.Register(sns.Backend{
+		Name:        "archive",
+		Description: "Twitter archives donated as zip files, works offline",
+		New:         func() sns.SocialNetworkService { return Archive{} },
+	})
+}
+
+// Archive reads the Twitter archives found in Dir instead of calling the API, the workspace when empty
+// files are written in the same layout as the twitter backend
+type Archive struct {
+	Dir     string
+	exports []*Export
+	byID    map[string]*Export
+	names   map[string]string
+	ids     map[string]string
+}
+
+// load discovers archives and indexes the screen names they mention
+// names of accounts which did not donate an archive are learned from mentions and replies
+func (ns *Archive) load() error {
+	var err error
+
+	if ns.exports != nil {
+		return nil
+	}
+	if ns.Dir == "" {
+		ns.Dir = util.WorkspacePath(".")
+	}
+	if ns.exports, err = Discover(ns.Dir); err != nil {
+		return err
+	}
+	if len(ns.exports) == 0 {
+		ns.exports = nil
+		return fmt.Errorf("no Twitter archive found in %s", ns.Dir)
+	}
+	ns.byID = make(map[string]*Export)
+	ns.names = make(map[string]string)
+	ns.ids = make(map[string]string)
+	learn := func(id string, name string) {
+		if id == "" || name == "" {
+			return
+		}
+		if _, ok := ns.names[id]; !ok {
+			ns.names[id] = name
+		}
+		if _, ok := ns.ids[strings.ToLower(name)]; !ok {
+			ns.ids[strings.ToLower(name)] = id
+		}
+	}
+	for _, e := range ns.exports {
+		ns.byID[e.Account.AccountID] = e
+		ns.names[e.Account.AccountID] = e.Account.Username
+		ns.ids[strings.ToLower(e.Account.Username)] = e.Account.AccountID
+	}
+	for _, e := range ns.exports {
+		tweets, err := e.Tweets()
+		if err != nil {
+			ns.exports = nil
+			return err
+		}
+		for _, t := range tweets {
+			learn(t.InReplyToUserIDStr, t.InReplyToScreenName)
+			for _, m := range t.Entities.UserMentions {
+				learn(m.IDStr, m.ScreenName)
+			}
+		}
+	}
+	log.Printf("found %d archives mentioning %d accounts\n", len(ns.exports), len(ns.names))
+	return nil
+}
+
+// find returns the archive of a handle given as screen name or ID
+func (ns *Archive) find(handle string) *Export {
+	if util.DigitsOnly(handle) {
+		return ns.byID[handle]
+	}
+	return ns.byID[ns.ids[strings.ToLower(handle)]]
+}
+
+// user returns a nucoll user object for an account ID
+// counts are only known for accounts which donated an archive and the ID is used when no screen name is known
+func (ns *Archive) user(id string) twitter.UserObject {
+	var result twitter.UserObject
+
+	result.ID, _ = strconv.ParseUint(id, 10, 64)
+	result.ScreenName = id
+	if name, ok := ns.names[id]; ok {
+		result.ScreenName = name
+	}
+	e, ok := ns.byID[id]
+	if !ok {
+		return result
+	}
+	following, _ := e.Following()
+	followers, _ := e.Followers()
+	tweets, _ := e.Tweets()
+	result.FriendsCount = len(following)
+	result.FollowersCount = len(followers)
+	result.StatusesCount = len(tweets)
+	if t, err := time.Parse(time.RFC3339, e.Account.CreatedAt); err == nil {
+		result.CreatedAt = t.Format(time.RubyDate)
+	}
+	result.URL = e.Profile.Description.Website
+	result.ProfileImageURL = e.Profile.AvatarMediaURL
+	result.Location = e.Profile.Description.Location
+	return result
+}
+
+// tweetObjects maps archived tweets of owner to nucoll tweet objects
+func tweetObjects(owner string, tweets []Tweet) []twitter.TweetObject {
+	result := make([]twitter.TweetObject, len(tweets))
+	for i, t := range tweets {
+		result[i].CreatedAt = t.CreatedAt
+		result[i].ID, _ = strconv.ParseUint(t.IDStr, 10, 64)
+		result[i].User.ScreenName = owner
+		result[i].Text = t.FullText
+		result[i].InReplyToTweet, _ = strconv.ParseUint(t.InReplyToStatusIDStr, 10, 64)
+		result[i].InReplyToUser, _ = strconv.ParseUint(t.InReplyToUserIDStr, 10, 64)
+		result[i].InReplyToScreenName = t.InReplyToScreenName
+		result[i].RetweetCount, _ = strconv.Atoi(t.RetweetCount)
+		result[i].FavoriteCount, _ = strconv.Atoi(t.FavoriteCount)
+	}
+	return result
+}
+
+// repliersOf returns followers with an archive who replied to handle within their maxCount most recent tweets
+func (ns *Archive) repliersOf(e *Export, maxCount int) ([]string, error) {
+	var ids []string
+
+	followers, err := e.Followers()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range followers {
+		f, ok := ns.byID[id]
+		if !ok {
+			continue
+		}
+		tweets, err := f.Tweets()
+		if err != nil {
+			return nil, err
+		}
+		for i, t := range tweets {
+			if i == maxCount {
+				break
+			}
+			if strings.EqualFold(t.InReplyToScreenName, e.Account.Username) {
+				ids = append(ids, id)
+				break
+			}
+		}
+		log.Printf("processed %d tweets from %s\n", len(tweets), f.Account.Username)
+	}
+	return ids, nil
+}
+
+// Init supports retrieve handles from: a query file, followers who reply or a friend/follow relationship
+// lists are not part of archives
+func (ns Archive) Init(ctx context.Context, opts sns.InitOptions, args []string) (string, error) {
+	var ids []string
+	var err error
+	var relation string
+
+	if opts.List != "" {
+		return "", errors.New("list memberships are not part of Twitter archives")
+	}
+	if opts.Images {
+		log.Println("images are not downloaded from archives")
+	}
+	if err = ns.load(); err != nil {
+		return "", err
+	}
+	e := ns.find(args[0])
+	if e == nil && !opts.Query {
+		return "", fmt.Errorf("no archive found for %s", args[0])
+	}
+
+	if opts.Followers {
+		relation = "followers"
+	} else {
+		relation = "friends"
+	}
+
+	switch {
+	case opts.Query:
+		// query file, handles are resolved with the names found in archives
+		var handles []string
+		if handles, err = util.QueryReader(args[0], opts.NoMention); err != nil {
+			return "", err
+		}
+		for _, handle := range handles {
+			if id, ok := ns.ids[strings.ToLower(handle)]; ok {
+				ids = append(ids, id)
+			} else if util.DigitsOnly(handle) {
+				ids = append(ids, handle)
+			} else {
+				log.Printf("skipping %s (unknown ID)\n", handle)
+			}
+		}
+	case opts.MaxPostCount > 0:
+		// followers who reply to this handle
+		ids, err = ns.repliersOf(e, opts.MaxPostCount)
+		relation = "retweeter"
+	case opts.Followers:
+		ids, err = e.Followers()
+	default:
+		ids, err = e.Following()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	result := make([]twitter.UserObject, len(ids))
+	for i, id := range ids {
+		result[i] = ns.user(id)
+		result[i].Relation = relation
+		result[i].Subject = args[0]
+	}
+	filename, err := util.CSVWriter(args[0], util.DatExt, false, result)
+	if err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	log.Printf("processed %d users\n", len(result))
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// Fetch writes second-degree "friends" of handles collected with Init which donated an archive
+func (ns Archive) Fetch(ctx context.Context, opts sns.FetchOptions, args []string) error {
+	if err := ns.load(); err != nil {
+		return err
+	}
+
+	data := []twitter.UserObject{}
+	if err := util.CSVReader(args[0], util.DatExt, &data); err != nil {
+		return err
+	}
+	for _, user := range data {
+		uid := fmt.Sprintf("%d", user.ID)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// skip if file exists and flag to force call not set
+		if !opts.Force && util.FdatExists(uid) {
+			continue
+		}
+		e, ok := ns.byID[uid]
+		if !ok {
+			continue
+		}
+		if user.FriendsCount > opts.MaxFriends {
+			log.Printf("skipping %s (%d friends)\n", user.ScreenName, user.FriendsCount)
+			continue
+		}
+		ids, err := e.Following()
+		if err != nil {
+			return err
+		}
+		if _, err := util.FdatWriter(uid, ids); err != nil {
+			return fmt.Errorf("failed to write friends file: %w", err)
+		}
+		log.Printf("processed %s\n", user.ScreenName)
+	}
+	return nil
+}
+
+// Edgelist constructs the network of who is "friends" with whom among handles returned by Init
+func (ns Archive) Edgelist(ctx context.Context, opts sns.EdgelistOptions, args []string) (string, error) {
+	var cols = []string{
+		"ID",
+		"ScreenName",
+		"Protected",
+		"Verified",
+		"FriendsCount",
+		"FollowersCount",
+		"ListedCount",
+		"StatusesCount",
+		"CreatedAt",
+		"ProfileImageURL",
+		"Relation",
+		"Subject",
+	}
+	var filename string
+	var err error
+
+	data := []twitter.UserObject{}
+	for _, handle := range args {
+		if err = util.CSVReader(handle, util.DatExt, &data); err != nil {
+			return "", err
+		}
+		if opts.Ego {
+			if err = ns.load(); err != nil {
+				return "", err
+			}
+			e := ns.find(handle)
+			if e == nil {
+				return "", fmt.Errorf("no archive found for %s", handle)
+			}
+			data = append(data, ns.user(e.Account.AccountID))
+		}
+	}
+	// call GMLWriter using ScreenName as label for nodes
+	if filename, err = util.GMLWriter(args, data, opts.Missing, cols, "ScreenName"); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// Posts retrieves archived tweets matching a query, replies to a given tweet ID or tweets of a handle
+func (ns Archive) Posts(ctx context.Context, opts sns.PostsOptions, args []string) (string, error) {
+	var result []twitter.TweetObject
+	var postID uint64
+	var err error
+
+	if opts.List != "" {
+		return "", errors.New("lists are not part of Twitter archives")
+	}
+	if opts.PostID != "" {
+		if postID, err = strconv.ParseUint(opts.PostID, 10, 64); err != nil {
+			return "", fmt.Errorf("invalid tweet id %s", opts.PostID)
+		}
+	}
+	if err = ns.load(); err != nil {
+		return "", err
+	}
+
+	switch {
+	case opts.Query || postID != 0:
+		query := strings.ToLower(args[0])
+		for _, e := range ns.exports {
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
+			tweets, err := e.Tweets()
+			if err != nil {
+				return "", err
+			}
+			for _, t := range tweetObjects(e.Account.Username, tweets) {
+				if (opts.Query && strings.Contains(strings.ToLower(t.Text), query)) ||
+					(postID != 0 && t.InReplyToTweet == postID) {
+					result = append(result, t)
+				}
+			}
+		}
+	default:
+		e := ns.find(args[0])
+		if e == nil {
+			return "", fmt.Errorf("no archive found for %s", args[0])
+		}
+		tweets, err := e.Tweets()
+		if err != nil {
+			return "", err
+		}
+		result = tweetObjects(e.Account.Username, tweets)
+	}
+	if len(result) == 0 {
+		return "", nil
+	}
+	filename, err := util.CSVWriter(args[0], util.QueryExt, false, result)
+	if err != nil {
+		return "", fmt.Errorf("failed to write posts: %w", err)
+	}
+	log.Printf("%s created\n", filename)
+	log.Printf("processed %d tweets\n", len(result))
+	return filename, nil
+}
+
+// Resolve converts screen names to IDs and vice versa using the names found in archives
+func (ns Archive) Resolve(ctx context.Context, args []string) ([]sns.Profile, error) {
+	var result []sns.Profile
+
+	if err := ns.load(); err != nil {
+		return nil, err
+	}
+
+	for _, handle := range args {
+		var id string
+		if util.DigitsOnly(handle) {
+			id = handle
+		} else if id = ns.ids[strings.ToLower(handle)]; id == "" {
+			return result, fmt.Errorf("%s not found in archives", handle)
+		}
+		result = append(result, ns.user(id).Profile(handle))
+	}
+	return result, nil
+}
@@ -0,0 +1,102 @@
+package archive
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/twitter"
+	"github.com/jdevoo/nucoll/util"
+	"github.com/jdevoo/nucoll/util/utiltest"
+)
+
+// writeExport creates a minimal archive in the layout of the Twitter data export in the workspace
+func writeExport(t *testing.T, filename string, files map[string]string) {
+	f, err := os.Create(util.WorkspacePath(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		fw, err := w.Create("data/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprint(fw, content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// alice follows bob and carol, bob follows carol and replied to alice
+// carol did not donate an archive and is only known from a mention
+func TestInitFetchPosts(t *testing.T) {
+	// archives are looked up in the workspace rather than the current directory
+	utiltest.InWorkspace(t)
+	writeExport(t, "alice.zip", map[string]string{
+		"account.js":   `window.YTD.account.part0 = [{"account":{"username":"alice","accountId":"1","createdAt":"2009-03-04T12:00:00.000Z"}}]`,
+		"profile.js":   `window.YTD.profile.part0 = [{"profile":{"description":{"location":"Ghent"}}}]`,
+		"following.js": `window.YTD.following.part0 = [{"following":{"accountId":"2"}},{"following":{"accountId":"3"}}]`,
+		"follower.js":  `window.YTD.follower.part0 = [{"follower":{"accountId":"2"}}]`,
+		"tweets.js":    `window.YTD.tweets.part0 = [{"tweet":{"id_str":"10","full_text":"hello @carol","created_at":"Wed Oct 10 20:19:24 +0000 2018","retweet_count":"2","favorite_count":"1","entities":{"user_mentions":[{"screen_name":"carol","id_str":"3"}]}}}]`,
+	})
+	writeExport(t, "bob.zip", map[string]string{
+		"account.js":   `window.YTD.account.part0 = [{"account":{"username":"bob","accountId":"2"}}]`,
+		"following.js": `window.YTD.following.part0 = [{"following":{"accountId":"3"}}]`,
+		"follower.js":  `window.YTD.follower.part0 = [{"follower":{"accountId":"1"}}]`,
+		"tweet.js":     `window.YTD.tweet.part0 = [{"tweet":{"id_str":"11","full_text":"@alice hi","created_at":"Wed Oct 10 21:19:24 +0000 2018","in_reply_to_status_id_str":"10","in_reply_to_user_id_str":"1","in_reply_to_screen_name":"alice"}}]`,
+	})
+	writeExport(t, "other.zip", map[string]string{"readme.txt": "not an archive"})
+
+	ns := Archive{}
+	ctx := context.Background()
+	if _, err := ns.Init(ctx, sns.InitOptions{}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	data := []twitter.UserObject{}
+	if err := util.CSVReader("alice", util.DatExt, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2 || data[0].ScreenName != "bob" || data[0].FriendsCount != 1 || data[0].StatusesCount != 1 {
+		t.Fatalf("unexpected users %+v", data)
+	}
+	if data[1].ID != 3 || data[1].ScreenName != "carol" || data[1].Relation != "friends" {
+		t.Errorf("unexpected user %+v", data[1])
+	}
+
+	if err := ns.Fetch(ctx, sns.FetchOptions{MaxFriends: 5000}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(util.WorkspacePath(util.FdatDir + "/2" + util.FdatExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(b)); !reflect.DeepEqual(got, []string{"3"}) {
+		t.Errorf("got friends %v, want [3]", got)
+	}
+	if util.FdatExists("3") {
+		t.Error("friends file written for account without archive")
+	}
+
+	if _, err := ns.Posts(ctx, sns.PostsOptions{PostID: "10"}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	tweets := []twitter.TweetObject{}
+	if err := util.CSVReader("alice", util.QueryExt, &tweets); err != nil {
+		t.Fatal(err)
+	}
+	if len(tweets) != 1 || tweets[0].ID != 11 || tweets[0].InReplyToUser != 1 {
+		t.Errorf("unexpected replies %+v", tweets)
+	}
+	if b, _ := ioutil.ReadFile(util.WorkspacePath("alice" + util.QueryExt)); !strings.Contains(string(b), "@bob") {
+		t.Errorf("reply not attributed to bob: %s", b)
+	}
+}
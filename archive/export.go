@@ -0,0 +1,196 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Account as found in data/account.js
+type Account struct {
+	Username           string `json:"username"`
+	AccountID          string `json:"accountId"`
+	CreatedAt          string `json:"createdAt"`
+	AccountDisplayName string `json:"accountDisplayName"`
+}
+
+// Profile as found in data/profile.js
+type Profile struct {
+	Description struct {
+		Bio      string `json:"bio"`
+		Website  string `json:"website"`
+		Location string `json:"location"`
+	} `json:"description"`
+	AvatarMediaURL string `json:"avatarMediaUrl"`
+}
+
+// Relation as found in data/following.js and data/follower.js
+type Relation struct {
+	AccountID string `json:"accountId"`
+}
+
+// Tweet as found in data/tweets.js, numbers are exported as strings
+type Tweet struct {
+	IDStr                string `json:"id_str"`
+	FullText             string `json:"full_text"`
+	CreatedAt            string `json:"created_at"`
+	InReplyToStatusIDStr string `json:"in_reply_to_status_id_str"`
+	InReplyToUserIDStr   string `json:"in_reply_to_user_id_str"`
+	InReplyToScreenName  string `json:"in_reply_to_screen_name"`
+	RetweetCount         string `json:"retweet_count"`
+	FavoriteCount        string `json:"favorite_count"`
+	Entities             struct {
+		UserMentions []struct {
+			ScreenName string `json:"screen_name"`
+			IDStr      string `json:"id_str"`
+		} `json:"user_mentions"`
+	} `json:"entities"`
+}
+
+// Export is a personal data archive downloaded from Twitter
+type Export struct {
+	Path    string
+	Account Account
+	Profile Profile
+}
+
+// tweetsRE matches tweet files across archive versions including split parts
+var tweetsRE = regexp.MustCompile(`^tweets?(-part\d+)?\.js$`)
+
+// Open reads the account and profile of the archive found at filename
+func Open(filename string) (*Export, error) {
+	var accounts []Account
+	var profiles []Profile
+
+	e := &Export{Path: filename}
+	if err := e.read("account.js", "account", &accounts); err != nil {
+		return nil, err
+	}
+	if len(accounts) == 0 {
+		return nil, errors.New(filename + ": account.js not found")
+	}
+	e.Account = accounts[0]
+	// profile is optional
+	if err := e.read("profile.js", "profile", &profiles); err == nil && len(profiles) > 0 {
+		e.Profile = profiles[0]
+	}
+	return e, nil
+}
+
+// Discover opens all archives with a .zip extension found in dir
+// zip files which are not Twitter archives are ignored
+func Discover(dir string) ([]*Export, error) {
+	var result []*Export
+
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.zip"))
+	if err != nil {
+		return nil, err
+	}
+	for _, filename := range filenames {
+		if e, err := Open(filename); err == nil {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+// Following returns the IDs of accounts followed by the archive owner
+func (e *Export) Following() ([]string, error) {
+	return e.relations("following.js", "following")
+}
+
+// Followers returns the IDs of accounts following the archive owner
+func (e *Export) Followers() ([]string, error) {
+	return e.relations("follower.js", "follower")
+}
+
+// Tweets returns all tweets of the archive owner, split parts included
+func (e *Export) Tweets() ([]Tweet, error) {
+	var tweets []Tweet
+
+	r, err := zip.OpenReader(e.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if !tweetsRE.MatchString(path.Base(f.Name)) {
+			continue
+		}
+		var part []Tweet
+		if err := decode(f, "tweet", &part); err != nil {
+			return nil, err
+		}
+		tweets = append(tweets, part...)
+	}
+	return tweets, nil
+}
+
+// relations returns the account IDs listed in a following or follower file
+func (e *Export) relations(name string, key string) ([]string, error) {
+	var relations []Relation
+
+	if err := e.read(name, key, &relations); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(relations))
+	for i, r := range relations {
+		ids[i] = r.AccountID
+	}
+	return ids, nil
+}
+
+// read decodes the entries of the archive file with base name into v
+// a missing file leaves v untouched
+func (e *Export) read(name string, key string, v interface{}) error {
+	r, err := zip.OpenReader(e.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if path.Base(f.Name) == name {
+			return decode(f, key, v)
+		}
+	}
+	return nil
+}
+
+// decode strips the JavaScript assignment wrapping each archive file
+// and unwraps the objects of the array stored under key into v, a pointer to a slice
+func decode(f *zip.File, key string, v interface{}) error {
+	var entries []map[string]json.RawMessage
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	if i := bytes.IndexByte(b, '='); i >= 0 && !strings.HasPrefix(strings.TrimSpace(string(b)), "[") {
+		b = b[i+1:]
+	}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return errors.New(f.Name + ": " + err.Error())
+	}
+	values := make([]json.RawMessage, 0, len(entries))
+	for _, entry := range entries {
+		if value, ok := entry[key]; ok {
+			values = append(values, value)
+		}
+	}
+	b, err = json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,197 @@
+package bluesky
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jdevoo/nucoll/util"
+)
+
+const (
+	// PublicService serves app.bsky.* queries without authentication
+	PublicService = "https://public.api.bsky.app"
+	// DefaultService hosts accounts and proxies authenticated queries
+	DefaultService = "https://bsky.social"
+)
+
+// Config stores an optional app password for authenticated access
+// Service is the host queried, the PDS of the account when authenticated
+type Config struct {
+	Identifier  string `json:"identifier"`
+	AppPassword string `json:"app_password"`
+	Service     string `json:"service"`
+}
+
+// NucollTransport holds the config, the session and the structure to deal with throttling
+type NucollTransport struct {
+	Config     *Config
+	Transport  http.RoundTripper
+	accessJwt  string
+	refreshJwt string
+}
+
+// APIError holds the XRPC error name and message
+type APIError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// session holds the tokens returned by createSession and refreshSession
+type session struct {
+	AccessJwt  string `json:"accessJwt"`
+	RefreshJwt string `json:"refreshJwt"`
+	Did        string `json:"did"`
+	Handle     string `json:"handle"`
+}
+
+// rateLimit headers sent with every response
+// https://docs.bsky.app/docs/advanced-guides/rate-limits
+var rateLimit = util.RateLimit{Remaining: "ratelimit-remaining", Reset: "ratelimit-reset"}
+
+// RoundTrip sends req with the session token, which only goes to the configured service
+func (t *NucollTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.accessJwt != "" && req.Header.Get("Authorization") == "" && req.URL.Host == serviceURL(t.Config.Service).Host {
+		req.Header.Set("Authorization", "Bearer "+t.accessJwt)
+	}
+	return rateLimit.Send(t.Transport, req, func(res *http.Response) (bool, error) {
+		return t.renew(req, res)
+	})
+}
+
+// renew refreshes an expired session so that req is sent again, other rejections are reported with their XRPC error
+func (t *NucollTransport) renew(req *http.Request, res *http.Response) (bool, error) {
+	if res.StatusCode != http.StatusBadRequest && res.StatusCode != http.StatusUnauthorized {
+		return false, nil
+	}
+	var ae APIError
+	json.NewDecoder(res.Body).Decode(&ae)
+	if ae.Error != "ExpiredToken" || t.refreshJwt == "" || req.Body != nil {
+		return false, fmt.Errorf("%w: %s %s", &util.StatusError{StatusCode: res.StatusCode, Status: res.Status}, ae.Error, ae.Message)
+	}
+	// refresh tokens expire too in which case a new session is created
+	if err := t.refresh(); err != nil {
+		if err = t.login(); err != nil {
+			return false, err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+t.accessJwt)
+	return true, nil
+}
+
+// serviceURL returns the base URL of a service given as host or URL
+func serviceURL(service string) *url.URL {
+	if service == "" {
+		service = PublicService
+	}
+	if !strings.Contains(service, "://") {
+		service = "https://" + service
+	}
+	u, err := url.Parse(strings.TrimRight(service, "/"))
+	if err != nil {
+		return &url.URL{}
+	}
+	return u
+}
+
+// post sends a procedure call and decodes the session it returns
+func (t *NucollTransport) post(nsid string, bearer string, body interface{}) (session, error) {
+	var s session
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/xrpc/%s", serviceURL(t.Config.Service), nsid), nil)
+	if body != nil {
+		payload, _ := json.Marshal(body)
+		req, _ = http.NewRequest("POST", req.URL.String(), bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	res, err := t.RoundTrip(req)
+	if err != nil {
+		return s, err
+	}
+	defer res.Body.Close()
+	err = json.NewDecoder(res.Body).Decode(&s)
+	return s, err
+}
+
+// login creates a session using the app password
+func (t *NucollTransport) login() error {
+	s, err := t.post("com.atproto.server.createSession", "", map[string]string{
+		"identifier": t.Config.Identifier,
+		"password":   t.Config.AppPassword,
+	})
+	if err != nil {
+		return err
+	}
+	t.accessJwt, t.refreshJwt = s.AccessJwt, s.RefreshJwt
+	return nil
+}
+
+// refresh exchanges the refresh token for a new access token
+func (t *NucollTransport) refresh() error {
+	s, err := t.post("com.atproto.server.refreshSession", t.refreshJwt, nil)
+	if err != nil {
+		return err
+	}
+	t.accessJwt, t.refreshJwt = s.AccessJwt, s.RefreshJwt
+	return nil
+}
+
+// NewClient returns a client for the configured service along with its base URL
+// queries are anonymous unless an app password was provided during setup
+func NewClient() (*http.Client, string, error) {
+	t := &NucollTransport{Config: &Config{}}
+	t.Transport = util.Transport()
+
+	config, err := util.ReadConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	if err = config.Section("bluesky", t.Config); err != nil {
+		return nil, "", err
+	}
+	if t.Config.Service == "" {
+		if !util.Interactive() {
+			return nil, "", errors.New("no Bluesky setup found and stdin is not a terminal: run once interactively and pass -config with the resulting file")
+		}
+		fmt.Println(`
+===BLUESKY API SETUP=============================================
+Public data is available without an account. Search requires an
+app password created under Settings > Privacy and security.
+Leave the handle empty to use the public API.`)
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Print("What is the handle? ")
+		identifier, _ := reader.ReadString('\n')
+		t.Config.Identifier = strings.TrimSpace(identifier)
+		t.Config.Service = PublicService
+		if t.Config.Identifier != "" {
+			password, err := util.ReadSecret("What is the app password? ")
+			if err != nil {
+				return nil, "", err
+			}
+			t.Config.AppPassword = strings.TrimSpace(password)
+			t.Config.Service = DefaultService
+		}
+		if err = config.SetSection("bluesky", t.Config); err != nil {
+			return nil, "", err
+		}
+		if err = util.WriteConfig(config); err != nil {
+			return nil, "", err
+		}
+	}
+	if t.Config.Identifier != "" {
+		if err = t.login(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return &http.Client{Transport: t}, serviceURL(t.Config.Service).String(), nil
+}
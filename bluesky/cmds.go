@@ -0,0 +1,621 @@
+package bluesky
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/util"
+)
+
+func init() {
+	sns.Register(sns.Backend{
+		Name:        "bluesky",
+		Description: "Bluesky and the AT Protocol, DIDs as IDs",
+		New:         func() sns.SocialNetworkService { return Bluesky{} },
+	})
+}
+
+// Bluesky client with custom RoundTripper to handle sessions and throttling
+// Service is the base URL of the XRPC host
+type Bluesky struct {
+	Client  *http.Client
+	Service string
+}
+
+// Profile as returned by app.bsky.actor and app.bsky.graph queries
+// counts are only populated by app.bsky.actor.getProfile(s)
+type Profile struct {
+	Did            string `json:"did"`
+	Handle         string `json:"handle"`
+	Avatar         string `json:"avatar"`
+	FollowersCount int    `json:"followersCount"`
+	FollowsCount   int    `json:"followsCount"`
+	PostsCount     int    `json:"postsCount"`
+	CreatedAt      string `json:"createdAt"`
+	Associated     struct {
+		Lists int `json:"lists"`
+	} `json:"associated"`
+	Verification struct {
+		VerifiedStatus string `json:"verifiedStatus"`
+	} `json:"verification"`
+	Labels []struct {
+		Val string `json:"val"`
+	} `json:"labels"`
+}
+
+// Post as returned by app.bsky.feed queries
+type Post struct {
+	URI    string  `json:"uri"`
+	Author Profile `json:"author"`
+	Record struct {
+		Text      string `json:"text"`
+		CreatedAt string `json:"createdAt"`
+		Reply     struct {
+			Parent struct {
+				URI string `json:"uri"`
+			} `json:"parent"`
+		} `json:"reply"`
+	} `json:"record"`
+	RepostCount int `json:"repostCount"`
+	LikeCount   int `json:"likeCount"`
+}
+
+// FeedItem wraps posts returned by feed queries with reply context and repost reason
+type FeedItem struct {
+	Post  Post `json:"post"`
+	Reply *struct {
+		Parent Post `json:"parent"`
+	} `json:"reply"`
+	Reason *struct {
+		By Profile `json:"by"`
+	} `json:"reason"`
+}
+
+// UserObject mirrors the twitter user columns with the DID as identifier
+// ListedCount holds the number of lists created by the account
+type UserObject struct {
+	ID              string
+	ScreenName      string
+	Protected       bool
+	Verified        bool
+	FriendsCount    int
+	FollowersCount  int
+	ListedCount     int
+	StatusesCount   int
+	CreatedAt       string
+	URL             string
+	ProfileImageURL string
+	Location        string
+	Relation        string
+	Subject         string
+}
+
+// PostObject mirrors the twitter tweet columns with AT URIs as post identifiers
+type PostObject struct {
+	CreatedAt string
+	ID        string
+	User      struct {
+		ScreenName string
+	}
+	Text                string
+	InReplyToTweet      string
+	InReplyToUser       string
+	InReplyToScreenName string
+	RetweetCount        int
+	FavoriteCount       int
+}
+
+// handleRE matches domain handles such as @alice.bsky.social in query files
+var handleRE = regexp.MustCompile(`@([a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)+)`)
+
+// authority returns the DID or handle of an AT URI such as at://did:plc:xyz/app.bsky.feed.post/3k
+func authority(uri string) string {
+	return strings.SplitN(strings.TrimPrefix(uri, "at://"), "/", 2)[0]
+}
+
+// connect creates the client on first use
+func (ns *Bluesky) connect() error {
+	var err error
+
+	if ns.Client == nil {
+		if ns.Client, ns.Service, err = NewClient(); err != nil {
+			return fmt.Errorf("failed to create Bluesky client: %w", err)
+		}
+	}
+	return nil
+}
+
+// get decodes the JSON response of an XRPC query into v
+func (ns Bluesky) get(ctx context.Context, nsid string, params url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/xrpc/%s?%s", ns.Service, nsid, params.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	res, err := ns.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// ids returns an array of DIDs for the follows or followers of actor
+func (ns Bluesky) ids(ctx context.Context, relation string, actor string) ([]string, error) {
+	var ids []string
+	var nsid string
+
+	if relation == "followers" {
+		nsid = "app.bsky.graph.getFollowers"
+	} else {
+		nsid = "app.bsky.graph.getFollows"
+	}
+	params := url.Values{"actor": {actor}, "limit": {"100"}}
+	for cursor := "start"; cursor != ""; {
+		var result struct {
+			Follows   []Profile `json:"follows"`
+			Followers []Profile `json:"followers"`
+			Cursor    string    `json:"cursor"`
+		}
+		if err := ns.get(ctx, nsid, params, &result); err != nil {
+			return nil, err
+		}
+		for _, p := range append(result.Follows, result.Followers...) {
+			ids = append(ids, p.Did)
+		}
+		cursor = result.Cursor
+		params.Set("cursor", cursor)
+	}
+	return ids, nil
+}
+
+// profiles returns hydrated profiles for up to 25 DIDs or handles
+func (ns Bluesky) profiles(ctx context.Context, actors []string) ([]Profile, error) {
+	var result struct {
+		Profiles []Profile `json:"profiles"`
+	}
+
+	err := ns.get(ctx, "app.bsky.actor.getProfiles", url.Values{"actors": actors}, &result)
+	return result.Profiles, err
+}
+
+// show returns a hydrated profile for a given DID or handle
+func (ns Bluesky) show(ctx context.Context, actor string) (Profile, error) {
+	var result Profile
+
+	err := ns.get(ctx, "app.bsky.actor.getProfile", url.Values{"actor": {actor}}, &result)
+	return result, err
+}
+
+// list returns the AT URI of a list given as URI, name or record key of a list created by actor
+func (ns Bluesky) list(ctx context.Context, name string, actor string) (string, error) {
+	if strings.HasPrefix(name, "at://") {
+		return name, nil
+	}
+	params := url.Values{"actor": {actor}, "limit": {"100"}}
+	for cursor := "start"; cursor != ""; {
+		var result struct {
+			Lists []struct {
+				URI  string `json:"uri"`
+				Name string `json:"name"`
+			} `json:"lists"`
+			Cursor string `json:"cursor"`
+		}
+		if err := ns.get(ctx, "app.bsky.graph.getLists", params, &result); err != nil {
+			return "", err
+		}
+		for _, l := range result.Lists {
+			if strings.EqualFold(l.Name, name) || strings.HasSuffix(l.URI, "/"+name) {
+				return l.URI, nil
+			}
+		}
+		cursor = result.Cursor
+		params.Set("cursor", cursor)
+	}
+	return "", fmt.Errorf("list %q not found for %s", name, actor)
+}
+
+// members returns an array of DIDs belonging to a list
+func (ns Bluesky) members(ctx context.Context, list string, actor string) ([]string, error) {
+	var ids []string
+
+	uri, err := ns.list(ctx, list, actor)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{"list": {uri}, "limit": {"100"}}
+	for cursor := "start"; cursor != ""; {
+		var result struct {
+			Items []struct {
+				Subject Profile `json:"subject"`
+			} `json:"items"`
+			Cursor string `json:"cursor"`
+		}
+		if err := ns.get(ctx, "app.bsky.graph.getList", params, &result); err != nil {
+			return nil, err
+		}
+		for _, item := range result.Items {
+			ids = append(ids, item.Subject.Did)
+		}
+		cursor = result.Cursor
+		params.Set("cursor", cursor)
+	}
+	return ids, nil
+}
+
+// repostersOf returns DIDs of followers of actor who reposted one of its last maxCount posts
+func (ns Bluesky) repostersOf(ctx context.Context, actor string, maxCount int) ([]string, error) {
+	var ids []string
+
+	followers, err := ns.ids(ctx, "followers", actor)
+	if err != nil {
+		return nil, err
+	}
+	isFollower := make(map[string]bool)
+	for _, id := range followers {
+		isFollower[id] = true
+	}
+	seen := make(map[string]bool)
+	params := url.Values{"actor": {actor}, "limit": {"100"}, "filter": {"posts_no_replies"}}
+	for c, cursor := 0, "start"; cursor != "" && c < maxCount; {
+		var result struct {
+			Feed   []FeedItem `json:"feed"`
+			Cursor string     `json:"cursor"`
+		}
+		if err := ns.get(ctx, "app.bsky.feed.getAuthorFeed", params, &result); err != nil {
+			return nil, err
+		}
+		for _, item := range result.Feed {
+			if item.Reason != nil || item.Post.RepostCount == 0 {
+				continue
+			}
+			reposts := url.Values{"uri": {item.Post.URI}, "limit": {"100"}}
+			for rc := "start"; rc != ""; {
+				var by struct {
+					RepostedBy []Profile `json:"repostedBy"`
+					Cursor     string    `json:"cursor"`
+				}
+				if err := ns.get(ctx, "app.bsky.feed.getRepostedBy", reposts, &by); err != nil {
+					return nil, err
+				}
+				for _, p := range by.RepostedBy {
+					if isFollower[p.Did] && !seen[p.Did] {
+						seen[p.Did] = true
+						ids = append(ids, p.Did)
+					}
+				}
+				rc = by.Cursor
+				reposts.Set("cursor", rc)
+			}
+		}
+		log.Printf("processed %d posts from %s\n", len(result.Feed), actor)
+		c += len(result.Feed)
+		cursor = result.Cursor
+		params.Set("cursor", cursor)
+	}
+	return ids, nil
+}
+
+// replies returns the direct replies to the post at uri along with the post itself
+func (ns Bluesky) replies(ctx context.Context, uri string) (Post, []FeedItem, error) {
+	var result struct {
+		Thread struct {
+			Post    Post `json:"post"`
+			Replies []struct {
+				Post Post `json:"post"`
+			} `json:"replies"`
+		} `json:"thread"`
+	}
+
+	params := url.Values{"uri": {uri}, "depth": {"1"}, "parentHeight": {"0"}}
+	if err := ns.get(ctx, "app.bsky.feed.getPostThread", params, &result); err != nil {
+		return Post{}, nil, err
+	}
+	items := make([]FeedItem, len(result.Thread.Replies))
+	for i, r := range result.Thread.Replies {
+		items[i].Post = r.Post
+	}
+	return result.Thread.Post, items, nil
+}
+
+// user maps a hydrated profile to a nucoll user object
+func user(p Profile, relation string, subject string) UserObject {
+	u := UserObject{
+		ID:              p.Did,
+		ScreenName:      p.Handle,
+		Verified:        p.Verification.VerifiedStatus == "valid",
+		FriendsCount:    p.FollowsCount,
+		FollowersCount:  p.FollowersCount,
+		ListedCount:     p.Associated.Lists,
+		StatusesCount:   p.PostsCount,
+		CreatedAt:       util.RubyDate(p.CreatedAt),
+		URL:             "https://bsky.app/profile/" + p.Handle,
+		ProfileImageURL: p.Avatar,
+		Relation:        relation,
+		Subject:         subject,
+	}
+	// accounts asking not to be shown to logged-out users
+	for _, l := range p.Labels {
+		if l.Val == "!no-unauthenticated" {
+			u.Protected = true
+		}
+	}
+	return u
+}
+
+// posts maps feed items to nucoll post objects, reposts are attributed to the reposting account
+func posts(items []FeedItem) []PostObject {
+	result := make([]PostObject, len(items))
+	for i, item := range items {
+		p := item.Post
+		result[i].CreatedAt = util.RubyDate(p.Record.CreatedAt)
+		result[i].ID = p.URI
+		result[i].User.ScreenName = p.Author.Handle
+		result[i].Text = p.Record.Text
+		if item.Reason != nil {
+			result[i].User.ScreenName = item.Reason.By.Handle
+			result[i].Text = fmt.Sprintf("RT @%s: %s", p.Author.Handle, p.Record.Text)
+		}
+		if parent := p.Record.Reply.Parent.URI; parent != "" {
+			result[i].InReplyToTweet = parent
+			result[i].InReplyToUser = authority(parent)
+			if item.Reply != nil {
+				result[i].InReplyToScreenName = item.Reply.Parent.Author.Handle
+			}
+		}
+		result[i].RetweetCount = p.RepostCount
+		result[i].FavoriteCount = p.LikeCount
+	}
+	return result
+}
+
+// Init supports retrieve handles from: list membership, a query file, followers who repost or a follows/followers relationship
+func (ns Bluesky) Init(ctx context.Context, opts sns.InitOptions, args []string) (string, error) {
+	var err error
+	var ids []string
+	var relation string
+	var filename string
+
+	if err = ns.connect(); err != nil {
+		return "", err
+	}
+
+	if opts.Followers {
+		relation = "followers"
+	} else {
+		relation = "friends"
+	}
+
+	switch {
+	case opts.List != "":
+		// list members use case
+		ids, err = ns.members(ctx, opts.List, args[0])
+		relation = opts.List
+	case opts.Query:
+		// query search or manually created query file
+		ids, err = util.HandleReader(args[0], opts.NoMention, handleRE)
+	case opts.MaxPostCount > 0:
+		// followers who repost posts by this handle
+		ids, err = ns.repostersOf(ctx, args[0], opts.MaxPostCount)
+		relation = "reposter"
+	default:
+		// basic relation use case
+		ids, err = ns.ids(ctx, relation, args[0])
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// populate a hydrated array of user objects based on array of DIDs
+	for page, width := 0, 25; page*width < len(ids); page++ {
+		end := (page + 1) * width
+		if end > len(ids) {
+			end = len(ids)
+		}
+		profiles, err := ns.profiles(ctx, ids[page*width:end])
+		if err != nil {
+			return filename, fmt.Errorf("failed to use Bluesky client: %w", err)
+		}
+		result := make([]UserObject, len(profiles))
+		for i, p := range profiles {
+			result[i] = user(p, relation, args[0])
+			// ignore errors on downloads
+			if opts.Images && p.Avatar != "" {
+				util.DownloadImage(ctx, p.Did, p.Avatar)
+			}
+		}
+		filename, err = util.CSVWriter(args[0], util.DatExt, page > 0, result)
+		if err != nil {
+			return filename, fmt.Errorf("failed to write file: %w", err)
+		}
+		log.Printf("processed %d starting from %s\n", end-page*width, ids[page*width])
+	}
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// Fetch retrieves second-degree follows from handles collected with Init
+func (ns Bluesky) Fetch(ctx context.Context, opts sns.FetchOptions, args []string) error {
+	var err error
+
+	if err = ns.connect(); err != nil {
+		return err
+	}
+
+	data := []UserObject{}
+	if err = util.CSVReader(args[0], util.DatExt, &data); err != nil {
+		return err
+	}
+	for _, user := range data {
+		// skip if file exists and flag to force call not set
+		if !opts.Force && util.FdatExists(user.ID) {
+			continue
+		}
+		if user.FriendsCount > opts.MaxFriends {
+			log.Printf("skipping %s (%d follows)\n", user.ScreenName, user.FriendsCount)
+			continue
+		}
+		ids, err := ns.ids(ctx, "follows", user.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := util.FdatWriter(user.ID, ids); err != nil {
+			return fmt.Errorf("failed to write follows file: %w", err)
+		}
+		log.Printf("processed %s\n", user.ScreenName)
+	}
+	return nil
+}
+
+// Edgelist constructs the network of who follows whom among handles returned by Init
+func (ns Bluesky) Edgelist(ctx context.Context, opts sns.EdgelistOptions, args []string) (string, error) {
+	var cols = []string{
+		"ID",
+		"ScreenName",
+		"Protected",
+		"Verified",
+		"FriendsCount",
+		"FollowersCount",
+		"ListedCount",
+		"StatusesCount",
+		"CreatedAt",
+		"ProfileImageURL",
+		"Relation",
+		"Subject",
+	}
+	var filename string
+	var err error
+
+	data := []UserObject{}
+	for _, handle := range args {
+		if err = util.CSVReader(handle, util.DatExt, &data); err != nil {
+			return "", err
+		}
+		if opts.Ego {
+			if err = ns.connect(); err != nil {
+				return "", err
+			}
+			self, err := ns.show(ctx, handle)
+			if err != nil {
+				return "", fmt.Errorf("failed to retrieve handle details: %w", err)
+			}
+			data = append(data, user(self, "", ""))
+		}
+	}
+	// call GMLWriter using ScreenName as label for nodes
+	if filename, err = util.GMLWriter(args, data, opts.Missing, cols, "ScreenName"); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// Posts retrieves posts from a search query, a list feed, replies to a given post or from a handle
+// the post is given as AT URI or as record key of a post by the handle
+func (ns Bluesky) Posts(ctx context.Context, opts sns.PostsOptions, args []string) (string, error) {
+	var err error
+	var nsid string
+	var params url.Values
+	var filename string
+
+	if err = ns.connect(); err != nil {
+		return "", err
+	}
+
+	switch {
+	case opts.Query:
+		nsid, params = "app.bsky.feed.searchPosts", url.Values{"q": {args[0]}, "limit": {"100"}, "sort": {"latest"}}
+	case opts.List != "":
+		uri, err := ns.list(ctx, opts.List, args[0])
+		if err != nil {
+			return "", fmt.Errorf("failed to retrieve list: %w", err)
+		}
+		nsid, params = "app.bsky.feed.getListFeed", url.Values{"list": {uri}, "limit": {"100"}}
+	case opts.PostID != "":
+		// threads are not paginated so replies are written at once
+		uri := opts.PostID
+		if !strings.HasPrefix(uri, "at://") {
+			uri = fmt.Sprintf("at://%s/app.bsky.feed.post/%s", args[0], uri)
+		}
+		parent, items, err := ns.replies(ctx, uri)
+		if err != nil {
+			return "", fmt.Errorf("failed to use Bluesky client: %w", err)
+		}
+		result := posts(items)
+		for i := range result {
+			result[i].InReplyToScreenName = parent.Author.Handle
+		}
+		if filename, err = util.CSVWriter(args[0], util.QueryExt, false, result); err != nil {
+			return filename, fmt.Errorf("failed to write posts: %w", err)
+		}
+		log.Printf("%s created\n", filename)
+		log.Printf("processed %d posts\n", len(result))
+		return filename, nil
+	default:
+		nsid, params = "app.bsky.feed.getAuthorFeed", url.Values{"actor": {args[0]}, "limit": {"100"}}
+	}
+
+	for page, cursor := 0, "start"; cursor != ""; page++ {
+		var result struct {
+			Feed   []FeedItem `json:"feed"`
+			Posts  []Post     `json:"posts"`
+			Cursor string     `json:"cursor"`
+		}
+		if err = ns.get(ctx, nsid, params, &result); err != nil {
+			return filename, fmt.Errorf("failed to use Bluesky client: %w", err)
+		}
+		// search returns posts without the feed wrapper
+		for _, p := range result.Posts {
+			result.Feed = append(result.Feed, FeedItem{Post: p})
+		}
+		if len(result.Feed) == 0 {
+			break
+		}
+		filename, err = util.CSVWriter(args[0], util.QueryExt, page > 0, posts(result.Feed))
+		if page == 0 {
+			log.Printf("%s created\n", filename)
+		}
+		if err != nil {
+			return filename, fmt.Errorf("failed to write posts: %w", err)
+		}
+		log.Printf("processed %d posts\n", len(result.Feed))
+		cursor = result.Cursor
+		params.Set("cursor", cursor)
+	}
+	return filename, nil
+}
+
+// Resolve converts handles to DIDs and vice versa along with basic stats
+func (ns Bluesky) Resolve(ctx context.Context, args []string) ([]sns.Profile, error) {
+	var result []sns.Profile
+
+	if err := ns.connect(); err != nil {
+		return nil, err
+	}
+
+	for _, handle := range args {
+		p, err := ns.show(ctx, handle)
+		if err != nil {
+			return result, fmt.Errorf("failed to retrieve handle details: %w", err)
+		}
+		result = append(result, sns.Profile{
+			Handle:     handle,
+			ID:         p.Did,
+			ScreenName: p.Handle,
+			Counts: []sns.Count{
+				{Value: p.FollowsCount, Label: "follows"},
+				{Value: p.FollowersCount, Label: "followers"},
+				{Value: p.Associated.Lists, Label: "lists"},
+				{Value: p.PostsCount, Label: "posts"},
+			},
+		})
+	}
+	return result, nil
+}
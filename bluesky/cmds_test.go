@@ -0,0 +1,121 @@
+package bluesky
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/util"
+	"github.com/jdevoo/nucoll/util/utiltest"
+)
+
+// newAppView stands in for the public API where alice follows bob and carol
+// and bob follows carol; follows are served in pages of one profile
+func newAppView() *httptest.Server {
+	profiles := map[string]string{
+		"alice.test":    `{"did":"did:plc:alice","handle":"alice.test","followsCount":2}`,
+		"did:plc:alice": `{"did":"did:plc:alice","handle":"alice.test","followsCount":2}`,
+		"did:plc:bob":   `{"did":"did:plc:bob","handle":"bob.test","followsCount":1,"followersCount":1,"createdAt":"2023-04-01T10:00:00.000Z"}`,
+		"did:plc:carol": `{"did":"did:plc:carol","handle":"carol.test","followersCount":2,"verification":{"verifiedStatus":"valid"},"labels":[{"val":"!no-unauthenticated"}]}`,
+	}
+	follows := map[string][]string{"alice.test": {"did:plc:bob", "did:plc:carol"}, "did:plc:bob": {"did:plc:carol"}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/xrpc/app.bsky.graph.getFollows", func(w http.ResponseWriter, r *http.Request) {
+		dids := follows[r.URL.Query().Get("actor")]
+		page := 0
+		fmt.Sscan(r.URL.Query().Get("cursor"), &page)
+		cursor := ""
+		if page+1 < len(dids) {
+			cursor = fmt.Sprint(page + 1)
+		}
+		if page < len(dids) {
+			fmt.Fprintf(w, `{"follows":[%s],"cursor":"%s"}`, profiles[dids[page]], cursor)
+		} else {
+			fmt.Fprint(w, `{"follows":[]}`)
+		}
+	})
+	mux.HandleFunc("/xrpc/app.bsky.actor.getProfiles", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"profiles":[`)
+		for i, actor := range r.URL.Query()["actors"] {
+			if i > 0 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprint(w, profiles[actor])
+		}
+		fmt.Fprint(w, `]}`)
+	})
+	mux.HandleFunc("/xrpc/app.bsky.feed.getPostThread", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("uri") != "at://alice.test/app.bsky.feed.post/3k" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"thread":{"post":{"uri":"at://did:plc:alice/app.bsky.feed.post/3k","author":{"handle":"alice.test"}},`+
+			`"replies":[{"post":{"uri":"at://did:plc:bob/app.bsky.feed.post/3l","author":{"handle":"bob.test"},`+
+			`"record":{"text":"hi alice","reply":{"parent":{"uri":"at://did:plc:alice/app.bsky.feed.post/3k"}}}}}]}}`)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestInitFetchPosts(t *testing.T) {
+	srv := newAppView()
+	defer srv.Close()
+	utiltest.InWorkspace(t)
+
+	ns := Bluesky{Client: srv.Client(), Service: srv.URL}
+	ctx := context.Background()
+	if _, err := ns.Init(ctx, sns.InitOptions{}, []string{"alice.test"}); err != nil {
+		t.Fatal(err)
+	}
+	data := []UserObject{}
+	if err := util.CSVReader("alice.test", util.DatExt, &data); err != nil {
+		t.Fatal(err)
+	}
+	actual := []string{}
+	for _, u := range data {
+		actual = append(actual, fmt.Sprintf("%s %s %t %t %s", u.ID, u.ScreenName, u.Protected, u.Verified, u.CreatedAt))
+	}
+	expected := []string{"did:plc:bob bob.test false false Sat Apr 01 10:00:00 +0000 2023", "did:plc:carol carol.test true true "}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Init: expected %v, actual %v", expected, actual)
+	}
+
+	if err := ns.Fetch(ctx, sns.FetchOptions{MaxFriends: 5000}, []string{"alice.test"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(util.WorkspacePath(util.FdatDir + "/did_plc_bob" + util.FdatExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "did:plc:carol\n" {
+		t.Fatalf("Fetch: expected did:plc:carol, actual %q", b)
+	}
+
+	if _, err := ns.Posts(ctx, sns.PostsOptions{PostID: "3k"}, []string{"alice.test"}); err != nil {
+		t.Fatal(err)
+	}
+	posts := []PostObject{}
+	if err := util.CSVReader("alice.test", util.QueryExt, &posts); err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 || posts[0].ID != "at://did:plc:bob/app.bsky.feed.post/3l" || posts[0].InReplyToScreenName != "alice.test" {
+		t.Fatalf("Posts: unexpected %v", posts)
+	}
+}
+
+func TestNewClientHeadless(t *testing.T) {
+	if util.Interactive() {
+		t.Skip("stdin is a terminal")
+	}
+	util.ConfigFlag = filepath.Join(t.TempDir(), "nucoll.json")
+	defer func() { util.ConfigFlag = "" }()
+	if _, _, err := NewClient(); err == nil || !strings.Contains(err.Error(), "not a terminal") {
+		t.Errorf("expected setup without a terminal to fail, actual %v", err)
+	}
+}
@@ -1,24 +1,22 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 
-	"github.com/jdevoo/nucoll/twitter"
+	"github.com/jdevoo/nucoll/sns"
 	"github.com/jdevoo/nucoll/util"
 )
 
-// SocialNetworkService defines the interface for services such as Twitter
-type SocialNetworkService interface {
-	Init(followersFlag bool, maxPostCount int, queryFlag bool, nomentionFlag bool, list string, imageFlag bool, args []string)
-	Fetch(forceFlag bool, fetchCount int, args []string)
-	Edgelist(egoFlag bool, missingFlag bool, args []string)
-	Posts(queryFlag bool, list string, postID uint64, args []string)
-	Resolve(args []string)
-}
-
 var (
 	version      string // set by go tool
 	golang       string // set by go tool
@@ -26,11 +24,25 @@ var (
 	initMembers  string
 	maxPostCount int
 	fetchCount   int
+	fetchWorkers int
 	postsList    string
-	postsPostID  uint64
+	postsPostID  string
+	postsMaxID   string
 
 	helpFlag    = flag.Bool("h", false, "show this help message and exit")
 	versionFlag = flag.Bool("v", false, "print version and exit")
+	networkFlag = flag.String("n", sns.Default, "social `network`, see Networks below")
+	urlFlag     = flag.String("u", "", "API base `url` of the network, e.g. a local stand-in server")
+	authFlag    = flag.String("auth", "app", "Twitter authentication `mode`: app (bearer token) or user (OAuth 1.0a sign-in)")
+	recordFlag  = flag.String("record", "", "write API requests and responses to `dir` with credentials redacted")
+	replayFlag  = flag.String("replay", "", "serve API responses recorded in `dir` instead of calling the network")
+	noCacheFlag = flag.Bool("no-cache", false, "bypass the response cache kept in the cache directory")
+	configFlag  = flag.String("config", "", "read credentials and settings from `path` instead of .nucoll in the home directory")
+	profileFlag = flag.String("profile", "", "use the credentials and settings of profile `name`, see the config command")
+	auditFlag   = flag.String("audit", "", "append a JSON Lines record of each API request to `file`")
+	timeoutFlag = flag.Duration("timeout", util.ReadTimeout, "give up on API requests left without response for `duration`")
+	dirFlag     = flag.String("C", "", "read and write collection files in `workspace` instead of the current directory (env NUCOLL_WORKSPACE)")
+	fdatFlag    = flag.String("fdat", "", "keep friends files in `dir` shared by several workspaces (env NUCOLL_FDAT_DIR)")
 
 	initCommand       = flag.NewFlagSet("init", flag.ExitOnError)
 	initFollowersFlag = initCommand.Bool("o", false, "retrieve followers (default friends)")
@@ -47,13 +59,17 @@ var (
 
 	resolveCommand = flag.NewFlagSet("resolve", flag.ExitOnError)
 
+	auditCommand = flag.NewFlagSet("audit", flag.ExitOnError)
+
+	configCommand = flag.NewFlagSet("config", flag.ExitOnError)
+
 	postsCommand   = flag.NewFlagSet("tweets", flag.ExitOnError)
 	postsQueryFlag = postsCommand.Bool("q", false, "argument is a quoted query string (default screen_name)")
 
 	// Usage overrides PrintDefaults
 	Usage = func() {
-		fmt.Println("Usage: " + filepath.Base(os.Args[0]) + " [-h] [-v]")
-		fmt.Println("              {init,fetch,edgelist,tweets,resolve} ...")
+		fmt.Println("Usage: " + filepath.Base(os.Args[0]) + " [-h] [-v] [-n network] [-C workspace] [-fdat dir] [-config path] [-profile name] [-u url] [-auth mode] [-record dir | -replay dir] [-no-cache] [-timeout duration] [-audit file]")
+		fmt.Println("              {init,fetch,edgelist,tweets,resolve,audit,config} ...")
 		fmt.Println()
 		fmt.Println("New Collection Tool")
 		fmt.Println()
@@ -63,6 +79,13 @@ var (
 		fmt.Println("  edgelist     generate graph in GML format")
 		fmt.Println("  tweets       retrieve tweets")
 		fmt.Println("  resolve      retrieve user_id for screen_name or vice versa")
+		fmt.Println("  audit        summarize API requests recorded in the audit log")
+		fmt.Println("  config       list, add, test, invalidate, set default and remove profiles, encrypt the config file")
+		fmt.Println()
+		fmt.Println("Networks:")
+		for _, b := range sns.Backends() {
+			fmt.Printf("  %-12s %s\n", b.Name, b.Description)
+		}
 		fmt.Println()
 		fmt.Println("Optional arguments:")
 		flag.PrintDefaults()
@@ -77,8 +100,9 @@ func init() {
 		initCommand.PrintDefaults()
 	}
 	fetchCommand.IntVar(&fetchCount, "c", 5000, "skip if friends count above limit")
+	fetchCommand.IntVar(&fetchWorkers, "w", 1, "number of handles fetched in parallel (twitter, twitter2)")
 	fetchCommand.Usage = func() {
-		fmt.Println("Usage: " + filepath.Base(os.Args[0]) + " fetch [-h] [-c N] [-f] screen_name")
+		fmt.Println("Usage: " + filepath.Base(os.Args[0]) + " fetch [-h] [-c N] [-f] [-w N] screen_name")
 		fetchCommand.PrintDefaults()
 	}
 	edgelistCommand.Usage = func() {
@@ -88,17 +112,22 @@ func init() {
 	resolveCommand.Usage = func() {
 		fmt.Println("Usage: " + filepath.Base(os.Args[0]) + " resolve [-h] screen_name [screen_name...]")
 	}
+	configCommand.Usage = func() {
+		fmt.Println("Usage: " + filepath.Base(os.Args[0]) + " config [-h] {list | add name | test [name] | invalidate [name] | default name | remove name | encrypt | decrypt}")
+	}
+	auditCommand.Usage = func() {
+		fmt.Println("Usage: " + filepath.Base(os.Args[0]) + " [-audit file] audit [-h]")
+	}
 	postsCommand.StringVar(&postsList, "m", "", "extract tweets from list")
-	postsCommand.Uint64Var(&postsPostID, "p", 0, "replies to tweet id by screen_name")
+	postsCommand.StringVar(&postsPostID, "p", "", "replies to tweet id by screen_name")
+	postsCommand.StringVar(&postsMaxID, "max", "", fmt.Sprintf("resume below tweet id, appending to the %s file (twitter)", util.QueryExt))
 	postsCommand.Usage = func() {
-		fmt.Println("Usage: " + filepath.Base(os.Args[0]) + " tweets [-h] [-p id] [-m list] [-q] <screen_name | \"query\">")
+		fmt.Println("Usage: " + filepath.Base(os.Args[0]) + " tweets [-h] [-p id] [-m list] [-max id] [-q] <screen_name | \"query\">")
 		postsCommand.PrintDefaults()
 	}
 }
 
 func main() {
-	var sns SocialNetworkService
-
 	flag.Parse()
 	if *versionFlag {
 		fmt.Printf("New Collection Tool %s (%s %s)\n", version, golang, githash)
@@ -109,57 +138,321 @@ func main() {
 		os.Exit(1)
 	}
 
-	sns = twitter.Twitter{}
+	backend, ok := sns.Lookup(*networkFlag)
+	if !ok {
+		fmt.Printf("%q is not a supported network\n", *networkFlag)
+		os.Exit(1)
+	}
+	// an audit log left empty without notice would pass for a run that made no requests
+	if *auditFlag != "" && !backend.Audit && flag.Arg(0) != "audit" {
+		fmt.Printf("-audit is not supported by %s, its requests are not recorded\n", *networkFlag)
+		os.Exit(1)
+	}
+	if *recordFlag != "" && *replayFlag != "" {
+		fmt.Println("-record and -replay cannot be combined")
+		os.Exit(1)
+	}
+	util.BaseURLFlag = *urlFlag
+	util.AuthMode = *authFlag
+	util.RecordDir = *recordFlag
+	util.ReplayDir = *replayFlag
+	util.NoCache = *noCacheFlag
+	util.ReadTimeout = *timeoutFlag
+	util.AuditFlag = *auditFlag
+	util.ProfileFlag = *profileFlag
+	util.ConfigFlag = *configFlag
+	if util.Workspace = *dirFlag; util.Workspace == "" {
+		util.Workspace = os.Getenv("NUCOLL_WORKSPACE")
+	}
+	util.Network = *networkFlag
+	if util.SharedFdatDir = *fdatFlag; util.SharedFdatDir == "" {
+		util.SharedFdatDir = os.Getenv("NUCOLL_FDAT_DIR")
+	}
+	if util.Workspace != "" {
+		if err := os.MkdirAll(util.Workspace, 0755); err != nil {
+			log.Fatal(err)
+		}
+	}
+	service := backend.New()
+	// in-flight requests are canceled on the first signal, files being written are completed
+	// and a second signal stops at once
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		log.Println("interrupted, stopping (press Ctrl-C again to quit at once)")
+	}()
 
-	switch os.Args[1+flag.NFlag()] {
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
 	case "init":
-		if err := initCommand.Parse(os.Args[2:]); err == nil {
+		if err := initCommand.Parse(args); err == nil {
 			if initCommand.NArg() == 1 {
-				sns.Init(*initFollowersFlag, maxPostCount, *initQueryFlag, *initNomentionFlag, initMembers, *initImageFlag, initCommand.Args())
+				opts := sns.InitOptions{
+					Followers:    *initFollowersFlag,
+					MaxPostCount: maxPostCount,
+					Query:        *initQueryFlag,
+					NoMention:    *initNomentionFlag,
+					List:         initMembers,
+					Images:       *initImageFlag,
+				}
+				ctx := util.WithCollection(ctx, "init", initCommand.Arg(0))
+				if _, err := service.Init(ctx, opts, initCommand.Args()); err != nil {
+					fail(err)
+				}
 			} else {
 				initCommand.Usage()
 				os.Exit(1)
 			}
 		}
 	case "edgelist":
-		if err := edgelistCommand.Parse(os.Args[2:]); err == nil {
+		if err := edgelistCommand.Parse(args); err == nil {
 			if edgelistCommand.NArg() > 0 {
-				sns.Edgelist(*edgelistEgoFlag, *edgelistMissingFlag, edgelistCommand.Args())
+				opts := sns.EdgelistOptions{Ego: *edgelistEgoFlag, Missing: *edgelistMissingFlag}
+				ctx := util.WithCollection(ctx, "edgelist", strings.Join(edgelistCommand.Args(), ","))
+				if _, err := service.Edgelist(ctx, opts, edgelistCommand.Args()); err != nil {
+					fail(err)
+				}
 			} else {
 				edgelistCommand.Usage()
 				os.Exit(1)
 			}
 		}
 	case "fetch":
-		if err := fetchCommand.Parse(os.Args[2:]); err == nil {
+		if err := fetchCommand.Parse(args); err == nil {
 			if fetchCommand.NArg() == 1 {
-				sns.Fetch(*fetchForceFlag, fetchCount, fetchCommand.Args())
+				opts := sns.FetchOptions{Force: *fetchForceFlag, MaxFriends: fetchCount, Workers: fetchWorkers}
+				ctx := util.WithCollection(ctx, "fetch", fetchCommand.Arg(0))
+				if err := service.Fetch(ctx, opts, fetchCommand.Args()); err != nil {
+					fail(err)
+				}
 			} else {
 				fetchCommand.Usage()
 				os.Exit(1)
 			}
 		}
 	case "resolve":
-		if err := resolveCommand.Parse(os.Args[2:]); err == nil {
+		if err := resolveCommand.Parse(args); err == nil {
 			if resolveCommand.NArg() > 0 {
-				sns.Resolve(resolveCommand.Args())
+				ctx := util.WithCollection(ctx, "resolve", strings.Join(resolveCommand.Args(), ","))
+				profiles, err := service.Resolve(ctx, resolveCommand.Args())
+				// profiles resolved before a failure are still printed
+				for _, p := range profiles {
+					fmt.Println(p)
+				}
+				if err != nil {
+					fail(err)
+				}
 			} else {
 				resolveCommand.Usage()
 				os.Exit(1)
 			}
 		}
 	case "tweets":
-		if err := postsCommand.Parse(os.Args[2:]); err == nil {
+		if err := postsCommand.Parse(args); err == nil {
 			if postsCommand.NArg() > 0 {
-				sns.Posts(*postsQueryFlag, postsList, postsPostID, postsCommand.Args())
+				opts := sns.PostsOptions{Query: *postsQueryFlag, List: postsList, PostID: postsPostID, MaxID: postsMaxID}
+				ctx := util.WithCollection(ctx, "tweets", postsCommand.Arg(0))
+				if _, err := service.Posts(ctx, opts, postsCommand.Args()); err != nil {
+					fail(err)
+				}
 			} else {
 				postsCommand.Usage()
 				os.Exit(1)
 			}
 		}
+	case "audit":
+		if err := auditCommand.Parse(args); err == nil {
+			config, err := util.ReadConfig()
+			if err != nil {
+				log.Fatal(err)
+			}
+			path := config.AuditPath(*networkFlag)
+			if path == "" {
+				fmt.Printf("no audit log, pass -audit file or set audit_log in the %s section\n", *networkFlag)
+				os.Exit(1)
+			}
+			if err := util.AuditSummary(path, os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+		}
+	case "config":
+		if err := configCommand.Parse(args); err == nil {
+			if err := configure(ctx, service, configCommand.Args()); err != nil {
+				fail(err)
+			}
+		}
 	default:
-		fmt.Printf("%q is not a valid command\n", os.Args[1])
+		fmt.Printf("%q is not a valid command\n", flag.Arg(0))
 		os.Exit(1)
 	}
+	util.RunAtExit()
+	if stats := util.CacheStats(); stats != "" {
+		log.Println(stats)
+	}
 	os.Exit(0)
 }
+
+// fail reports err and exits, printing how to resume a run canceled by a signal
+func fail(err error) {
+	var interrupted *sns.Interrupted
+
+	util.RunAtExit()
+	if !errors.Is(err, context.Canceled) {
+		log.Fatal(err)
+	}
+	var resume []string
+	if errors.As(err, &interrupted) {
+		resume = interrupted.Resume
+	}
+	log.Printf("interrupted, resume with: %s\n", resumeCommand(resume))
+	os.Exit(130)
+}
+
+// resumeCommand returns the command line of the run with opts added to those of the command
+// options given again replace their previous value
+func resumeCommand(opts []string) string {
+	i := len(os.Args) - flag.NArg() + 1
+	args := append([]string{filepath.Base(os.Args[0])}, os.Args[1:i]...)
+	args = append(args, opts...)
+	for k := i; k < len(os.Args); k++ {
+		if replaced(os.Args[k], opts) {
+			if !strings.Contains(os.Args[k], "=") {
+				k++
+			}
+			continue
+		}
+		args = append(args, os.Args[k])
+	}
+	for k, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\"'") {
+			args[k] = strconv.Quote(a)
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// replaced reports whether arg is one of the options taking a value in opts
+func replaced(arg string, opts []string) bool {
+	name := strings.SplitN(arg, "=", 2)[0]
+	for k := 0; k < len(opts); k += 2 {
+		if name == opts[k] || name == "-"+opts[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// configure runs the config command on the profiles of the config file
+// adding a profile tests it right away so the network asks for its credentials
+func configure(ctx context.Context, service sns.SocialNetworkService, args []string) error {
+	if len(args) == 0 || !util.Exists(args[0], []string{"list", "test", "invalidate", "encrypt", "decrypt"}) && len(args) != 2 {
+		configCommand.Usage()
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "list":
+		names, selected, err := util.Profiles()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			config, err := util.ReadProfile(name)
+			if err != nil {
+				return err
+			}
+			mark := " "
+			if name == selected {
+				mark = "*"
+			}
+			fmt.Printf("%s %-12s %s\n", mark, name, config.Describe())
+		}
+	case "add":
+		if err := util.AddProfile(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("profile %s added\n", args[1])
+		if _, ok := service.(sns.Tester); !ok {
+			fmt.Printf("credentials are set up by the first command run with -profile %s\n", args[1])
+			return nil
+		}
+		return testProfile(ctx, service, args[1])
+	case "test":
+		name, err := profileArg(args)
+		if err != nil {
+			return err
+		}
+		return testProfile(ctx, service, name)
+	case "invalidate":
+		name, err := profileArg(args)
+		if err != nil {
+			return err
+		}
+		invalidator, ok := service.(sns.Invalidator)
+		if !ok {
+			return fmt.Errorf("%s cannot invalidate tokens", *networkFlag)
+		}
+		util.ProfileFlag = name
+		result, err := invalidator.Invalidate(ctx)
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+		fmt.Printf("profile %s: %s\n", name, result)
+	case "default":
+		if err := util.SetDefaultProfile(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("commands now use profile %s unless -profile is given\n", args[1])
+	case "remove":
+		if err := util.RemoveProfile(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("profile %s removed\n", args[1])
+	case "encrypt":
+		path, err := util.EncryptConfig()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s encrypted, set NUCOLL_PASSPHRASE for runs without a terminal\n", path)
+	case "decrypt":
+		path, err := util.DecryptConfig()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s stored as plain text\n", path)
+	default:
+		configCommand.Usage()
+		os.Exit(1)
+	}
+	return nil
+}
+
+// profileArg returns the profile named after the config action, else the one used by commands
+func profileArg(args []string) (string, error) {
+	if len(args) > 1 {
+		return args[1], nil
+	}
+	if util.ProfileFlag != "" {
+		return util.ProfileFlag, nil
+	}
+	_, selected, err := util.Profiles()
+	return selected, err
+}
+
+// testProfile makes a cheap authenticated call of the network with the credentials of profile name
+func testProfile(ctx context.Context, service sns.SocialNetworkService, name string) error {
+	tester, ok := service.(sns.Tester)
+	if !ok {
+		return fmt.Errorf("%s cannot test credentials", *networkFlag)
+	}
+	if _, err := util.ReadProfile(name); err != nil {
+		return err
+	}
+	util.ProfileFlag = name
+	result, err := tester.Test(ctx)
+	if err != nil {
+		return fmt.Errorf("profile %s: %w", name, err)
+	}
+	fmt.Printf("profile %s: %s\n", name, result)
+	return nil
+}
@@ -0,0 +1,84 @@
+package github
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jdevoo/nucoll/util"
+)
+
+// APIURL is the default base URL of the GitHub REST API
+const APIURL = "https://api.github.com"
+
+// Config stores an optional personal access token raising the limit from 60 to 5000 requests per hour
+type Config struct {
+	Token string `json:"token"`
+}
+
+// NucollTransport holds the config and the structure to deal with throttling
+// Host is the host of the configured base URL, the only one receiving the token
+type NucollTransport struct {
+	Config    *Config
+	Host      string
+	Transport http.RoundTripper
+}
+
+// rateLimit headers sent with every response, exhausted windows are reported with 403 or 429
+// https://docs.github.com/en/rest/using-the-rest-api/rate-limits-for-the-rest-api
+var rateLimit = util.RateLimit{Remaining: "X-RateLimit-Remaining", Reset: "X-RateLimit-Reset"}
+
+// RoundTrip sends req with the token, which only goes to the API host
+func (t *NucollTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if t.Config.Token != "" && req.URL.Host == t.Host {
+		req.Header.Set("Authorization", "Bearer "+t.Config.Token)
+	}
+	return rateLimit.Send(t.Transport, req, nil)
+}
+
+// NewClient returns a client authenticated with the stored token if any
+func NewClient() (*http.Client, error) {
+	t := &NucollTransport{Config: &Config{}}
+	t.Transport = util.Transport()
+
+	config, err := util.ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(config.BaseURL("github", APIURL))
+	if err != nil {
+		return nil, err
+	}
+	t.Host = base.Host
+	if _, ok := config.Networks["github"]; !ok {
+		// an empty token read from a closed stdin would be stored as the choice to stay anonymous
+		if !util.Interactive() {
+			return nil, errors.New("no GitHub setup found and stdin is not a terminal: run once interactively and pass -config with the resulting file")
+		}
+		fmt.Println(`
+===GITHUB API SETUP==============================================
+Anonymous requests are limited to 60 per hour. Create a personal
+access token without any scope to raise the limit to 5000...
+>>> https://github.com/settings/tokens
+Leave the token empty to continue anonymously.`)
+		fmt.Print("What is the token? ")
+		token, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		t.Config.Token = strings.TrimSpace(token)
+		if err = config.SetSection("github", t.Config); err != nil {
+			return nil, err
+		}
+		if err = util.WriteConfig(config); err != nil {
+			return nil, err
+		}
+	} else if err = config.Section("github", t.Config); err != nil {
+		return nil, err
+	}
+
+	return &http.Client{Transport: t}, nil
+}
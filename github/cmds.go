@@ -0,0 +1,574 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/util"
+)
+
+func init() {
+	sns.Register(sns.Backend{
+		Name:        "github",
+		Description: "GitHub following and stargazers, repositories as lists",
+		New:         func() sns.SocialNetworkService { return GitHub{} },
+	})
+}
+
+// GitHub client with custom RoundTripper to handle throttling
+// BaseURL defaults to the configured base URL, see APIURL
+type GitHub struct {
+	Client  *http.Client
+	BaseURL string
+}
+
+// User as returned by the users API, list endpoints only populate Login, ID and AvatarURL
+type User struct {
+	Login       string `json:"login"`
+	ID          uint64 `json:"id"`
+	Type        string `json:"type"`
+	Followers   int    `json:"followers"`
+	Following   int    `json:"following"`
+	PublicRepos int    `json:"public_repos"`
+	PublicGists int    `json:"public_gists"`
+	CreatedAt   string `json:"created_at"`
+	HTMLURL     string `json:"html_url"`
+	AvatarURL   string `json:"avatar_url"`
+	Location    string `json:"location"`
+}
+
+// Event as returned by the events API
+type Event struct {
+	ID        string                `json:"id"`
+	Type      string                `json:"type"`
+	CreatedAt string                `json:"created_at"`
+	Actor     User                  `json:"actor"`
+	Repo      struct{ Name string } `json:"repo"`
+	Payload   struct {
+		Action  string `json:"action"`
+		Issue   Issue  `json:"issue"`
+		Comment struct {
+			Body string `json:"body"`
+		} `json:"comment"`
+	} `json:"payload"`
+}
+
+// Issue as returned by the issues and search APIs, pull requests included
+type Issue struct {
+	ID        uint64 `json:"id"`
+	Number    uint64 `json:"number"`
+	Title     string `json:"title"`
+	Body      string `json:"body"`
+	CreatedAt string `json:"created_at"`
+	User      User   `json:"user"`
+	Comments  int    `json:"comments"`
+	Reactions struct {
+		TotalCount int `json:"total_count"`
+		PlusOne    int `json:"+1"`
+	} `json:"reactions"`
+}
+
+// Comment as returned by the issue comments API
+type Comment struct {
+	ID        uint64 `json:"id"`
+	Body      string `json:"body"`
+	CreatedAt string `json:"created_at"`
+	User      User   `json:"user"`
+	IssueURL  string `json:"issue_url"`
+	Reactions struct {
+		TotalCount int `json:"total_count"`
+		PlusOne    int `json:"+1"`
+	} `json:"reactions"`
+}
+
+// UserObject mirrors the twitter user columns
+// ListedCount holds public gists and StatusesCount public repositories
+type UserObject struct {
+	ID              uint64
+	ScreenName      string
+	Protected       bool
+	Verified        bool
+	FriendsCount    int
+	FollowersCount  int
+	ListedCount     int
+	StatusesCount   int
+	CreatedAt       string
+	URL             string
+	ProfileImageURL string
+	Location        string
+	Relation        string
+	Subject         string
+}
+
+// PostObject mirrors the twitter tweet columns for events, issues and comments
+// InReplyToTweet holds the issue number a comment belongs to
+type PostObject struct {
+	CreatedAt string
+	ID        uint64
+	User      struct {
+		ScreenName string
+	}
+	Text                string
+	InReplyToTweet      uint64
+	InReplyToUser       uint64
+	InReplyToScreenName string
+	RetweetCount        int
+	FavoriteCount       int
+}
+
+// handleRE matches logins which may contain hyphens
+var handleRE = regexp.MustCompile(`@([A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?)`)
+
+// repository qualifies a repository name with its owner unless given as owner/repo
+func repository(repo string, owner string) string {
+	if strings.Contains(repo, "/") {
+		return repo
+	}
+	return owner + "/" + repo
+}
+
+// connect creates the client unless one was provided
+func (ns *GitHub) connect() error {
+	var err error
+
+	if ns.BaseURL == "" {
+		config, err := util.ReadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to read config: %w", err)
+		}
+		ns.BaseURL = config.BaseURL("github", APIURL)
+	}
+	if ns.Client == nil {
+		if ns.Client, err = NewClient(); err != nil {
+			return fmt.Errorf("failed to create GitHub client: %w", err)
+		}
+	}
+	return nil
+}
+
+// get decodes a JSON response into v and returns the next page from the Link header
+func (ns GitHub) get(ctx context.Context, endpoint string, v interface{}) (string, error) {
+	if !strings.HasPrefix(endpoint, "http") {
+		endpoint = ns.BaseURL + endpoint
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := ns.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", &util.StatusError{StatusCode: res.StatusCode, Status: res.Status}
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return "", err
+	}
+	return util.NextLink(res.Header), nil
+}
+
+// users follows Link headers to collect all users of a paginated endpoint
+func (ns GitHub) users(ctx context.Context, endpoint string) ([]User, error) {
+	var users []User
+
+	for endpoint != "" {
+		var page []User
+		next, err := ns.get(ctx, endpoint, &page)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, page...)
+		endpoint = next
+	}
+	return users, nil
+}
+
+// show returns a hydrated user given a login or numeric ID
+func (ns GitHub) show(ctx context.Context, handle string) (User, error) {
+	var result User
+
+	if util.DigitsOnly(handle) {
+		_, err := ns.get(ctx, "/user/"+handle, &result)
+		return result, err
+	}
+	_, err := ns.get(ctx, "/users/"+url.PathEscape(handle), &result)
+	return result, err
+}
+
+// stargazersOf returns followers of login who starred one of its maxCount most recently pushed repositories
+func (ns GitHub) stargazersOf(ctx context.Context, login string, maxCount int) ([]User, error) {
+	var result []User
+	var repos []struct {
+		FullName        string `json:"full_name"`
+		StargazersCount int    `json:"stargazers_count"`
+	}
+
+	followers, err := ns.users(ctx, fmt.Sprintf("/users/%s/followers?per_page=100", login))
+	if err != nil {
+		return nil, err
+	}
+	isFollower := make(map[uint64]bool)
+	for _, u := range followers {
+		isFollower[u.ID] = true
+	}
+	if _, err := ns.get(ctx, fmt.Sprintf("/users/%s/repos?sort=pushed&per_page=%d", login, maxCount), &repos); err != nil {
+		return nil, err
+	}
+	seen := make(map[uint64]bool)
+	for _, repo := range repos {
+		if repo.StargazersCount == 0 {
+			continue
+		}
+		stargazers, err := ns.users(ctx, fmt.Sprintf("/repos/%s/stargazers?per_page=100", repo.FullName))
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range stargazers {
+			if isFollower[u.ID] && !seen[u.ID] {
+				seen[u.ID] = true
+				result = append(result, u)
+			}
+		}
+		log.Printf("processed %d stargazers of %s\n", len(stargazers), repo.FullName)
+	}
+	return result, nil
+}
+
+// userObject maps a hydrated user to a nucoll user object
+func userObject(u User, relation string, subject string) UserObject {
+	return UserObject{
+		ID:              u.ID,
+		ScreenName:      u.Login,
+		FriendsCount:    u.Following,
+		FollowersCount:  u.Followers,
+		ListedCount:     u.PublicGists,
+		StatusesCount:   u.PublicRepos,
+		CreatedAt:       util.RubyDate(u.CreatedAt),
+		URL:             u.HTMLURL,
+		ProfileImageURL: u.AvatarURL,
+		Location:        u.Location,
+		Relation:        relation,
+		Subject:         subject,
+	}
+}
+
+// eventObjects maps public events to nucoll post objects
+func eventObjects(events []Event) []PostObject {
+	result := make([]PostObject, len(events))
+	for i, e := range events {
+		var id uint64
+		fmt.Sscan(e.ID, &id)
+		result[i].CreatedAt = util.RubyDate(e.CreatedAt)
+		result[i].ID = id
+		result[i].User.ScreenName = e.Actor.Login
+		text := []string{e.Type, e.Repo.Name, e.Payload.Action, e.Payload.Issue.Title, e.Payload.Comment.Body}
+		result[i].Text = strings.Join(strings.Fields(strings.Join(text, " ")), " ")
+		if e.Payload.Issue.Number != 0 {
+			result[i].InReplyToTweet = e.Payload.Issue.Number
+			result[i].InReplyToUser = e.Payload.Issue.User.ID
+			result[i].InReplyToScreenName = e.Payload.Issue.User.Login
+		}
+	}
+	return result
+}
+
+// issueObjects maps issues and pull requests to nucoll post objects
+func issueObjects(issues []Issue) []PostObject {
+	result := make([]PostObject, len(issues))
+	for i, is := range issues {
+		result[i].CreatedAt = util.RubyDate(is.CreatedAt)
+		result[i].ID = is.ID
+		result[i].User.ScreenName = is.User.Login
+		result[i].Text = fmt.Sprintf("#%d %s %s", is.Number, is.Title, is.Body)
+		result[i].RetweetCount = is.Comments
+		result[i].FavoriteCount = is.Reactions.PlusOne
+	}
+	return result
+}
+
+// commentObjects maps issue comments to nucoll post objects
+func commentObjects(comments []Comment) []PostObject {
+	result := make([]PostObject, len(comments))
+	for i, c := range comments {
+		result[i].CreatedAt = util.RubyDate(c.CreatedAt)
+		result[i].ID = c.ID
+		result[i].User.ScreenName = c.User.Login
+		result[i].Text = c.Body
+		fmt.Sscan(c.IssueURL[strings.LastIndex(c.IssueURL, "/")+1:], &result[i].InReplyToTweet)
+		result[i].RetweetCount = c.Reactions.TotalCount
+		result[i].FavoriteCount = c.Reactions.PlusOne
+	}
+	return result
+}
+
+// Init supports retrieve handles from: stargazers of a repository, a query file, followers who star or a following/followers relationship
+// the repository is passed in place of a list, see InitOptions
+func (ns GitHub) Init(ctx context.Context, opts sns.InitOptions, args []string) (string, error) {
+	var users []User
+	var err error
+	var relation string
+	var filename string
+
+	if err = ns.connect(); err != nil {
+		return "", err
+	}
+
+	if opts.Followers {
+		relation = "followers"
+	} else {
+		relation = "friends"
+	}
+
+	switch {
+	case opts.List != "":
+		// stargazers of a repository in place of list members
+		users, err = ns.users(ctx, fmt.Sprintf("/repos/%s/stargazers?per_page=100", repository(opts.List, args[0])))
+		relation = opts.List
+	case opts.Query:
+		// query search or manually created query file
+		var logins []string
+		if logins, err = util.HandleReader(args[0], opts.NoMention, handleRE); err == nil {
+			for _, login := range logins {
+				users = append(users, User{Login: login})
+			}
+		}
+	case opts.MaxPostCount > 0:
+		// followers who star repositories by this handle
+		users, err = ns.stargazersOf(ctx, args[0], opts.MaxPostCount)
+		relation = "stargazer"
+	default:
+		// basic relation use case
+		path := "following"
+		if opts.Followers {
+			path = "followers"
+		}
+		users, err = ns.users(ctx, fmt.Sprintf("/users/%s/%s?per_page=100", url.PathEscape(args[0]), path))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// list endpoints do not return counts so each user is hydrated
+	result := []UserObject{}
+	for _, u := range users {
+		if err = ctx.Err(); err != nil {
+			return "", err
+		}
+		user, err := ns.show(ctx, u.Login)
+		if err != nil {
+			log.Printf("skipping %s: %s\n", u.Login, err)
+			continue
+		}
+		result = append(result, userObject(user, relation, args[0]))
+		// ignore errors on downloads
+		if opts.Images {
+			util.DownloadImage(ctx, fmt.Sprintf("%d", user.ID), user.AvatarURL)
+		}
+	}
+	filename, err = util.CSVWriter(args[0], util.DatExt, false, result)
+	if err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	log.Printf("processed %d users\n", len(result))
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// Fetch retrieves second-degree following from handles collected with Init
+func (ns GitHub) Fetch(ctx context.Context, opts sns.FetchOptions, args []string) error {
+	var err error
+
+	if err = ns.connect(); err != nil {
+		return err
+	}
+
+	data := []UserObject{}
+	if err = util.CSVReader(args[0], util.DatExt, &data); err != nil {
+		return err
+	}
+	for _, user := range data {
+		uid := fmt.Sprintf("%d", user.ID)
+		// skip if file exists and flag to force call not set
+		if !opts.Force && util.FdatExists(uid) {
+			continue
+		}
+		if user.FriendsCount > opts.MaxFriends {
+			log.Printf("skipping %s (%d following)\n", user.ScreenName, user.FriendsCount)
+			continue
+		}
+		following, err := ns.users(ctx, fmt.Sprintf("/user/%d/following?per_page=100", user.ID))
+		if err != nil {
+			// users deleted or suspended since init are left out rather than ending the run
+			var status *util.StatusError
+			if errors.As(err, &status) && (status.StatusCode == http.StatusNotFound || status.StatusCode == http.StatusGone) {
+				log.Printf("skipping %s (%v)\n", user.ScreenName, err)
+				continue
+			}
+			return err
+		}
+		ids := make([]string, len(following))
+		for i, u := range following {
+			ids[i] = fmt.Sprintf("%d", u.ID)
+		}
+		if _, err := util.FdatWriter(uid, ids); err != nil {
+			return fmt.Errorf("failed to write following file: %w", err)
+		}
+		log.Printf("processed %s\n", user.ScreenName)
+	}
+	return nil
+}
+
+// Edgelist constructs the network of who follows whom among handles returned by Init
+func (ns GitHub) Edgelist(ctx context.Context, opts sns.EdgelistOptions, args []string) (string, error) {
+	var cols = []string{
+		"ID",
+		"ScreenName",
+		"Protected",
+		"Verified",
+		"FriendsCount",
+		"FollowersCount",
+		"ListedCount",
+		"StatusesCount",
+		"CreatedAt",
+		"ProfileImageURL",
+		"Relation",
+		"Subject",
+	}
+	var filename string
+	var err error
+
+	data := []UserObject{}
+	for _, handle := range args {
+		if err = util.CSVReader(handle, util.DatExt, &data); err != nil {
+			return "", err
+		}
+		if opts.Ego {
+			if err = ns.connect(); err != nil {
+				return "", err
+			}
+			self, err := ns.show(ctx, handle)
+			if err != nil {
+				return "", fmt.Errorf("failed to retrieve handle details: %w", err)
+			}
+			data = append(data, userObject(self, "", ""))
+		}
+	}
+	// call GMLWriter using ScreenName as label for nodes
+	if filename, err = util.GMLWriter(args, data, opts.Missing, cols, "ScreenName"); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// Posts retrieves issues matching a search query, comments on a repository or one of its issues, or public events of a handle
+// the repository is passed in place of a list and the issue number in place of a post ID
+func (ns GitHub) Posts(ctx context.Context, opts sns.PostsOptions, args []string) (string, error) {
+	var endpoint string
+	var filename string
+	var err error
+
+	if err = ns.connect(); err != nil {
+		return "", err
+	}
+
+	repo := opts.List
+	switch {
+	case opts.Query:
+		endpoint = "/search/issues?per_page=100&q=" + url.QueryEscape(args[0])
+	case opts.PostID != "":
+		if repo == "" {
+			return "", errors.New("an issue number requires a repository passed with -m")
+		}
+		issue, err := strconv.ParseUint(opts.PostID, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid issue number %s", opts.PostID)
+		}
+		endpoint = fmt.Sprintf("/repos/%s/issues/%d/comments?per_page=100", repository(repo, args[0]), issue)
+	case repo != "":
+		endpoint = fmt.Sprintf("/repos/%s/issues/comments?per_page=100", repository(repo, args[0]))
+	default:
+		endpoint = fmt.Sprintf("/users/%s/events/public?per_page=100", url.PathEscape(args[0]))
+	}
+
+	for page := 0; endpoint != ""; page++ {
+		var posts []PostObject
+		var next string
+
+		if err = ctx.Err(); err != nil {
+			return filename, err
+		}
+		switch {
+		case opts.Query:
+			var result struct {
+				Items []Issue `json:"items"`
+			}
+			next, err = ns.get(ctx, endpoint, &result)
+			posts = issueObjects(result.Items)
+		case repo != "":
+			var comments []Comment
+			next, err = ns.get(ctx, endpoint, &comments)
+			posts = commentObjects(comments)
+		default:
+			var events []Event
+			next, err = ns.get(ctx, endpoint, &events)
+			posts = eventObjects(events)
+		}
+		if err != nil {
+			return filename, fmt.Errorf("failed to use GitHub client: %w", err)
+		}
+		if len(posts) == 0 {
+			break
+		}
+		filename, err = util.CSVWriter(args[0], util.QueryExt, page > 0, posts)
+		if page == 0 {
+			log.Printf("%s created\n", filename)
+		}
+		if err != nil {
+			return filename, fmt.Errorf("failed to write posts: %w", err)
+		}
+		log.Printf("processed %d posts\n", len(posts))
+		endpoint = next
+	}
+	return filename, nil
+}
+
+// Resolve converts logins to IDs and vice versa along with basic stats
+func (ns GitHub) Resolve(ctx context.Context, args []string) ([]sns.Profile, error) {
+	var result []sns.Profile
+
+	if err := ns.connect(); err != nil {
+		return nil, err
+	}
+
+	for _, handle := range args {
+		u, err := ns.show(ctx, handle)
+		if err != nil {
+			return result, fmt.Errorf("failed to retrieve handle details: %w", err)
+		}
+		result = append(result, sns.Profile{
+			Handle:     handle,
+			ID:         fmt.Sprintf("%d", u.ID),
+			ScreenName: u.Login,
+			Counts: []sns.Count{
+				{Value: u.Following, Label: "following"},
+				{Value: u.Followers, Label: "followers"},
+				{Value: u.PublicGists, Label: "gists"},
+				{Value: u.PublicRepos, Label: "repositories"},
+			},
+		})
+	}
+	return result, nil
+}
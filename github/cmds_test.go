@@ -0,0 +1,181 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/util"
+	"github.com/jdevoo/nucoll/util/utiltest"
+)
+
+// newAPI stands in for the GitHub API where alice follows bob and carol
+// and bob follows carol; following is served in pages of one user
+func newAPI(t *testing.T) *httptest.Server {
+	users := map[string]string{
+		"alice": `{"login":"alice","id":1,"following":2,"followers":0,"public_repos":3,"created_at":"2012-11-01T00:00:00Z"}`,
+		"bob":   `{"login":"bob","id":2,"following":1,"followers":1,"public_gists":4,"created_at":"2013-11-02T00:00:00Z"}`,
+		"carol": `{"login":"carol","id":3,"following":0,"followers":2,"location":"Ghent"}`,
+	}
+	ids := map[string]string{"1": "alice", "2": "bob", "3": "carol"}
+	following := map[string][]string{"alice": {"bob", "carol"}, "bob": {"carol"}}
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+	page := func(w http.ResponseWriter, r *http.Request, logins []string) {
+		n := 0
+		fmt.Sscan(r.URL.Query().Get("page"), &n)
+		if n+1 < len(logins) {
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=%d>; rel="next"`, srv.URL, r.URL.Path, n+1))
+		}
+		if n < len(logins) {
+			fmt.Fprintf(w, "[%s]", users[logins[n]])
+		} else {
+			fmt.Fprint(w, "[]")
+		}
+	}
+	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/users/"), "/")
+		switch {
+		case len(parts) == 1 && users[parts[0]] != "":
+			fmt.Fprint(w, users[parts[0]])
+		case len(parts) == 2 && parts[1] == "following":
+			page(w, r, following[parts[0]])
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	mux.HandleFunc("/user/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/user/"), "/")
+		if ids[parts[0]] == "" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"Not Found"}`)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "following" {
+			page(w, r, following[ids[parts[0]]])
+			return
+		}
+		fmt.Fprint(w, users[ids[parts[0]]])
+	})
+	mux.HandleFunc("/repos/alice/nucoll/stargazers", func(w http.ResponseWriter, r *http.Request) {
+		page(w, r, []string{"carol"})
+	})
+	srv = httptest.NewServer(mux)
+	return srv
+}
+
+func TestInitFetch(t *testing.T) {
+	srv := newAPI(t)
+	defer srv.Close()
+	utiltest.InWorkspace(t)
+
+	ns := GitHub{Client: srv.Client(), BaseURL: srv.URL}
+	ctx := context.Background()
+	if _, err := ns.Init(ctx, sns.InitOptions{}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	data := []UserObject{}
+	if err := util.CSVReader("alice", util.DatExt, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2 || data[0].ScreenName != "bob" || data[0].ListedCount != 4 || data[1].Location != "Ghent" {
+		t.Fatalf("unexpected users %+v", data)
+	}
+	if data[0].CreatedAt != "Sat Nov 02 00:00:00 +0000 2013" || data[0].Relation != "friends" {
+		t.Errorf("unexpected user %+v", data[0])
+	}
+
+	if err := ns.Fetch(ctx, sns.FetchOptions{MaxFriends: 5000}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(util.WorkspacePath(util.FdatDir + "/2" + util.FdatExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(b)); !reflect.DeepEqual(got, []string{"3"}) {
+		t.Errorf("got following %v, want [3]", got)
+	}
+
+	if _, err := ns.Init(ctx, sns.InitOptions{List: "nucoll"}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	data = []UserObject{}
+	if err := util.CSVReader("alice", util.DatExt, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || data[0].ScreenName != "carol" || data[0].Relation != "nucoll" {
+		t.Errorf("unexpected stargazers %+v", data)
+	}
+}
+
+func TestFetchSkips(t *testing.T) {
+	srv := newAPI(t)
+	defer srv.Close()
+	utiltest.InWorkspace(t)
+
+	// dave was deleted after init
+	data := []UserObject{{ID: 4, ScreenName: "dave"}, {ID: 2, ScreenName: "bob", FriendsCount: 1}}
+	if _, err := util.CSVWriter("alice", util.DatExt, false, data); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &NucollTransport{Config: &Config{}, Transport: http.DefaultTransport}}
+	ns := GitHub{Client: client, BaseURL: srv.URL}
+	if err := ns.Fetch(context.Background(), sns.FetchOptions{MaxFriends: 5000}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if util.FdatExists("4") || !util.FdatExists("2") {
+		t.Error("expected dave to be skipped and bob to be fetched")
+	}
+}
+
+func TestNewClientHeadless(t *testing.T) {
+	if util.Interactive() {
+		t.Skip("stdin is a terminal")
+	}
+	util.ConfigFlag = filepath.Join(t.TempDir(), "nucoll.json")
+	defer func() { util.ConfigFlag = "" }()
+	if _, err := NewClient(); err == nil || !strings.Contains(err.Error(), "not a terminal") {
+		t.Errorf("expected setup without a terminal to fail, actual %v", err)
+	}
+	// no answers are stored in place of a token
+	if _, err := os.Stat(util.ConfigFlag); !os.IsNotExist(err) {
+		t.Errorf("expected no config file, actual %v", err)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"message":"Bad credentials"}`)
+	}))
+	defer srv.Close()
+	utiltest.InWorkspace(t)
+
+	if _, err := util.CSVWriter("alice", util.DatExt, false, []UserObject{{ID: 2, ScreenName: "bob", FriendsCount: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	// bad credentials end the run whether or not the transport checks the status
+	for _, client := range []*http.Client{
+		{Transport: &NucollTransport{Config: &Config{Token: "bad"}, Transport: http.DefaultTransport}},
+		srv.Client(),
+	} {
+		ns := GitHub{Client: client, BaseURL: srv.URL}
+		if _, err := ns.Resolve(context.Background(), []string{"alice"}); err == nil || !strings.Contains(err.Error(), "401") {
+			t.Errorf("Resolve: expected 401, actual %v", err)
+		}
+		if err := ns.Fetch(context.Background(), sns.FetchOptions{MaxFriends: 5000}, []string{"alice"}); err == nil {
+			t.Error("Fetch: expected 401 to end the run")
+		}
+		if util.FdatExists("2") {
+			t.Error("Fetch: unexpected following file")
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package hackernews
+
+import (
+	"net/http"
+
+	"github.com/jdevoo/nucoll/util"
+)
+
+// APIURL is the default base URL of the Hacker News API served by Firebase
+const APIURL = "https://hacker-news.firebaseio.com/v0"
+
+// NucollTransport turns error statuses into errors
+// the API is neither authenticated nor rate limited
+type NucollTransport struct {
+	Transport http.RoundTripper
+}
+
+// rateLimit is empty as no window is announced, a Retry-After header is still honoured
+var rateLimit util.RateLimit
+
+// RoundTrip returns an error unless the API answered 200 OK
+func (t *NucollTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return rateLimit.Send(t.Transport, req, nil)
+}
+
+// NewClient returns a client for the public API, no setup is required
+func NewClient() (*http.Client, error) {
+	return &http.Client{Transport: &NucollTransport{Transport: util.Transport()}}, nil
+}
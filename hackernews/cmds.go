@@ -0,0 +1,467 @@
+package hackernews
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/util"
+)
+
+func init() {
+	sns.Register(sns.Backend{
+		Name:        "hackernews",
+		Description: "Hacker News reply networks of stories",
+		New:         func() sns.SocialNetworkService { return HackerNews{} },
+	})
+}
+
+// HackerNews builds reply networks from the comment trees of stories
+// handles are usernames except for init which takes a story ID
+type HackerNews struct {
+	Client  *http.Client
+	BaseURL string
+}
+
+// Item is a story, comment, job or poll as returned by the item API
+// deleted items have no author
+type Item struct {
+	ID          uint64   `json:"id"`
+	Type        string   `json:"type"`
+	By          string   `json:"by"`
+	Time        int64    `json:"time"`
+	Text        string   `json:"text"`
+	Title       string   `json:"title"`
+	URL         string   `json:"url"`
+	Parent      uint64   `json:"parent"`
+	Kids        []uint64 `json:"kids"`
+	Score       int      `json:"score"`
+	Descendants int      `json:"descendants"`
+	Deleted     bool     `json:"deleted"`
+	Dead        bool     `json:"dead"`
+}
+
+// User as returned by the user API
+type User struct {
+	ID        string   `json:"id"`
+	Created   int64    `json:"created"`
+	Karma     int      `json:"karma"`
+	About     string   `json:"about"`
+	Submitted []uint64 `json:"submitted"`
+}
+
+// UserObject mirrors the twitter user columns with the username as identifier
+// karma takes the place of the followers count and submissions of the statuses count
+// FriendsCount is the number of participants replied to in the thread
+type UserObject struct {
+	ID              string
+	ScreenName      string
+	Protected       bool
+	Verified        bool
+	FriendsCount    int
+	FollowersCount  int
+	ListedCount     int
+	StatusesCount   int
+	CreatedAt       string
+	URL             string
+	ProfileImageURL string
+	Location        string
+	Relation        string
+	Subject         string
+}
+
+// PostObject mirrors the twitter tweet columns with usernames as user identifiers
+// RetweetCount holds the number of direct replies and FavoriteCount the score of stories
+type PostObject struct {
+	CreatedAt string
+	ID        uint64
+	User      struct {
+		ScreenName string
+	}
+	Text                string
+	InReplyToTweet      uint64
+	InReplyToUser       string
+	InReplyToScreenName string
+	RetweetCount        int
+	FavoriteCount       int
+}
+
+// maxSubmissions bounds the items retrieved for the posts of a user
+const maxSubmissions = 200
+
+// unixDate converts API timestamps to the format used in twitter .dat files
+func unixDate(t int64) string {
+	return time.Unix(t, 0).UTC().Format(time.RubyDate)
+}
+
+// stripTags reduces comment HTML to plain text, paragraphs are opened but not closed
+func stripTags(s string) string {
+	s = regexp.MustCompile(`<p>|<br\s*/?>`).ReplaceAllString(s, " ")
+	s = regexp.MustCompile(`<[^>]*>`).ReplaceAllString(s, "")
+	return strings.TrimSpace(html.UnescapeString(s))
+}
+
+// connect creates the client unless one was provided
+func (ns *HackerNews) connect() error {
+	var err error
+
+	if ns.BaseURL == "" {
+		config, err := util.ReadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to read config: %w", err)
+		}
+		ns.BaseURL = config.BaseURL("hackernews", APIURL)
+	}
+	if ns.Client == nil {
+		if ns.Client, err = NewClient(); err != nil {
+			return fmt.Errorf("failed to create Hacker News client: %w", err)
+		}
+	}
+	return nil
+}
+
+// get decodes the JSON response of path into v
+func (ns HackerNews) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ns.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	res, err := ns.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// item returns the item with the given ID, unknown IDs are answered with null
+func (ns HackerNews) item(ctx context.Context, id uint64) (Item, error) {
+	var result Item
+
+	if err := ns.get(ctx, fmt.Sprintf("/item/%d.json", id), &result); err != nil {
+		return result, err
+	}
+	if result.ID == 0 {
+		return result, fmt.Errorf("item %d not found", id)
+	}
+	return result, nil
+}
+
+// user returns the profile of a username, usernames are case-sensitive
+func (ns HackerNews) user(ctx context.Context, name string) (User, error) {
+	var result User
+
+	if err := ns.get(ctx, "/user/"+url.PathEscape(name)+".json", &result); err != nil {
+		return result, err
+	}
+	if result.ID == "" {
+		return result, fmt.Errorf("user %s not found", name)
+	}
+	return result, nil
+}
+
+// thread returns the item id followed by all its comments in reading order
+func (ns HackerNews) thread(ctx context.Context, id uint64) ([]Item, error) {
+	var result []Item
+
+	stack := []uint64{id}
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		it, err := ns.item(ctx, stack[len(stack)-1])
+		if err != nil {
+			return nil, err
+		}
+		stack = stack[:len(stack)-1]
+		result = append(result, it)
+		for i := len(it.Kids) - 1; i >= 0; i-- {
+			stack = append(stack, it.Kids[i])
+		}
+		if len(result)%100 == 0 {
+			log.Printf("processed %d items\n", len(result))
+		}
+	}
+	return result, nil
+}
+
+// replies returns the participants of a thread in order of appearance and whom each replied to
+// replies to deleted items and to oneself are left out
+func replies(items []Item) ([]string, map[string][]string) {
+	var participants []string
+
+	edges := make(map[string][]string)
+	author := make(map[uint64]string)
+	for _, it := range items {
+		author[it.ID] = it.By
+		if it.By == "" {
+			continue
+		}
+		if _, ok := edges[it.By]; !ok {
+			edges[it.By] = []string{}
+			participants = append(participants, it.By)
+		}
+		if to := author[it.Parent]; to != "" && to != it.By && !util.Exists(to, edges[it.By]) {
+			edges[it.By] = append(edges[it.By], to)
+		}
+	}
+	return participants, edges
+}
+
+// userObject maps a user profile to a nucoll user object
+func userObject(u User) UserObject {
+	return UserObject{
+		ID:             u.ID,
+		ScreenName:     u.ID,
+		FollowersCount: u.Karma,
+		StatusesCount:  len(u.Submitted),
+		CreatedAt:      unixDate(u.Created),
+		URL:            "https://news.ycombinator.com/user?id=" + u.ID,
+	}
+}
+
+// postObjects maps items to nucoll post objects, deleted items are left out
+// reply targets are only named when the parent is part of items
+func postObjects(items []Item) []PostObject {
+	var result []PostObject
+
+	author := make(map[uint64]string)
+	for _, it := range items {
+		author[it.ID] = it.By
+	}
+	for _, it := range items {
+		if it.By == "" {
+			continue
+		}
+		var p PostObject
+		p.CreatedAt = unixDate(it.Time)
+		p.ID = it.ID
+		p.User.ScreenName = it.By
+		p.Text = stripTags(it.Text)
+		if it.Title != "" {
+			p.Text = strings.TrimSpace(it.Title + " " + it.URL + " " + p.Text)
+		}
+		p.InReplyToTweet = it.Parent
+		p.InReplyToUser = author[it.Parent]
+		p.InReplyToScreenName = author[it.Parent]
+		p.RetweetCount = len(it.Kids)
+		p.FavoriteCount = it.Score
+		result = append(result, p)
+	}
+	return result
+}
+
+// participant returns the ID of user name in the thread of story
+// it is prefixed by the story so the reply network of each story is kept apart
+func participant(story uint64, name string) string {
+	return strconv.FormatUint(story, 10) + ":" + name
+}
+
+// network writes the participants of a thread to a .dat file and whom they replied to to fdat files
+// participants are identified per story so that collecting another story leaves the fdat files of this one untouched
+func (ns HackerNews) network(ctx context.Context, handle string, items []Item) (string, error) {
+	story := items[0].ID
+	participants, edges := replies(items)
+	result := []UserObject{}
+	for _, name := range participants {
+		u, err := ns.user(ctx, name)
+		if err != nil {
+			log.Printf("skipping %s: %s\n", name, err)
+			continue
+		}
+		uo := userObject(u)
+		uo.ID = participant(story, name)
+		uo.FriendsCount = len(edges[name])
+		uo.Relation = "replier"
+		if name == items[0].By {
+			uo.Relation = "author"
+		}
+		uo.Subject = handle
+		result = append(result, uo)
+		targets := make([]string, len(edges[name]))
+		for i, to := range edges[name] {
+			targets[i] = participant(story, to)
+		}
+		if _, err := util.FdatWriter(uo.ID, targets); err != nil {
+			return "", fmt.Errorf("failed to write replies file: %w", err)
+		}
+	}
+	filename, err := util.CSVWriter(handle, util.DatExt, false, result)
+	if err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	log.Printf("processed %d participants\n", len(result))
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// storyID parses the story given in place of a screen name or post ID
+func storyID(s string) (uint64, error) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid story id %s", s)
+	}
+	return id, nil
+}
+
+// Init writes the reply network of the story given as argument, there are no follow relationships
+func (ns HackerNews) Init(ctx context.Context, opts sns.InitOptions, args []string) (string, error) {
+	if opts.Followers || opts.Query || opts.List != "" || opts.MaxPostCount > 0 {
+		return "", errors.New("Hacker News init takes a story ID without options")
+	}
+	if opts.Images {
+		log.Println("Hacker News users have no images")
+	}
+	id, err := storyID(args[0])
+	if err != nil {
+		return "", err
+	}
+	if err = ns.connect(); err != nil {
+		return "", err
+	}
+
+	items, err := ns.thread(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to use Hacker News client: %w", err)
+	}
+	return ns.network(ctx, args[0], items)
+}
+
+// Fetch has nothing to retrieve since init writes the replies of each participant
+func (ns HackerNews) Fetch(ctx context.Context, opts sns.FetchOptions, args []string) error {
+	log.Println("replies are written by init, nothing to fetch")
+	return nil
+}
+
+// Edgelist constructs the network of who replied to whom among participants returned by Init
+func (ns HackerNews) Edgelist(ctx context.Context, opts sns.EdgelistOptions, args []string) (string, error) {
+	var cols = []string{
+		"ID",
+		"ScreenName",
+		"Protected",
+		"Verified",
+		"FriendsCount",
+		"FollowersCount",
+		"ListedCount",
+		"StatusesCount",
+		"CreatedAt",
+		"ProfileImageURL",
+		"Relation",
+		"Subject",
+	}
+	var filename string
+	var err error
+
+	if opts.Ego {
+		log.Println("the author of the story is part of the network already")
+	}
+	data := []UserObject{}
+	for _, handle := range args {
+		if err = util.CSVReader(handle, util.DatExt, &data); err != nil {
+			return "", err
+		}
+	}
+	// call GMLWriter using ScreenName as label for nodes
+	if filename, err = util.GMLWriter(args, data, opts.Missing, cols, "ScreenName"); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// Posts retrieves the comment tree of a story given as post ID along with its reply network or the submissions of a user
+func (ns HackerNews) Posts(ctx context.Context, opts sns.PostsOptions, args []string) (string, error) {
+	var items []Item
+	var filename string
+	var err error
+
+	switch {
+	case opts.Query:
+		return "", errors.New("search is not part of the Hacker News API")
+	case opts.List != "":
+		return "", errors.New("lists are not part of Hacker News")
+	}
+	if err = ns.connect(); err != nil {
+		return "", err
+	}
+
+	if opts.PostID != "" {
+		id, err := storyID(opts.PostID)
+		if err != nil {
+			return "", err
+		}
+		if items, err = ns.thread(ctx, id); err != nil {
+			return "", fmt.Errorf("failed to use Hacker News client: %w", err)
+		}
+	} else {
+		u, err := ns.user(ctx, args[0])
+		if err != nil {
+			return "", fmt.Errorf("failed to retrieve handle details: %w", err)
+		}
+		for i, id := range u.Submitted {
+			if i == maxSubmissions {
+				break
+			}
+			it, err := ns.item(ctx, id)
+			if err != nil {
+				return "", fmt.Errorf("failed to use Hacker News client: %w", err)
+			}
+			items = append(items, it)
+		}
+	}
+
+	posts := postObjects(items)
+	if len(posts) == 0 {
+		return "", nil
+	}
+	if filename, err = util.CSVWriter(args[0], util.QueryExt, false, posts); err != nil {
+		return "", fmt.Errorf("failed to write posts: %w", err)
+	}
+	log.Printf("%s created\n", filename)
+	log.Printf("processed %d posts\n", len(posts))
+	if opts.PostID != "" {
+		if _, err = ns.network(ctx, args[0], items); err != nil {
+			return filename, err
+		}
+	}
+	return filename, nil
+}
+
+// Resolve returns the karma, creation date and number of submissions of usernames
+func (ns HackerNews) Resolve(ctx context.Context, args []string) ([]sns.Profile, error) {
+	var result []sns.Profile
+
+	if err := ns.connect(); err != nil {
+		return nil, err
+	}
+
+	for _, handle := range args {
+		u, err := ns.user(ctx, handle)
+		if err != nil {
+			return result, fmt.Errorf("failed to retrieve handle details: %w", err)
+		}
+		uo := userObject(u)
+		result = append(result, sns.Profile{
+			Handle:     handle,
+			ID:         uo.ID,
+			ScreenName: uo.ScreenName,
+			Counts: []sns.Count{
+				{Value: uo.FollowersCount, Label: "karma"},
+				{Value: uo.StatusesCount, Label: "submitted"},
+			},
+			CreatedAt: uo.CreatedAt,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,129 @@
+package hackernews
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/util"
+	"github.com/jdevoo/nucoll/util/utiltest"
+)
+
+// newAPI stands in for the API where bob and a deleted comment reply to alice's story,
+// carol replies to bob, alice to carol and bob to the deleted comment
+// in bob's story, alice replies to bob and carol to alice
+func newAPI() *httptest.Server {
+	items := map[string]string{
+		"1": `{"id":1,"type":"story","by":"alice","time":1700000000,"title":"Show HN: nucoll","url":"https://github.com/jdevoo/nucoll","kids":[2,5],"score":42}`,
+		"2": `{"id":2,"type":"comment","by":"bob","time":1700000100,"parent":1,"text":"Nice<p>Does it &quot;fetch&quot;?","kids":[3]}`,
+		"3": `{"id":3,"type":"comment","by":"carol","time":1700000200,"parent":2,"text":"It does","kids":[4]}`,
+		"4": `{"id":4,"type":"comment","by":"alice","time":1700000300,"parent":3,"text":"Thanks"}`,
+		"5": `{"id":5,"type":"comment","deleted":true,"time":1700000400,"parent":1,"kids":[6]}`,
+		"6": `{"id":6,"type":"comment","by":"bob","time":1700000500,"parent":5,"text":"?"}`,
+		"7": `{"id":7,"type":"story","by":"bob","time":1700000600,"title":"Ask HN: graphs?","kids":[8]}`,
+		"8": `{"id":8,"type":"comment","by":"alice","time":1700000700,"parent":7,"text":"Gephi","kids":[9]}`,
+		"9": `{"id":9,"type":"comment","by":"carol","time":1700000800,"parent":8,"text":"+1"}`,
+	}
+	users := map[string]string{
+		"alice": `{"id":"alice","created":1200000000,"karma":100,"submitted":[4,1]}`,
+		"bob":   `{"id":"bob","created":1300000000,"karma":20,"submitted":[6,2]}`,
+		"carol": `{"id":"carol","created":1400000000,"karma":3,"submitted":[3]}`,
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body string
+		var ok bool
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/item/"):
+			body, ok = items[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/item/"), ".json")]
+		case strings.HasPrefix(r.URL.Path, "/user/"):
+			body, ok = users[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/user/"), ".json")]
+		}
+		if !ok {
+			body = "null"
+		}
+		w.Write([]byte(body))
+	}))
+}
+
+func TestPostsEdgelist(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+	utiltest.InWorkspace(t)
+
+	ns := HackerNews{Client: srv.Client(), BaseURL: srv.URL}
+	ctx := context.Background()
+	if _, err := ns.Posts(ctx, sns.PostsOptions{PostID: "1"}, []string{"story"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(util.WorkspacePath("story" + util.QueryExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 6 || !strings.Contains(lines[2], `"Nice Does it ""fetch""?"`) {
+		t.Fatalf("Posts: unexpected %q", b)
+	}
+
+	data := []UserObject{}
+	if err := util.CSVReader("story", util.DatExt, &data); err != nil {
+		t.Fatal(err)
+	}
+	actual := []string{}
+	for _, u := range data {
+		actual = append(actual, u.ID+" "+u.Relation)
+	}
+	expected := []string{"1:alice author", "1:bob replier", "1:carol replier"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Posts: expected %v, actual %v", expected, actual)
+	}
+	if data[0].FollowersCount != 100 || data[0].StatusesCount != 2 || data[1].FriendsCount != 1 {
+		t.Errorf("unexpected user %+v", data[0])
+	}
+	for name, expected := range map[string]string{"alice": "1:carol\n", "bob": "1:alice\n", "carol": "1:bob\n"} {
+		b, err := ioutil.ReadFile(util.WorkspacePath(util.FdatDir + "/1_" + name + util.FdatExt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("replies of %s: expected %q, actual %q", name, expected, b)
+		}
+	}
+
+	// collecting another story with the same participants leaves the first network intact
+	if _, err := ns.Init(ctx, sns.InitOptions{}, []string{"7"}); err != nil {
+		t.Fatal(err)
+	}
+	filename, err := ns.Edgelist(ctx, sns.EdgelistOptions{}, []string{"story"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "edge ["); n != 3 {
+		t.Errorf("Edgelist: expected 3 edges, actual %d", n)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+
+	ns := HackerNews{Client: srv.Client(), BaseURL: srv.URL}
+	profiles, err := ns.Resolve(context.Background(), []string{"bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "bob, bob, 20 karma, 2 submitted, created Sun Mar 13 07:06:40 +0000 2011"
+	if len(profiles) != 1 || profiles[0].String() != expected {
+		t.Errorf("Resolve: expected %q, actual %v", expected, profiles)
+	}
+	if _, err := ns.Resolve(context.Background(), []string{"dave"}); err == nil {
+		t.Error("unknown user resolved")
+	}
+}
@@ -0,0 +1,104 @@
+package mastodon
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jdevoo/nucoll/util"
+)
+
+// Config stores the default instance and an optional user access token
+type Config struct {
+	Instance    string `json:"instance"`
+	AccessToken string `json:"access_token"`
+}
+
+// NucollTransport holds the config and the structure to deal with throttling
+type NucollTransport struct {
+	Config    *Config
+	Transport http.RoundTripper
+}
+
+// APIError holds the error message returned by an instance
+type APIError struct {
+	Message string `json:"error"`
+}
+
+// rateLimit headers sent with every response, the reset is an ISO 8601 timestamp
+// https://docs.joinmastodon.org/api/rate-limits/
+var rateLimit = util.RateLimit{
+	Remaining: "X-RateLimit-Remaining",
+	Reset:     "X-RateLimit-Reset",
+	ParseReset: func(s string) (time.Time, error) {
+		return time.Parse(time.RFC3339, s)
+	},
+}
+
+// RoundTrip sends req with the access token, which only goes to the configured instance
+func (t *NucollTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Config.AccessToken != "" && req.URL.Host == instanceURL(t.Config.Instance).Host {
+		req.Header.Set("Authorization", "Bearer "+t.Config.AccessToken)
+	}
+	return rateLimit.Send(t.Transport, req, nil)
+}
+
+// instanceURL returns the base URL of an instance given as domain or URL
+func instanceURL(instance string) *url.URL {
+	if !strings.Contains(instance, "://") {
+		instance = "https://" + instance
+	}
+	u, err := url.Parse(strings.TrimRight(instance, "/"))
+	if err != nil {
+		return &url.URL{}
+	}
+	return u
+}
+
+// NewClient returns a client for the instance stored in the configuration
+// the access token is optional and only needed for lists and search
+func NewClient() (*http.Client, string, error) {
+	t := &NucollTransport{Config: &Config{}}
+	t.Transport = util.Transport()
+
+	config, err := util.ReadConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	if err = config.Section("mastodon", t.Config); err != nil {
+		return nil, "", err
+	}
+	if t.Config.Instance == "" {
+		if !util.Interactive() {
+			return nil, "", errors.New("no Mastodon instance configured and stdin is not a terminal: run once interactively and pass -config with the resulting file")
+		}
+		fmt.Println(`
+===MASTODON API SETUP============================================
+Enter the instance used to resolve handles without a domain.
+An access token with read scope can be created on the instance
+under Preferences > Development; leave it empty to skip.`)
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Print("What is the instance (e.g. mastodon.social)? ")
+		instance, _ := reader.ReadString('\n')
+		fmt.Print("What is the access token? ")
+		accessToken, _ := reader.ReadString('\n')
+		t.Config.Instance = strings.TrimSpace(instance)
+		t.Config.AccessToken = strings.TrimSpace(accessToken)
+		if t.Config.Instance == "" {
+			return nil, "", errors.New("instance is required")
+		}
+		if err = config.SetSection("mastodon", t.Config); err != nil {
+			return nil, "", err
+		}
+		if err = util.WriteConfig(config); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return &http.Client{Transport: t}, t.Config.Instance, nil
+}
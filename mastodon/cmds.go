@@ -0,0 +1,659 @@
+package mastodon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/util"
+)
+
+func init() {
+	sns.Register(sns.Backend{
+		Name:        "mastodon",
+		Description: "Mastodon instances, handles as user@instance",
+		New:         func() sns.SocialNetworkService { return Mastodon{} },
+	})
+}
+
+// Mastodon client with custom RoundTripper to handle throttling
+// Instance resolves handles without a domain and serves lists and search
+type Mastodon struct {
+	Client   *http.Client
+	Instance string
+}
+
+// Account as returned by the accounts API
+type Account struct {
+	ID             string `json:"id"`
+	Acct           string `json:"acct"`
+	URL            string `json:"url"`
+	Locked         bool   `json:"locked"`
+	CreatedAt      string `json:"created_at"`
+	FollowersCount int    `json:"followers_count"`
+	FollowingCount int    `json:"following_count"`
+	StatusesCount  int    `json:"statuses_count"`
+	Avatar         string `json:"avatar"`
+	Fields         []struct {
+		VerifiedAt string `json:"verified_at"`
+	} `json:"fields"`
+}
+
+// Status as returned by the statuses and timelines API
+type Status struct {
+	ID                 string  `json:"id"`
+	CreatedAt          string  `json:"created_at"`
+	InReplyToID        string  `json:"in_reply_to_id"`
+	InReplyToAccountID string  `json:"in_reply_to_account_id"`
+	Content            string  `json:"content"`
+	Account            Account `json:"account"`
+	Mentions           []struct {
+		ID   string `json:"id"`
+		Acct string `json:"acct"`
+	} `json:"mentions"`
+	ReblogsCount    int     `json:"reblogs_count"`
+	FavouritesCount int     `json:"favourites_count"`
+	Reblog          *Status `json:"reblog"`
+}
+
+// List as returned by the lists API
+type List struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// UserObject mirrors the twitter user columns so that .dat files share one layout
+// ScreenName is qualified with the domain of the instance e.g. user@mastodon.social
+// ID is kept as returned since instances such as Pleroma use non-numeric identifiers
+type UserObject struct {
+	ID              string
+	ScreenName      string
+	Protected       bool
+	Verified        bool
+	FriendsCount    int
+	FollowersCount  int
+	ListedCount     int
+	StatusesCount   int
+	CreatedAt       string
+	URL             string
+	ProfileImageURL string
+	Location        string
+	Relation        string
+	Subject         string
+}
+
+// PostObject mirrors the twitter tweet columns so that .qry files share one layout
+type PostObject struct {
+	CreatedAt string
+	ID        string
+	User      struct {
+		ScreenName string
+	}
+	Text                string
+	InReplyToTweet      string
+	InReplyToUser       string
+	InReplyToScreenName string
+	RetweetCount        int
+	FavoriteCount       int
+}
+
+// handleRE matches @user and @user@instance in query files
+var handleRE = regexp.MustCompile(`@(\w+(?:@[\w-]+(?:\.[\w-]+)+)?)`)
+
+// splitHandle separates the user and domain parts of @user@instance
+func splitHandle(handle string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(handle, "@"), "@", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// qualify appends domain to accounts local to the instance which returned them
+func qualify(acct string, domain string) string {
+	if strings.Contains(acct, "@") {
+		return acct
+	}
+	return acct + "@" + domain
+}
+
+// stripTags reduces status HTML to plain text
+func stripTags(s string) string {
+	s = regexp.MustCompile(`<br\s*/?>|</p>`).ReplaceAllString(s, " ")
+	s = regexp.MustCompile(`<[^>]*>`).ReplaceAllString(s, "")
+	return strings.TrimSpace(html.UnescapeString(s))
+}
+
+// base returns the URL of the instance responsible for handle
+func (ns Mastodon) base(handle string) *url.URL {
+	home := instanceURL(ns.Instance)
+	if _, domain := splitHandle(handle); domain != "" && domain != home.Host {
+		return instanceURL(domain)
+	}
+	return home
+}
+
+// connect creates the client on first use
+func (ns *Mastodon) connect() error {
+	var err error
+
+	if ns.Client == nil {
+		if ns.Client, ns.Instance, err = NewClient(); err != nil {
+			return fmt.Errorf("failed to create Mastodon client: %w", err)
+		}
+	}
+	return nil
+}
+
+// get decodes a JSON response into v and returns the next page from the Link header
+func (ns Mastodon) get(ctx context.Context, endpoint string, v interface{}) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := ns.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", req.URL.Path, res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return "", err
+	}
+	return util.NextLink(res.Header), nil
+}
+
+// lookup returns the account of a handle or numeric ID known to instance base
+func (ns Mastodon) lookup(ctx context.Context, base *url.URL, handle string) (Account, error) {
+	var result Account
+
+	if util.DigitsOnly(handle) {
+		_, err := ns.get(ctx, fmt.Sprintf("%s/api/v1/accounts/%s", base, handle), &result)
+		return result, err
+	}
+	user, domain := splitHandle(handle)
+	acct := user
+	if domain != "" && domain != base.Host {
+		acct += "@" + domain
+	}
+	_, err := ns.get(ctx, fmt.Sprintf("%s/api/v1/accounts/lookup?acct=%s", base, url.QueryEscape(acct)), &result)
+	return result, err
+}
+
+// accounts follows Link headers to collect all accounts of a paginated endpoint
+func (ns Mastodon) accounts(ctx context.Context, endpoint string) ([]Account, error) {
+	var accounts []Account
+
+	for endpoint != "" {
+		var page []Account
+		next, err := ns.get(ctx, endpoint, &page)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, page...)
+		endpoint = next
+	}
+	return accounts, nil
+}
+
+// list returns the ID of a list owned by the authenticated user given its ID or title
+func (ns Mastodon) list(ctx context.Context, name string) (string, error) {
+	var lists []List
+
+	if _, err := ns.get(ctx, fmt.Sprintf("%s/api/v1/lists", instanceURL(ns.Instance)), &lists); err != nil {
+		return "", err
+	}
+	for _, l := range lists {
+		if l.ID == name || strings.EqualFold(l.Title, name) {
+			return l.ID, nil
+		}
+	}
+	return "", fmt.Errorf("list %q not found on %s", name, ns.Instance)
+}
+
+// members returns hydrated nucoll user objects belonging to a list
+func (ns Mastodon) members(ctx context.Context, list string, param string) ([]UserObject, error) {
+	id, err := ns.list(ctx, list)
+	if err != nil {
+		return nil, err
+	}
+	home := instanceURL(ns.Instance)
+	accounts, err := ns.accounts(ctx, fmt.Sprintf("%s/api/v1/lists/%s/accounts?limit=80", home, id))
+	if err != nil {
+		return nil, err
+	}
+	return users(accounts, home.Host, list, param), nil
+}
+
+// rebloggersOf returns followers of handle who reblogged one of its last maxCount statuses
+func (ns Mastodon) rebloggersOf(ctx context.Context, base *url.URL, handle string, maxCount int) ([]Account, error) {
+	var result []Account
+
+	self, err := ns.lookup(ctx, base, handle)
+	if err != nil {
+		return nil, err
+	}
+	followers, err := ns.accounts(ctx, fmt.Sprintf("%s/api/v1/accounts/%s/followers?limit=80", base, self.ID))
+	if err != nil {
+		return nil, err
+	}
+	isFollower := make(map[string]bool)
+	for _, a := range followers {
+		isFollower[a.ID] = true
+	}
+	seen := make(map[string]bool)
+	endpoint := fmt.Sprintf("%s/api/v1/accounts/%s/statuses?limit=40&exclude_reblogs=true", base, self.ID)
+	for c := 0; endpoint != "" && c < maxCount; {
+		var statuses []Status
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		next, err := ns.get(ctx, endpoint, &statuses)
+		if err != nil {
+			return nil, err
+		}
+		if len(statuses) == 0 {
+			break
+		}
+		for _, status := range statuses {
+			if status.ReblogsCount == 0 {
+				continue
+			}
+			rebloggers, err := ns.accounts(ctx, fmt.Sprintf("%s/api/v1/statuses/%s/reblogged_by?limit=80", base, status.ID))
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range rebloggers {
+				if isFollower[a.ID] && !seen[a.ID] {
+					seen[a.ID] = true
+					result = append(result, a)
+				}
+			}
+		}
+		log.Printf("processed %d statuses from %s\n", len(statuses), handle)
+		c += len(statuses)
+		endpoint = next
+	}
+	return result, nil
+}
+
+// users maps accounts returned by the instance at domain to nucoll user objects
+func users(accounts []Account, domain string, relation string, subject string) []UserObject {
+	result := make([]UserObject, len(accounts))
+	for i, a := range accounts {
+		result[i] = UserObject{
+			ID:              a.ID,
+			ScreenName:      qualify(a.Acct, domain),
+			Protected:       a.Locked,
+			FriendsCount:    a.FollowingCount,
+			FollowersCount:  a.FollowersCount,
+			StatusesCount:   a.StatusesCount,
+			CreatedAt:       util.RubyDate(a.CreatedAt),
+			URL:             a.URL,
+			ProfileImageURL: a.Avatar,
+			Relation:        relation,
+			Subject:         subject,
+		}
+		for _, f := range a.Fields {
+			if f.VerifiedAt != "" {
+				result[i].Verified = true
+			}
+		}
+	}
+	return result
+}
+
+// posts maps statuses returned by the instance at domain to nucoll post objects
+func posts(statuses []Status, domain string) []PostObject {
+	result := make([]PostObject, len(statuses))
+	for i, s := range statuses {
+		result[i].CreatedAt = util.RubyDate(s.CreatedAt)
+		result[i].ID = s.ID
+		result[i].User.ScreenName = qualify(s.Account.Acct, domain)
+		if s.Reblog != nil {
+			result[i].Text = fmt.Sprintf("RT @%s: %s", qualify(s.Reblog.Account.Acct, domain), stripTags(s.Reblog.Content))
+		} else {
+			result[i].Text = stripTags(s.Content)
+		}
+		result[i].InReplyToTweet = s.InReplyToID
+		result[i].InReplyToUser = s.InReplyToAccountID
+		if s.InReplyToAccountID == s.Account.ID {
+			result[i].InReplyToScreenName = result[i].User.ScreenName
+		}
+		for _, m := range s.Mentions {
+			if m.ID == s.InReplyToAccountID {
+				result[i].InReplyToScreenName = qualify(m.Acct, domain)
+			}
+		}
+		result[i].RetweetCount = s.ReblogsCount
+		result[i].FavoriteCount = s.FavouritesCount
+	}
+	return result
+}
+
+// following returns the accounts followed by user, preferring its home instance
+// where the list is complete; accounts present in members are written with their
+// collection ID so that edges match, others with their qualified handle
+func (ns Mastodon) following(ctx context.Context, base *url.URL, user UserObject, members map[string]string) ([]string, error) {
+	var ids []string
+
+	_, domain := splitHandle(user.ScreenName)
+	if domain != "" && domain != base.Host {
+		var accounts []Account
+
+		home := instanceURL(domain)
+		self, err := ns.lookup(ctx, home, user.ScreenName)
+		if err == nil {
+			accounts, err = ns.accounts(ctx, fmt.Sprintf("%s/api/v1/accounts/%s/following?limit=80", home, self.ID))
+			if err == nil {
+				for _, a := range accounts {
+					acct := qualify(a.Acct, domain)
+					if id, ok := members[acct]; ok {
+						ids = append(ids, id)
+					} else {
+						ids = append(ids, acct)
+					}
+				}
+				return ids, nil
+			}
+		}
+		log.Printf("falling back to %s for %s: %s\n", base.Host, user.ScreenName, err)
+	}
+	accounts, err := ns.accounts(ctx, fmt.Sprintf("%s/api/v1/accounts/%s/following?limit=80", base, url.PathEscape(user.ID)))
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range accounts {
+		ids = append(ids, a.ID)
+	}
+	return ids, nil
+}
+
+// Init supports retrieve handles from: list membership, a query file, followers who reblog or a following/follower relationship
+func (ns Mastodon) Init(ctx context.Context, opts sns.InitOptions, args []string) (string, error) {
+	var result []UserObject
+	var accounts []Account
+	var err error
+	var relation string
+	var path string
+	var filename string
+
+	if err = ns.connect(); err != nil {
+		return "", err
+	}
+	base := ns.base(args[0])
+
+	// list members use case: write user objects to disk and return
+	if opts.List != "" {
+		result, err = ns.members(ctx, opts.List, args[0])
+		if err != nil {
+			return "", fmt.Errorf("failed to retrieve members: %w", err)
+		}
+		filename, err = util.CSVWriter(args[0], util.DatExt, false, result)
+		if err != nil {
+			return "", fmt.Errorf("failed to write dat file: %w", err)
+		}
+		log.Printf("%s created\n", filename)
+		return filename, nil
+	}
+
+	if opts.Followers {
+		relation, path = "followers", "followers"
+	} else {
+		relation, path = "friends", "following"
+	}
+
+	switch {
+	case opts.Query:
+		// query search or manually created query file
+		var handles []string
+		handles, err = util.HandleReader(args[0], opts.NoMention, handleRE)
+		if err != nil {
+			return "", err
+		}
+		for _, handle := range handles {
+			a, err := ns.lookup(ctx, base, handle)
+			if err != nil {
+				log.Printf("skipping %s: %s\n", handle, err)
+				continue
+			}
+			accounts = append(accounts, a)
+		}
+	case opts.MaxPostCount > 0:
+		// followers who reblog statuses by this handle
+		accounts, err = ns.rebloggersOf(ctx, base, args[0], opts.MaxPostCount)
+		relation = "reblogger"
+	default:
+		// basic relation use case
+		var self Account
+		if self, err = ns.lookup(ctx, base, args[0]); err == nil {
+			accounts, err = ns.accounts(ctx, fmt.Sprintf("%s/api/v1/accounts/%s/%s?limit=80", base, self.ID, path))
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	result = users(accounts, base.Host, relation, args[0])
+	if opts.Images {
+		for i := range result {
+			// ignore errors on downloads
+			util.DownloadImage(ctx, result[i].ID, result[i].ProfileImageURL)
+		}
+	}
+	filename, err = util.CSVWriter(args[0], util.DatExt, false, result)
+	if err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	log.Printf("processed %d accounts\n", len(result))
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// Fetch retrieves second-degree "following" from handles collected with Init
+func (ns Mastodon) Fetch(ctx context.Context, opts sns.FetchOptions, args []string) error {
+	var err error
+
+	if err = ns.connect(); err != nil {
+		return err
+	}
+	base := ns.base(args[0])
+
+	data := []UserObject{}
+	if err = util.CSVReader(args[0], util.DatExt, &data); err != nil {
+		return err
+	}
+	members := make(map[string]string)
+	for _, user := range data {
+		members[user.ScreenName] = user.ID
+	}
+	for _, user := range data {
+		uid := user.ID
+		// skip if file exists and flag to force call not set
+		if !opts.Force && util.FdatExists(uid) {
+			continue
+		}
+		if user.FriendsCount > opts.MaxFriends {
+			log.Printf("skipping %s (%d following)\n", user.ScreenName, user.FriendsCount)
+			continue
+		}
+		ids, err := ns.following(ctx, base, user, members)
+		if err != nil {
+			return err
+		}
+		if _, err := util.FdatWriter(uid, ids); err != nil {
+			return fmt.Errorf("failed to write following file: %w", err)
+		}
+		log.Printf("processed %s\n", user.ScreenName)
+	}
+	return nil
+}
+
+// Edgelist constructs the network of who follows whom among handles returned by Init
+func (ns Mastodon) Edgelist(ctx context.Context, opts sns.EdgelistOptions, args []string) (string, error) {
+	var cols = []string{
+		"ID",
+		"ScreenName",
+		"Protected",
+		"Verified",
+		"FriendsCount",
+		"FollowersCount",
+		"ListedCount",
+		"StatusesCount",
+		"CreatedAt",
+		"ProfileImageURL",
+		"Relation",
+		"Subject",
+	}
+	var filename string
+	var err error
+
+	data := []UserObject{}
+	for _, handle := range args {
+		if err = util.CSVReader(handle, util.DatExt, &data); err != nil {
+			return "", err
+		}
+		if opts.Ego {
+			if err = ns.connect(); err != nil {
+				return "", err
+			}
+			base := ns.base(handle)
+			self, err := ns.lookup(ctx, base, handle)
+			if err != nil {
+				return "", fmt.Errorf("failed to retrieve handle details: %w", err)
+			}
+			// the ego node is labelled with the handle as given so that edges to its alters match
+			ego := users([]Account{self}, base.Host, "", "")[0]
+			ego.ScreenName = handle
+			data = append(data, ego)
+		}
+	}
+	// call GMLWriter using ScreenName as label for nodes
+	if filename, err = util.GMLWriter(args, data, opts.Missing, cols, "ScreenName"); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// Posts retrieves statuses from a search query or hashtag, a list, replies to a given status ID or from a handle
+func (ns Mastodon) Posts(ctx context.Context, opts sns.PostsOptions, args []string) (string, error) {
+	var endpoint string
+	var filename string
+	var err error
+
+	if err = ns.connect(); err != nil {
+		return "", err
+	}
+	base := ns.base(args[0])
+
+	switch {
+	case opts.Query && strings.HasPrefix(args[0], "#"):
+		base = instanceURL(ns.Instance)
+		endpoint = fmt.Sprintf("%s/api/v1/timelines/tag/%s?limit=40", base, url.PathEscape(strings.TrimPrefix(args[0], "#")))
+	case opts.Query:
+		base = instanceURL(ns.Instance)
+		endpoint = fmt.Sprintf("%s/api/v2/search?q=%s&type=statuses&limit=40", base, url.QueryEscape(args[0]))
+	case opts.List != "":
+		base = instanceURL(ns.Instance)
+		id, err := ns.list(ctx, opts.List)
+		if err != nil {
+			return "", fmt.Errorf("failed to retrieve list: %w", err)
+		}
+		endpoint = fmt.Sprintf("%s/api/v1/timelines/list/%s?limit=40", base, id)
+	case opts.PostID != "":
+		endpoint = fmt.Sprintf("%s/api/v1/statuses/%s/context", base, url.PathEscape(opts.PostID))
+	default:
+		self, err := ns.lookup(ctx, base, args[0])
+		if err != nil {
+			return "", fmt.Errorf("failed to retrieve handle details: %w", err)
+		}
+		endpoint = fmt.Sprintf("%s/api/v1/accounts/%s/statuses?limit=40", base, self.ID)
+	}
+
+	for page, offset := 0, 0; endpoint != ""; page++ {
+		var statuses []Status
+		var next string
+
+		if err = ctx.Err(); err != nil {
+			return filename, err
+		}
+		switch {
+		case opts.Query && !strings.HasPrefix(args[0], "#"):
+			var result struct {
+				Statuses []Status `json:"statuses"`
+			}
+			_, err = ns.get(ctx, fmt.Sprintf("%s&offset=%d", endpoint, offset), &result)
+			statuses = result.Statuses
+			offset += len(statuses)
+			next = endpoint
+		case opts.PostID != "":
+			var result struct {
+				Descendants []Status `json:"descendants"`
+			}
+			_, err = ns.get(ctx, endpoint, &result)
+			for _, s := range result.Descendants {
+				if s.InReplyToID == opts.PostID {
+					statuses = append(statuses, s)
+				}
+			}
+		default:
+			next, err = ns.get(ctx, endpoint, &statuses)
+		}
+		if err != nil {
+			return filename, fmt.Errorf("failed to use Mastodon client: %w", err)
+		}
+		if len(statuses) == 0 {
+			break
+		}
+		filename, err = util.CSVWriter(args[0], util.QueryExt, page > 0, posts(statuses, base.Host))
+		if page == 0 {
+			log.Printf("%s created\n", filename)
+		}
+		if err != nil {
+			return filename, fmt.Errorf("failed to write posts: %w", err)
+		}
+		log.Printf("processed %d statuses\n", len(statuses))
+		endpoint = next
+	}
+	return filename, nil
+}
+
+// Resolve converts handles to IDs and vice versa along with basic stats
+func (ns Mastodon) Resolve(ctx context.Context, args []string) ([]sns.Profile, error) {
+	var result []sns.Profile
+
+	if err := ns.connect(); err != nil {
+		return nil, err
+	}
+
+	for _, handle := range args {
+		base := ns.base(handle)
+		a, err := ns.lookup(ctx, base, handle)
+		if err != nil {
+			return result, fmt.Errorf("failed to retrieve handle details: %w", err)
+		}
+		result = append(result, sns.Profile{
+			Handle:     handle,
+			ID:         a.ID,
+			ScreenName: qualify(a.Acct, base.Host),
+			Counts: []sns.Count{
+				{Value: a.FollowingCount, Label: "following"},
+				{Value: a.FollowersCount, Label: "followers"},
+				{Value: a.StatusesCount, Label: "statuses"},
+			},
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,177 @@
+package mastodon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/util"
+	"github.com/jdevoo/nucoll/util/utiltest"
+)
+
+// newInstance stands in for a Mastodon instance where alice follows bob and carol
+// and bob follows carol; carol is on a server with non-numeric ids such as Pleroma
+// and following is served in pages of one account
+func newInstance(t *testing.T) *httptest.Server {
+	accounts := map[string]string{
+		"1":       `{"id":"1","acct":"alice","following_count":2,"followers_count":1,"statuses_count":1,"created_at":"2022-11-01T00:00:00.000Z"}`,
+		"2":       `{"id":"2","acct":"bob","following_count":1,"followers_count":1,"created_at":"2022-11-02T00:00:00.000Z"}`,
+		"AbZ9zXy": `{"id":"AbZ9zXy","acct":"carol@example.invalid","following_count":0,"followers_count":2,"locked":true,"fields":[{"verified_at":"2023-01-01T00:00:00.000Z"}]}`,
+	}
+	following := map[string][]string{"1": {"2", "AbZ9zXy"}, "2": {"AbZ9zXy"}}
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+	mux.HandleFunc("/api/v1/accounts/lookup", func(w http.ResponseWriter, r *http.Request) {
+		for _, a := range accounts {
+			if strings.Contains(a, `"acct":"`+r.URL.Query().Get("acct")+`"`) {
+				fmt.Fprint(w, a)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/api/v1/accounts/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/accounts/"), "/")
+		switch {
+		case len(parts) == 1:
+			fmt.Fprint(w, accounts[parts[0]])
+		case parts[1] == "following":
+			ids := following[parts[0]]
+			page := 0
+			fmt.Sscan(r.URL.Query().Get("page"), &page)
+			if page+1 < len(ids) {
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=%d>; rel="next"`, srv.URL, r.URL.Path, page+1))
+			}
+			if page < len(ids) {
+				fmt.Fprintf(w, "[%s]", accounts[ids[page]])
+			} else {
+				fmt.Fprint(w, "[]")
+			}
+		case parts[1] == "statuses":
+			fmt.Fprint(w, `[{"id":"11","created_at":"2023-05-01T10:00:00.000Z","content":"<p>hello <span>@bob</span></p>","account":{"id":"1","acct":"alice"},"reblogs_count":2}]`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	srv = httptest.NewServer(mux)
+	return srv
+}
+
+func TestSplitHandle(t *testing.T) {
+	var tests = []struct {
+		input  string
+		user   string
+		domain string
+	}{
+		{"@alice@mastodon.social", "alice", "mastodon.social"},
+		{"alice@mastodon.social", "alice", "mastodon.social"},
+		{"alice", "alice", ""},
+	}
+
+	for _, test := range tests {
+		user, domain := splitHandle(test.input)
+		if user != test.user || domain != test.domain {
+			t.Fatalf("splitHandle(%s): expected %s %s, actual %s %s", test.input, test.user, test.domain, user, domain)
+		} else {
+			t.Logf("splitHandle(%s): %s %s", test.input, test.user, test.domain)
+		}
+	}
+}
+
+func TestInitFetchPosts(t *testing.T) {
+	srv := newInstance(t)
+	defer srv.Close()
+	utiltest.InWorkspace(t)
+
+	ns := Mastodon{Client: srv.Client(), Instance: srv.URL}
+	ctx := context.Background()
+	if _, err := ns.Init(ctx, sns.InitOptions{}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	data := []UserObject{}
+	if err := util.CSVReader("alice", util.DatExt, &data); err != nil {
+		t.Fatal(err)
+	}
+	actual := []string{}
+	for _, u := range data {
+		actual = append(actual, fmt.Sprintf("%s %s %t %t %s", u.ID, u.ScreenName, u.Protected, u.Verified, u.Relation))
+	}
+	host := instanceURL(srv.URL).Host
+	expected := []string{"2 bob@" + host + " false false friends", "AbZ9zXy carol@example.invalid true true friends"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Init: expected %v, actual %v", expected, actual)
+	}
+
+	// carol's home instance cannot be reached so her following comes from the stand-in
+	if err := ns.Fetch(ctx, sns.FetchOptions{MaxFriends: 5000}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	for uid, expected := range map[string]string{"2": "AbZ9zXy\n", "AbZ9zXy": ""} {
+		b, err := ioutil.ReadFile(util.WorkspacePath(util.FdatDir + "/" + uid + util.FdatExt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Fatalf("Fetch %s: expected %q, actual %q", uid, expected, b)
+		}
+	}
+
+	if _, err := ns.Posts(ctx, sns.PostsOptions{}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	posts := []PostObject{}
+	if err := util.CSVReader("alice", util.QueryExt, &posts); err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 || posts[0].Text != "hello @bob" || posts[0].RetweetCount != 2 {
+		t.Fatalf("Posts: unexpected %v", posts)
+	}
+}
+
+func TestGetStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":"This API requires an authenticated user"}`)
+	}))
+	defer srv.Close()
+
+	ns := Mastodon{Client: srv.Client(), Instance: srv.URL}
+	account, err := ns.lookup(context.Background(), instanceURL(srv.URL), "alice")
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected 401 error, actual %+v (%v)", account, err)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":"The access token is invalid"}`)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &NucollTransport{Config: &Config{Instance: srv.URL, AccessToken: "bad"}, Transport: http.DefaultTransport}}
+	res, err := client.Get(srv.URL + "/api/v1/lists")
+	var status *util.StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected the transport to return 401, actual %v (%v)", res, err)
+	}
+}
+
+func TestNewClientHeadless(t *testing.T) {
+	if util.Interactive() {
+		t.Skip("stdin is a terminal")
+	}
+	util.ConfigFlag = filepath.Join(t.TempDir(), "nucoll.json")
+	defer func() { util.ConfigFlag = "" }()
+	if _, _, err := NewClient(); err == nil || !strings.Contains(err.Error(), "not a terminal") {
+		t.Errorf("expected setup without a terminal to fail, actual %v", err)
+	}
+}
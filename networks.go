@@ -0,0 +1,13 @@
+package main
+
+// backends register themselves with sns when their package is imported
+import (
+	_ "github.com/jdevoo/nucoll/archive"
+	_ "github.com/jdevoo/nucoll/bluesky"
+	_ "github.com/jdevoo/nucoll/github"
+	_ "github.com/jdevoo/nucoll/hackernews"
+	_ "github.com/jdevoo/nucoll/mastodon"
+	_ "github.com/jdevoo/nucoll/nostr"
+	_ "github.com/jdevoo/nucoll/synthetic"
+	_ "github.com/jdevoo/nucoll/twitter"
+)
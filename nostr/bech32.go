@@ -0,0 +1,141 @@
+package nostr
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// bech32 encoding of public keys as npub, see NIP-19 and BIP-173
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// polymod computes the BCH checksum over values
+func polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+// hrpExpand prepares the human-readable part for checksum computation
+func hrpExpand(hrp string) []byte {
+	result := make([]byte, 0, len(hrp)*2+1)
+	for _, c := range hrp {
+		result = append(result, byte(c>>5))
+	}
+	result = append(result, 0)
+	for _, c := range hrp {
+		result = append(result, byte(c&31))
+	}
+	return result
+}
+
+// convertBits regroups data from fromBits to toBits wide values
+func convertBits(data []byte, fromBits uint, toBits uint, pad bool) ([]byte, error) {
+	var result []byte
+	acc, bits := uint32(0), uint(0)
+	maxv := uint32(1)<<toBits - 1
+	for _, v := range data {
+		if uint32(v)>>fromBits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			result = append(result, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return result, nil
+}
+
+// bech32Encode encodes data with the human-readable part hrp
+func bech32Encode(hrp string, data []byte) (string, error) {
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	chk := polymod(append(append(hrpExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	var sb strings.Builder
+	sb.WriteString(hrp + "1")
+	for _, v := range values {
+		sb.WriteByte(charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(charset[(chk>>uint(5*(5-i)))&31])
+	}
+	return sb.String(), nil
+}
+
+// bech32Decode returns the human-readable part and data of s
+func bech32Decode(s string) (string, []byte, error) {
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, fmt.Errorf("invalid bech32 string %q", s)
+	}
+	hrp := s[:pos]
+	values := make([]byte, 0, len(s)-pos-1)
+	for _, c := range s[pos+1:] {
+		i := strings.IndexRune(charset, c)
+		if i < 0 {
+			return "", nil, fmt.Errorf("invalid bech32 character %q", c)
+		}
+		values = append(values, byte(i))
+	}
+	if polymod(append(hrpExpand(hrp), values...)) != 1 {
+		return "", nil, fmt.Errorf("invalid bech32 checksum in %q", s)
+	}
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	return hrp, data, err
+}
+
+// EncodeNpub converts a hex public key to its npub form
+func EncodeNpub(pubkey string) (string, error) {
+	b, err := hex.DecodeString(pubkey)
+	if err != nil || len(b) != 32 {
+		return "", fmt.Errorf("invalid public key %q", pubkey)
+	}
+	return bech32Encode("npub", b)
+}
+
+// DecodeNpub converts an npub to the hex public key used by relays
+func DecodeNpub(npub string) (string, error) {
+	hrp, b, err := bech32Decode(npub)
+	if err != nil {
+		return "", err
+	}
+	if hrp != "npub" || len(b) != 32 {
+		return "", fmt.Errorf("%s is not an npub", npub)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// DecodeNote converts a note to the hex event ID used by relays
+func DecodeNote(note string) (string, error) {
+	hrp, b, err := bech32Decode(note)
+	if err != nil {
+		return "", err
+	}
+	if hrp != "note" || len(b) != 32 {
+		return "", fmt.Errorf("%s is not a note", note)
+	}
+	return hex.EncodeToString(b), nil
+}
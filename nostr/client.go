@@ -0,0 +1,319 @@
+package nostr
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jdevoo/nucoll/util"
+)
+
+// DefaultRelays are used until the relays section of the config is edited
+var DefaultRelays = []string{"wss://relay.damus.io", "wss://nos.lol", "wss://relay.nostr.band"}
+
+// Config stores the relays to query
+type Config struct {
+	Relays []string `json:"relays"`
+}
+
+// Event as defined by NIP-01, ids are checked but signatures are not verified
+type Event struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// Hash returns the id of e, the hex SHA-256 of its serialization defined by NIP-01
+func (e Event) Hash() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[0,%s,%d,%d,[", quote(e.PubKey), e.CreatedAt, e.Kind)
+	for i, tag := range e.Tags {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('[')
+		for j, v := range tag {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(quote(v))
+		}
+		b.WriteByte(']')
+	}
+	fmt.Fprintf(&b, "],%s]", quote(e.Content))
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// quote escapes s as NIP-01 requires, which unlike encoding/json leaves HTML characters and line separators alone
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Filter selects events in a REQ message
+type Filter struct {
+	IDs     []string `json:"ids,omitempty"`
+	Authors []string `json:"authors,omitempty"`
+	Kinds   []int    `json:"kinds,omitempty"`
+	P       []string `json:"#p,omitempty"`
+	D       []string `json:"#d,omitempty"`
+	Until   int64    `json:"until,omitempty"`
+	E       []string `json:"#e,omitempty"`
+	Limit   int      `json:"limit,omitempty"`
+	Search  string   `json:"search,omitempty"`
+}
+
+// matches applies the filter to e except for Search and Limit, which only relays can
+func (f Filter) matches(e Event) bool {
+	has := func(values []string, v string) bool { return len(values) == 0 || util.Exists(v, values) }
+	hasTag := func(values []string, name string) bool {
+		if len(values) == 0 {
+			return true
+		}
+		for _, tag := range e.Tags {
+			if len(tag) > 1 && tag[0] == name && util.Exists(tag[1], values) {
+				return true
+			}
+		}
+		return false
+	}
+	kind := len(f.Kinds) == 0
+	for _, k := range f.Kinds {
+		kind = kind || k == e.Kind
+	}
+	return kind && has(f.IDs, e.ID) && has(f.Authors, e.PubKey) && hasTag(f.P, "p") && hasTag(f.D, "d") && hasTag(f.E, "e") &&
+		(f.Until == 0 || e.CreatedAt <= f.Until)
+}
+
+// Pool queries the same filters on several relays and merges their answers
+// connections are opened on first use and kept until Close
+type Pool struct {
+	Relays  []string
+	Timeout time.Duration
+	conns   map[string]*Conn
+	subs    int
+}
+
+// NewPool returns a pool over relays
+func NewPool(relays []string) *Pool {
+	return &Pool{Relays: relays, Timeout: 30 * time.Second, conns: make(map[string]*Conn)}
+}
+
+// conn returns the open connection to relay, dialing it if needed
+func (p *Pool) conn(relay string) (*Conn, error) {
+	if c, ok := p.conns[relay]; ok {
+		return c, nil
+	}
+	c, err := Dial(relay, p.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	p.conns[relay] = c
+	return c, nil
+}
+
+// drop closes the connection to relay after a failure
+func (p *Pool) drop(relay string) {
+	if c, ok := p.conns[relay]; ok {
+		c.Close()
+		delete(p.conns, relay)
+	}
+}
+
+// Query returns the events matching filters stored by any relay, duplicates removed
+func (p *Pool) Query(ctx context.Context, filters ...Filter) ([]Event, error) {
+	var result []Event
+
+	answers, err := p.QueryEach(ctx, filters...)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, relay := range p.Relays {
+		for _, e := range answers[relay] {
+			if !seen[e.ID] {
+				seen[e.ID] = true
+				result = append(result, e)
+			}
+		}
+	}
+	return result, nil
+}
+
+// QueryEach returns the events matching filters stored by each relay which answered
+// relays which cannot be reached are skipped as long as one of them answers
+func (p *Pool) QueryEach(ctx context.Context, filters ...Filter) (map[string][]Event, error) {
+	var lastErr error
+
+	result := make(map[string][]Event)
+	for _, relay := range p.Relays {
+		events, err := p.query(ctx, relay, filters)
+		if ctx.Err() != nil {
+			p.drop(relay)
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("skipping %s: %s\n", relay, err)
+			p.drop(relay)
+			lastErr = err
+			continue
+		}
+		result[relay] = events
+	}
+	if len(result) == 0 {
+		if lastErr == nil {
+			lastErr = errors.New("no relay configured")
+		}
+		return nil, lastErr
+	}
+	return result, nil
+}
+
+// query sends a REQ to relay and collects events until EOSE
+// events whose id does not match their content or which match none of the filters are dropped
+// so a relay cannot pass off another author's events, e.g. a newer contact list
+// the connection deadline is moved to now when ctx is done to unblock reads
+func (p *Pool) query(ctx context.Context, relay string, filters []Filter) ([]Event, error) {
+	var events []Event
+
+	c, err := p.conn(relay)
+	if err != nil {
+		return nil, err
+	}
+	p.subs++
+	sub := fmt.Sprintf("nucoll-%d", p.subs)
+	req := []interface{}{"REQ", sub}
+	for _, f := range filters {
+		req = append(req, f)
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(p.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.SetDeadline(deadline)
+	defer c.SetDeadline(time.Time{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	if err := c.WriteText(b); err != nil {
+		return nil, err
+	}
+	for {
+		var msg []json.RawMessage
+		var label, id string
+
+		b, err := c.ReadText()
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &msg); err != nil || len(msg) < 2 {
+			continue
+		}
+		json.Unmarshal(msg[0], &label)
+		json.Unmarshal(msg[1], &id)
+		switch label {
+		case "EVENT":
+			var e Event
+			if id != sub || len(msg) < 3 || json.Unmarshal(msg[2], &e) != nil {
+				continue
+			}
+			if e.ID != e.Hash() {
+				log.Printf("%s: dropping event %s with a mismatched id\n", relay, e.ID)
+				continue
+			}
+			for _, f := range filters {
+				if f.matches(e) {
+					events = append(events, e)
+					break
+				}
+			}
+		case "EOSE":
+			if id == sub {
+				done, _ := json.Marshal([]string{"CLOSE", sub})
+				c.WriteText(done)
+				return events, nil
+			}
+		case "CLOSED":
+			if id == sub {
+				return events, fmt.Errorf("subscription closed: %s", msg[len(msg)-1])
+			}
+		case "NOTICE":
+			log.Printf("%s: %s\n", relay, id)
+		}
+	}
+}
+
+// Close closes all relay connections
+func (p *Pool) Close() {
+	for relay := range p.conns {
+		p.drop(relay)
+	}
+}
+
+// NewClient returns a pool over the configured relays
+// the default relays are stored on first usage so they can be edited
+func NewClient() (*Pool, error) {
+	config, err := util.ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+	c := Config{Relays: DefaultRelays}
+	if _, ok := config.Networks["nostr"]; !ok {
+		if err = config.SetSection("nostr", c); err != nil {
+			return nil, err
+		}
+		if err = util.WriteConfig(config); err != nil {
+			return nil, err
+		}
+	} else if err = config.Section("nostr", &c); err != nil {
+		return nil, err
+	}
+	return NewPool(c.Relays), nil
+}
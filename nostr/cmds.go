@@ -0,0 +1,691 @@
+package nostr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/util"
+)
+
+func init() {
+	sns.Register(sns.Backend{
+		Name:        "nostr",
+		Description: "Nostr contact lists read from relays, public keys as IDs",
+		New:         func() sns.SocialNetworkService { return Nostr{} },
+	})
+}
+
+// Nostr reads events from a pool of relays
+// Client is only used to resolve NIP-05 identifiers
+type Nostr struct {
+	Pool   *Pool
+	Client *http.Client
+}
+
+// Metadata as found in the content of kind 0 events
+type Metadata struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Picture     string `json:"picture"`
+	Website     string `json:"website"`
+	Nip05       string `json:"nip05"`
+}
+
+// UserObject mirrors the twitter user columns with the hex public key as identifier
+// FollowersCount and StatusesCount are not known to relays and left to zero
+type UserObject struct {
+	ID              string
+	ScreenName      string
+	Protected       bool
+	Verified        bool
+	FriendsCount    int
+	FollowersCount  int
+	ListedCount     int
+	StatusesCount   int
+	CreatedAt       string
+	URL             string
+	ProfileImageURL string
+	Location        string
+	Relation        string
+	Subject         string
+}
+
+// PostObject mirrors the twitter tweet columns with event IDs as post identifiers
+// authors are written as npub so that query files can be used by init
+type PostObject struct {
+	CreatedAt string
+	ID        string
+	User      struct {
+		ScreenName string
+	}
+	Text                string
+	InReplyToTweet      string
+	InReplyToUser       string
+	InReplyToScreenName string
+	RetweetCount        int
+	FavoriteCount       int
+}
+
+const (
+	kindMetadata = 0
+	kindNote     = 1
+	kindContacts = 3
+	kindPeople   = 30000
+
+	// batch is the number of authors per filter, relays reject larger filters
+	batch = 100
+)
+
+// handleRE matches npub authors and nostr: mentions in query files
+var handleRE = regexp.MustCompile(`(?:@|nostr:)(npub1[02-9ac-hj-np-z]{58})`)
+
+// hexRE matches hex public keys
+var hexRE = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// connect creates the relay pool unless one was provided
+func (ns *Nostr) connect() error {
+	var err error
+
+	if ns.Client == nil {
+		ns.Client = http.DefaultClient
+	}
+	if ns.Pool == nil {
+		if ns.Pool, err = NewClient(); err != nil {
+			return fmt.Errorf("failed to create Nostr client: %w", err)
+		}
+	}
+	return nil
+}
+
+// pubkey normalizes a handle given as npub, hex key or NIP-05 identifier to a hex key
+func (ns Nostr) pubkey(ctx context.Context, handle string) (string, error) {
+	switch {
+	case strings.HasPrefix(handle, "npub1"):
+		return DecodeNpub(handle)
+	case hexRE.MatchString(strings.ToLower(handle)):
+		return strings.ToLower(handle), nil
+	case strings.Contains(handle, "."):
+		return ns.nip05(ctx, handle)
+	}
+	return "", fmt.Errorf("%s is neither an npub, a hex public key nor a NIP-05 identifier", handle)
+}
+
+// nip05 resolves name@domain, or domain for _@domain, with the well-known document of the domain
+func (ns Nostr) nip05(ctx context.Context, ident string) (string, error) {
+	var result struct {
+		Names map[string]string `json:"names"`
+	}
+
+	name, domain := "_", ident
+	if i := strings.Index(ident, "@"); i >= 0 {
+		name, domain = ident[:i], ident[i+1:]
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s/.well-known/nostr.json?name=%s", domain, name), nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := ns.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", ident, res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	pk, ok := result.Names[name]
+	if !ok || !hexRE.MatchString(pk) {
+		return "", fmt.Errorf("%s not found", ident)
+	}
+	return pk, nil
+}
+
+// eventID normalizes a post given as note or hex event ID
+func eventID(post string) (string, error) {
+	if strings.HasPrefix(post, "note1") {
+		return DecodeNote(post)
+	}
+	return strings.ToLower(post), nil
+}
+
+// npub encodes a hex key, keys which do not decode are returned unchanged
+func npub(pk string) string {
+	if s, err := EncodeNpub(pk); err == nil {
+		return s
+	}
+	return pk
+}
+
+// latest keeps the most recent replaceable event of each author
+func latest(events []Event) map[string]Event {
+	result := make(map[string]Event)
+	for _, e := range events {
+		if prev, ok := result[e.PubKey]; !ok || e.CreatedAt > prev.CreatedAt {
+			result[e.PubKey] = e
+		}
+	}
+	return result
+}
+
+// tagged returns the distinct valid public keys of the p tags of e
+func tagged(e Event) []string {
+	var result []string
+
+	seen := make(map[string]bool)
+	for _, tag := range e.Tags {
+		if len(tag) > 1 && tag[0] == "p" && hexRE.MatchString(tag[1]) && !seen[tag[1]] {
+			seen[tag[1]] = true
+			result = append(result, tag[1])
+		}
+	}
+	return result
+}
+
+// replaceable returns the latest event of kind for each of pubkeys querying relays in batches
+func (ns Nostr) replaceable(ctx context.Context, kind int, pubkeys []string) (map[string]Event, error) {
+	var events []Event
+
+	for i := 0; i < len(pubkeys); i += batch {
+		end := i + batch
+		if end > len(pubkeys) {
+			end = len(pubkeys)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		page, err := ns.Pool.Query(ctx, Filter{Kinds: []int{kind}, Authors: pubkeys[i:end]})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, page...)
+	}
+	return latest(events), nil
+}
+
+// contacts returns the followed keys of each of pubkeys from their kind 3 contact list
+func (ns Nostr) contacts(ctx context.Context, pubkeys []string) (map[string][]string, error) {
+	lists, err := ns.replaceable(ctx, kindContacts, pubkeys)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]string)
+	for pk, e := range lists {
+		result[pk] = tagged(e)
+	}
+	return result, nil
+}
+
+// metadata returns the kind 0 profile of each of pubkeys
+func (ns Nostr) metadata(ctx context.Context, pubkeys []string) (map[string]Metadata, error) {
+	events, err := ns.replaceable(ctx, kindMetadata, pubkeys)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]Metadata)
+	for pk, e := range events {
+		var m Metadata
+		if json.Unmarshal([]byte(e.Content), &m) == nil {
+			result[pk] = m
+		}
+	}
+	return result, nil
+}
+
+// followers returns the keys whose latest contact list includes pk
+func (ns Nostr) followers(ctx context.Context, pk string) ([]string, error) {
+	var result []string
+
+	events, err := ns.Pool.Query(ctx, Filter{Kinds: []int{kindContacts}, P: []string{pk}})
+	if err != nil {
+		return nil, err
+	}
+	for author, e := range latest(events) {
+		if util.Exists(pk, tagged(e)) {
+			result = append(result, author)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// repliersOf returns followers of pk among the authors of its maxCount most recent mentions
+func (ns Nostr) repliersOf(ctx context.Context, pk string, maxCount int) ([]string, error) {
+	var result []string
+
+	followers, err := ns.followers(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := ns.Pool.Query(ctx, Filter{Kinds: []int{kindNote}, P: []string{pk}, Limit: maxCount})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range notes {
+		if util.Exists(e.PubKey, followers) && !util.Exists(e.PubKey, result) {
+			result = append(result, e.PubKey)
+		}
+	}
+	log.Printf("processed %d notes mentioning %s\n", len(notes), npub(pk))
+	return result, nil
+}
+
+// members returns the keys of the NIP-51 people list named list by pk
+func (ns Nostr) members(ctx context.Context, list string, pk string) ([]string, error) {
+	lists, err := ns.Pool.Query(ctx, Filter{Kinds: []int{kindPeople}, Authors: []string{pk}, D: []string{list}})
+	if err != nil {
+		return nil, err
+	}
+	if len(lists) == 0 {
+		return nil, fmt.Errorf("list %s not found", list)
+	}
+	return tagged(latest(lists)[pk]), nil
+}
+
+// user maps a public key and its profile to a nucoll user object
+// names are not unique so the npub is used when the profile has none
+func user(pk string, m Metadata, following []string, relation string, subject string) UserObject {
+	name := m.Name
+	if name == "" {
+		name = m.DisplayName
+	}
+	if name == "" {
+		name = npub(pk)
+	}
+	return UserObject{
+		ID:              pk,
+		ScreenName:      strings.Join(strings.Fields(name), "_"),
+		FriendsCount:    len(following),
+		URL:             m.Website,
+		ProfileImageURL: m.Picture,
+		Relation:        relation,
+		Subject:         subject,
+	}
+}
+
+// users hydrates pubkeys with their profile and contact list
+func (ns Nostr) users(ctx context.Context, pubkeys []string, relation string, subject string) ([]UserObject, error) {
+	meta, err := ns.metadata(ctx, pubkeys)
+	if err != nil {
+		return nil, err
+	}
+	contacts, err := ns.contacts(ctx, pubkeys)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]UserObject, len(pubkeys))
+	for i, pk := range pubkeys {
+		result[i] = user(pk, meta[pk], contacts[pk], relation, subject)
+	}
+	return result, nil
+}
+
+// postObjects maps kind 1 events to nucoll post objects
+// the reply target is the e tag marked reply or else the last e tag as per NIP-10
+func postObjects(events []Event) []PostObject {
+	result := make([]PostObject, len(events))
+	for i, e := range events {
+		result[i].CreatedAt = time.Unix(e.CreatedAt, 0).UTC().Format(time.RubyDate)
+		result[i].ID = e.ID
+		result[i].User.ScreenName = npub(e.PubKey)
+		result[i].Text = e.Content
+		for _, tag := range e.Tags {
+			if len(tag) < 2 || tag[0] != "e" {
+				continue
+			}
+			if len(tag) > 3 && tag[3] == "root" && result[i].InReplyToTweet != "" {
+				continue
+			}
+			result[i].InReplyToTweet = tag[1]
+			if len(tag) > 3 && tag[3] == "reply" {
+				break
+			}
+		}
+		if result[i].InReplyToTweet != "" {
+			if p := tagged(e); len(p) > 0 {
+				result[i].InReplyToUser = p[0]
+				result[i].InReplyToScreenName = npub(p[0])
+			}
+		}
+	}
+	return result
+}
+
+// Init supports retrieve handles from: a people list, a query file, followers who reply or a follows/followers relationship
+func (ns Nostr) Init(ctx context.Context, opts sns.InitOptions, args []string) (string, error) {
+	var err error
+	var ids []string
+	var relation string
+	var filename string
+
+	if err = ns.connect(); err != nil {
+		return "", err
+	}
+	defer ns.Pool.Close()
+
+	if opts.Followers {
+		relation = "followers"
+	} else {
+		relation = "friends"
+	}
+
+	if opts.Query {
+		// query search or manually created query file
+		var handles []string
+		if handles, err = util.HandleReader(args[0], opts.NoMention, handleRE); err != nil {
+			return "", err
+		}
+		for _, handle := range handles {
+			pk, err := DecodeNpub(handle)
+			if err != nil {
+				return "", err
+			}
+			ids = append(ids, pk)
+		}
+	} else {
+		pk, err := ns.pubkey(ctx, args[0])
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case opts.List != "":
+			// people list use case
+			ids, err = ns.members(ctx, opts.List, pk)
+			relation = opts.List
+		case opts.MaxPostCount > 0:
+			// followers who reply to this handle
+			ids, err = ns.repliersOf(ctx, pk, opts.MaxPostCount)
+			relation = "replier"
+		case opts.Followers:
+			ids, err = ns.followers(ctx, pk)
+		default:
+			// basic relation use case
+			var contacts map[string][]string
+			if contacts, err = ns.contacts(ctx, []string{pk}); err == nil {
+				ids = contacts[pk]
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// populate a hydrated array of user objects based on array of keys
+	for page, width := 0, batch; page*width < len(ids); page++ {
+		end := (page + 1) * width
+		if end > len(ids) {
+			end = len(ids)
+		}
+		result, err := ns.users(ctx, ids[page*width:end], relation, args[0])
+		if err != nil {
+			return filename, fmt.Errorf("failed to query relays: %w", err)
+		}
+		for _, u := range result {
+			// ignore errors on downloads
+			if opts.Images && u.ProfileImageURL != "" {
+				util.DownloadImage(ctx, u.ID, u.ProfileImageURL)
+			}
+		}
+		filename, err = util.CSVWriter(args[0], util.DatExt, page > 0, result)
+		if err != nil {
+			return filename, fmt.Errorf("failed to write file: %w", err)
+		}
+		log.Printf("processed %d starting from %s\n", len(result), npub(ids[page*width]))
+	}
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// Fetch retrieves second-degree follows from handles collected with Init
+func (ns Nostr) Fetch(ctx context.Context, opts sns.FetchOptions, args []string) error {
+	var pubkeys []string
+
+	if err := ns.connect(); err != nil {
+		return err
+	}
+	defer ns.Pool.Close()
+
+	data := []UserObject{}
+	if err := util.CSVReader(args[0], util.DatExt, &data); err != nil {
+		return err
+	}
+	names := make(map[string]string)
+	for _, user := range data {
+		// skip if file exists and flag to force call not set
+		if !opts.Force && util.FdatExists(user.ID) {
+			continue
+		}
+		if user.FriendsCount > opts.MaxFriends {
+			log.Printf("skipping %s (%d follows)\n", user.ScreenName, user.FriendsCount)
+			continue
+		}
+		pubkeys = append(pubkeys, user.ID)
+		names[user.ID] = user.ScreenName
+	}
+	// contact lists are requested for a batch of authors at once
+	for i := 0; i < len(pubkeys); i += batch {
+		end := i + batch
+		if end > len(pubkeys) {
+			end = len(pubkeys)
+		}
+		contacts, err := ns.contacts(ctx, pubkeys[i:end])
+		if err != nil {
+			return err
+		}
+		for _, pk := range pubkeys[i:end] {
+			// an empty follows file would be taken as a user following nobody
+			follows, ok := contacts[pk]
+			if !ok {
+				log.Printf("skipping %s (no contact list found)\n", npub(pk))
+				continue
+			}
+			if _, err := util.FdatWriter(pk, follows); err != nil {
+				return fmt.Errorf("failed to write follows file: %w", err)
+			}
+			log.Printf("processed %s\n", names[pk])
+		}
+	}
+	return nil
+}
+
+// Edgelist constructs the network of who follows whom among handles returned by Init
+func (ns Nostr) Edgelist(ctx context.Context, opts sns.EdgelistOptions, args []string) (string, error) {
+	var cols = []string{
+		"ID",
+		"ScreenName",
+		"Protected",
+		"Verified",
+		"FriendsCount",
+		"FollowersCount",
+		"ListedCount",
+		"StatusesCount",
+		"CreatedAt",
+		"ProfileImageURL",
+		"Relation",
+		"Subject",
+	}
+	var filename string
+	var err error
+
+	defer func() {
+		if ns.Pool != nil {
+			ns.Pool.Close()
+		}
+	}()
+
+	data := []UserObject{}
+	for _, handle := range args {
+		if err = util.CSVReader(handle, util.DatExt, &data); err != nil {
+			return "", err
+		}
+		if opts.Ego {
+			if err = ns.connect(); err != nil {
+				return "", err
+			}
+			pk, err := ns.pubkey(ctx, handle)
+			if err != nil {
+				return "", err
+			}
+			self, err := ns.users(ctx, []string{pk}, "", "")
+			if err != nil {
+				return "", fmt.Errorf("failed to retrieve handle details: %w", err)
+			}
+			data = append(data, self...)
+		}
+	}
+	// call GMLWriter using ScreenName as label for nodes
+	if filename, err = util.GMLWriter(args, data, opts.Missing, cols, "ScreenName"); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// Posts retrieves notes from a search query, a people list, replies to a given note or a handle
+// relays answer search queries only when they support NIP-50
+func (ns Nostr) Posts(ctx context.Context, opts sns.PostsOptions, args []string) (string, error) {
+	var filter Filter
+	var filename string
+	var id string
+	var err error
+
+	if opts.PostID != "" {
+		if id, err = eventID(opts.PostID); err != nil {
+			return "", err
+		}
+	}
+	if err = ns.connect(); err != nil {
+		return "", err
+	}
+	defer ns.Pool.Close()
+
+	filter = Filter{Kinds: []int{kindNote}, Limit: 500}
+	switch {
+	case opts.Query:
+		filter.Search = args[0]
+	case id != "":
+		// notes referencing the event, kept below when they reply to it
+		filter.E = []string{id}
+	default:
+		pk, err := ns.pubkey(ctx, args[0])
+		if err != nil {
+			return "", err
+		}
+		filter.Authors = []string{pk}
+		if opts.List != "" {
+			if filter.Authors, err = ns.members(ctx, opts.List, pk); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	// relays apply the limit each on their own, so paging resumes below the newest of their oldest notes
+	// lest a relay with a denser page miss notes, and notes sent again by the others are skipped
+	seen := make(map[string]bool)
+	for {
+		var events []Event
+		var until int64
+
+		if err = ctx.Err(); err != nil {
+			return filename, err
+		}
+		answers, err := ns.Pool.QueryEach(ctx, filter)
+		if err != nil {
+			return filename, fmt.Errorf("failed to query relays: %w", err)
+		}
+		for _, relay := range ns.Pool.Relays {
+			var oldest int64
+			for _, e := range answers[relay] {
+				if oldest == 0 || e.CreatedAt < oldest {
+					oldest = e.CreatedAt
+				}
+				if !seen[e.ID] {
+					seen[e.ID] = true
+					events = append(events, e)
+				}
+			}
+			if oldest > until {
+				until = oldest
+			}
+		}
+		if until == 0 {
+			break
+		}
+		posts := postObjects(events)
+		if id != "" {
+			// a page may only hold notes further down the thread so paging goes on
+			replies := posts[:0]
+			for _, p := range posts {
+				if p.InReplyToTweet == id {
+					replies = append(replies, p)
+				}
+			}
+			posts = replies
+		}
+		if len(posts) > 0 {
+			created := filename == ""
+			filename, err = util.CSVWriter(args[0], util.QueryExt, !created, posts)
+			if created {
+				log.Printf("%s created\n", filename)
+			}
+			if err != nil {
+				return filename, fmt.Errorf("failed to write posts: %w", err)
+			}
+			log.Printf("processed %d notes\n", len(posts))
+		}
+		// search results are ranked so only authors and lists are paged by time
+		if opts.Query {
+			break
+		}
+		// stop if relays ignore until rather than loop over the same notes
+		if filter.Until != 0 && until > filter.Until {
+			break
+		}
+		filter.Until = until - 1
+	}
+	return filename, nil
+}
+
+// Resolve converts npubs, hex keys and NIP-05 identifiers to one another along with basic stats
+// hex keys resolve to the profile name
+func (ns Nostr) Resolve(ctx context.Context, args []string) ([]sns.Profile, error) {
+	var result []sns.Profile
+
+	if err := ns.connect(); err != nil {
+		return nil, err
+	}
+	defer ns.Pool.Close()
+
+	for _, handle := range args {
+		pk, err := ns.pubkey(ctx, handle)
+		if err != nil {
+			return result, err
+		}
+		meta, err := ns.metadata(ctx, []string{pk})
+		if err != nil {
+			return result, fmt.Errorf("failed to retrieve handle details: %w", err)
+		}
+		contacts, err := ns.contacts(ctx, []string{pk})
+		if err != nil {
+			return result, fmt.Errorf("failed to retrieve handle details: %w", err)
+		}
+		u := user(pk, meta[pk], contacts[pk], "", "")
+		result = append(result, sns.Profile{
+			Handle:     handle,
+			ID:         pk,
+			ScreenName: u.ScreenName,
+			Counts:     []sns.Count{{Value: u.FriendsCount, Label: "follows"}},
+		})
+	}
+	return result, nil
+}
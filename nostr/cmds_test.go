@@ -0,0 +1,282 @@
+package nostr
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/util"
+	"github.com/jdevoo/nucoll/util/utiltest"
+)
+
+var (
+	alice = strings.Repeat("a", 64)
+	bob   = strings.Repeat("b", 64)
+	carol = strings.Repeat("c", 64)
+)
+
+// hashes maps the ids events are written with in tests to their hash
+var hashes = make(map[string]string)
+
+// hashed sets the id of each event to its hash, e tags referring to earlier events follow
+// forged events keep the id they were given
+func hashed(events []Event) []Event {
+	for i, e := range events {
+		for _, tag := range e.Tags {
+			if len(tag) > 1 && tag[0] == "e" && hashes[tag[1]] != "" {
+				tag[1] = hashes[tag[1]]
+			}
+		}
+		if e.Sig != "forged" {
+			hashes[e.ID] = e.Hash()
+			events[i].ID = hashes[e.ID]
+		}
+	}
+	return events
+}
+
+// newRelay stands in for a relay where alice follows bob and carol and bob follows carol
+// alice replaced an older contact list which only had bob
+// extra events are served along with these and the newest events come first up to the limit of a filter
+// forged events are sent whatever the filter as a misbehaving relay would
+func newRelay(t *testing.T, extra ...Event) *httptest.Server {
+	events := hashed(append([]Event{
+		{ID: "1", PubKey: alice, CreatedAt: 100, Kind: kindContacts, Tags: [][]string{{"p", bob}}},
+		{ID: "2", PubKey: alice, CreatedAt: 200, Kind: kindContacts, Tags: [][]string{{"p", bob}, {"p", carol, "", "carol"}}},
+		{ID: "3", PubKey: bob, CreatedAt: 150, Kind: kindContacts, Tags: [][]string{{"p", carol}}},
+		{ID: "4", PubKey: bob, CreatedAt: 150, Kind: kindMetadata, Content: `{"name":"bob","picture":"https://example.invalid/bob.png"}`},
+		{ID: "5", PubKey: alice, CreatedAt: 300, Kind: kindNote, Content: "hello nostr:" + npub(bob)},
+		{ID: "6", PubKey: bob, CreatedAt: 400, Kind: kindNote, Content: "hi", Tags: [][]string{{"e", "5", "", "root"}, {"p", alice}}},
+		{ID: "7", PubKey: carol, CreatedAt: 500, Kind: kindNote, Content: "hey bob", Tags: [][]string{{"e", "5", "", "root"}, {"e", "6", "", "reply"}, {"p", bob}}},
+	}, extra...))
+	sort.SliceStable(events, func(i, j int) bool { return events[i].CreatedAt > events[j].CreatedAt })
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(r.Header.Get("Sec-WebSocket-Key")))
+		rw.Flush()
+		reply := func(v ...interface{}) {
+			b, _ := json.Marshal(v)
+			writeFrame(conn, opText, b, false)
+		}
+		for {
+			_, op, payload, err := readFrame(rw.Reader)
+			if err != nil || op == opClose {
+				return
+			}
+			var msg []json.RawMessage
+			var label, sub string
+			json.Unmarshal(payload, &msg)
+			json.Unmarshal(msg[0], &label)
+			json.Unmarshal(msg[1], &sub)
+			if label != "REQ" {
+				continue
+			}
+			for _, raw := range msg[2:] {
+				var f Filter
+				json.Unmarshal(raw, &f)
+				n := 0
+				for _, e := range events {
+					if (e.Sig == "forged" || f.matches(e)) && (f.Limit == 0 || n < f.Limit) {
+						reply("EVENT", sub, e)
+						n++
+					}
+				}
+			}
+			reply("EOSE", sub)
+		}
+	}))
+}
+
+func TestNpub(t *testing.T) {
+	// vector from NIP-19
+	const pk = "7e7e9c42a91bfef19fa929e5fda1b72e0ebc1a4c1141673e2794234d86addf4e"
+	const npub = "npub10elfcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8qzvjptg"
+	if got, err := EncodeNpub(pk); err != nil || got != npub {
+		t.Errorf("EncodeNpub(%s) = %s, %v", pk, got, err)
+	}
+	if got, err := DecodeNpub(npub); err != nil || got != pk {
+		t.Errorf("DecodeNpub(%s) = %s, %v", npub, got, err)
+	}
+	if _, err := DecodeNpub(npub[:len(npub)-1] + "q"); err == nil {
+		t.Error("bad checksum accepted")
+	}
+}
+
+func TestInitFetchPosts(t *testing.T) {
+	srv := newRelay(t)
+	defer srv.Close()
+	utiltest.InWorkspace(t)
+	relay := "ws" + strings.TrimPrefix(srv.URL, "http")
+	handle := npub(alice)
+
+	// the second relay cannot be reached and is skipped
+	ns := Nostr{Pool: NewPool([]string{relay, "ws://127.0.0.1:1"})}
+	ctx := context.Background()
+	if _, err := ns.Init(ctx, sns.InitOptions{}, []string{handle}); err != nil {
+		t.Fatal(err)
+	}
+	data := []UserObject{}
+	if err := util.CSVReader(handle, util.DatExt, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2 || data[0].ID != bob || data[0].ScreenName != "bob" || data[0].FriendsCount != 1 {
+		t.Fatalf("unexpected users %+v", data)
+	}
+	if data[1].ID != carol || data[1].ScreenName != npub(carol) || data[1].Relation != "friends" {
+		t.Errorf("unexpected user %+v", data[1])
+	}
+
+	ns = Nostr{Pool: NewPool([]string{relay})}
+	if err := ns.Fetch(ctx, sns.FetchOptions{MaxFriends: 5000}, []string{handle}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(util.WorkspacePath(util.FdatDir + "/" + bob + util.FdatExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(b)); !reflect.DeepEqual(got, []string{carol}) {
+		t.Errorf("got follows %v, want [%s]", got, carol)
+	}
+	// carol published no contact list so a later fetch may still find one
+	if util.FdatExists(carol) {
+		t.Error("unexpected follows file for carol")
+	}
+
+	ns = Nostr{Pool: NewPool([]string{relay})}
+	if _, err := ns.Posts(ctx, sns.PostsOptions{}, []string{handle}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(util.WorkspacePath(handle + util.QueryExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	scanner.Scan()
+	if line := scanner.Text(); !strings.Contains(line, "@"+handle) || !strings.Contains(line, "hello") {
+		t.Errorf("unexpected note %s", line)
+	}
+	ids, err := util.HandleReader(handle, false, handleRE)
+	if err != nil || !reflect.DeepEqual(ids, []string{handle, npub(bob)}) {
+		t.Errorf("got handles %v, %v", ids, err)
+	}
+
+	// carol's note references 5 as root but replies to 6
+	ns = Nostr{Pool: NewPool([]string{relay})}
+	if _, err := ns.Posts(ctx, sns.PostsOptions{PostID: hashes["5"]}, []string{"replies"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadFile(util.WorkspacePath("replies" + util.QueryExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 2 || !strings.Contains(lines[1], "hi") {
+		t.Errorf("unexpected replies %q", b)
+	}
+}
+
+func TestPostsThread(t *testing.T) {
+	// a page worth of later notes down the thread comes before bob's reply to 5
+	var thread []Event
+	for i := 0; i < 500; i++ {
+		thread = append(thread, Event{ID: fmt.Sprint(1000 + i), PubKey: carol, CreatedAt: int64(1000 + i), Kind: kindNote, Content: "more", Tags: [][]string{{"e", "5", "", "root"}, {"e", "6", "", "reply"}}})
+	}
+	srv := newRelay(t, thread...)
+	defer srv.Close()
+	utiltest.InWorkspace(t)
+
+	ns := Nostr{Pool: NewPool([]string{"ws" + strings.TrimPrefix(srv.URL, "http")})}
+	if _, err := ns.Posts(context.Background(), sns.PostsOptions{PostID: hashes["5"]}, []string{"replies"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(util.WorkspacePath("replies" + util.QueryExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 2 || !strings.Contains(lines[1], "hi") {
+		t.Errorf("unexpected replies %q", b)
+	}
+}
+
+func TestPostsRelays(t *testing.T) {
+	// the first relay holds a page of newer notes by alice and one older than them
+	var notes []Event
+	for i := 0; i < 500; i++ {
+		notes = append(notes, Event{ID: fmt.Sprint("n", i), PubKey: alice, CreatedAt: int64(1000 + i), Kind: kindNote, Content: "note"})
+	}
+	notes = append(notes, Event{ID: "late", PubKey: alice, CreatedAt: 950, Kind: kindNote, Content: "older"})
+	dense := newRelay(t, notes...)
+	defer dense.Close()
+	sparse := newRelay(t)
+	defer sparse.Close()
+	utiltest.InWorkspace(t)
+
+	ns := Nostr{Pool: NewPool([]string{"ws" + strings.TrimPrefix(dense.URL, "http"), "ws" + strings.TrimPrefix(sparse.URL, "http")})}
+	handle := npub(alice)
+	if _, err := ns.Posts(context.Background(), sns.PostsOptions{}, []string{handle}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(util.WorkspacePath(handle + util.QueryExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the note at 950 is below the oldest note of the dense page but above the one of the sparse relay
+	if n := strings.Count(string(b), ",older,"); n != 1 {
+		t.Errorf("expected the older note once, actual %d", n)
+	}
+	if n := strings.Count(string(b), ",hello nostr:"); n != 1 {
+		t.Errorf("expected the note held by both relays once, actual %d", n)
+	}
+}
+
+func TestForged(t *testing.T) {
+	// a newer contact list of alice with a wrong id and one of carol sent in answer to a request for alice
+	forged := []Event{
+		{ID: strings.Repeat("f", 64), PubKey: alice, CreatedAt: 900, Kind: kindContacts, Tags: [][]string{{"p", carol}}, Sig: "forged"},
+		{PubKey: carol, CreatedAt: 900, Kind: kindContacts, Tags: [][]string{{"p", bob}}, Sig: "forged"},
+	}
+	forged[1].ID = forged[1].Hash()
+	srv := newRelay(t, forged...)
+	defer srv.Close()
+
+	ns := Nostr{Pool: NewPool([]string{"ws" + strings.TrimPrefix(srv.URL, "http")})}
+	contacts, err := ns.contacts(context.Background(), []string{alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(contacts, map[string][]string{alice: {bob, carol}}) {
+		t.Errorf("unexpected contacts %v", contacts)
+	}
+}
+
+func TestHash(t *testing.T) {
+	e := Event{PubKey: alice, CreatedAt: 1700000000, Kind: kindNote, Tags: [][]string{{"e", bob, "", "root"}, {"p", carol}}, Content: "a <b> & \"c\"\n\u2028"}
+	// NIP-01 leaves HTML characters and line separators unescaped, unlike encoding/json
+	expected := "[0,\"" + alice + "\",1700000000,1,[[\"e\",\"" + bob + "\",\"\",\"root\"],[\"p\",\"" + carol + "\"]],\"a <b> & \\\"c\\\"\\n\u2028\"]"
+	sum := sha256.Sum256([]byte(expected))
+	if actual := e.Hash(); actual != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected hash of %s, actual %s", expected, actual)
+	}
+}
@@ -0,0 +1,242 @@
+package nostr
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// minimal WebSocket client covering what relays need: text messages, ping and close
+// see https://www.rfc-editor.org/rfc/rfc6455
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+
+	// maxMessage bounds the size of a message read from a relay
+	maxMessage = 16 << 20
+
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// Conn is a WebSocket connection to a relay
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// acceptKey computes the Sec-WebSocket-Accept value expected for key
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Dial opens a WebSocket connection to a ws:// or wss:// URL
+func Dial(rawurl string, timeout time.Duration) (*Conn, error) {
+	var conn net.Conn
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	dialer := &net.Dialer{Timeout: timeout}
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			host += ":80"
+		}
+		conn, err = dialer.Dial("tcp", host)
+	case "wss":
+		if u.Port() == "" {
+			host += ":443"
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("unsupported relay scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: u.EscapedPath(), RawQuery: u.RawQuery},
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSwitchingProtocols || res.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, fmt.Errorf("%s: websocket handshake failed with %s", rawurl, res.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return &Conn{conn: conn, br: br}, nil
+}
+
+// SetDeadline bounds the time spent in reads and writes
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// WriteText sends a text message, client frames are always masked
+func (c *Conn) WriteText(b []byte) error {
+	return writeFrame(c.conn, opText, b, true)
+}
+
+// ReadText returns the next text message answering pings along the way
+func (c *Conn) ReadText() ([]byte, error) {
+	var message []byte
+
+	for {
+		fin, op, payload, err := readFrame(c.br)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			if err := writeFrame(c.conn, opPong, payload, true); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			writeFrame(c.conn, opClose, nil, true)
+			return nil, io.EOF
+		}
+		message = append(message, payload...)
+		if len(message) > maxMessage {
+			return nil, errors.New("websocket message too large")
+		}
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// Close sends a close frame and closes the connection
+func (c *Conn) Close() error {
+	writeFrame(c.conn, opClose, nil, true)
+	return c.conn.Close()
+}
+
+// writeFrame writes a single final frame
+func writeFrame(w io.Writer, op byte, payload []byte, masked bool) error {
+	header := []byte{0x80 | op, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	if masked {
+		header[1] |= 0x80
+		mask := make([]byte, 4)
+		rand.Read(mask)
+		header = append(header, mask...)
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+	if _, err := w.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readFrame reads a single frame and unmasks its payload
+func readFrame(r io.Reader) (fin bool, op byte, payload []byte, err error) {
+	var header [2]byte
+
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	op = header[0] & 0x0F
+	n := uint64(header[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxMessage {
+		err = errors.New("websocket frame too large")
+		return
+	}
+	var mask [4]byte
+	if header[1]&0x80 != 0 {
+		if _, err = io.ReadFull(r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if header[1]&0x80 != 0 {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	switch op {
+	case opContinuation, opText, opClose, opPing, opPong:
+	default:
+		// relays speak JSON so binary frames are unexpected as well
+		err = fmt.Errorf("unexpected websocket opcode %d", op)
+	}
+	return
+}
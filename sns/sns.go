@@ -0,0 +1,160 @@
+// Package sns defines the interface implemented by social network backends
+// and the registry through which backends make themselves available by name
+package sns
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Default is the network used when none is selected
+const Default = "twitter"
+
+// SocialNetworkService defines the interface for services such as Twitter
+// methods return errors instead of exiting and stop paginating once ctx is done
+type SocialNetworkService interface {
+	Init(ctx context.Context, opts InitOptions, args []string) (string, error)
+	Fetch(ctx context.Context, opts FetchOptions, args []string) error
+	Edgelist(ctx context.Context, opts EdgelistOptions, args []string) (string, error)
+	Posts(ctx context.Context, opts PostsOptions, args []string) (string, error)
+	Resolve(ctx context.Context, args []string) ([]Profile, error)
+}
+
+// Tester is implemented by backends able to check their credentials with a cheap authenticated call
+// Test returns a short description of the outcome such as the calls left
+type Tester interface {
+	Test(ctx context.Context) (string, error)
+}
+
+// Invalidator is implemented by backends able to revoke the token stored in the profile
+// Invalidate returns a short description of the token revoked
+type Invalidator interface {
+	Invalidate(ctx context.Context) (string, error)
+}
+
+// InitOptions select which handles Init retrieves for a screen name
+// List takes precedence over Query, itself over MaxPostCount and Followers
+type InitOptions struct {
+	Followers    bool
+	MaxPostCount int
+	Query        bool
+	NoMention    bool
+	List         string
+	Images       bool
+}
+
+// FetchOptions control which handles of a .dat file are fetched
+// handles with more than MaxFriends friends are skipped
+// backends able to fetch in parallel use up to Workers goroutines
+type FetchOptions struct {
+	Force      bool
+	MaxFriends int
+	Workers    int
+}
+
+// EdgelistOptions control the nodes written to the GML file
+type EdgelistOptions struct {
+	Ego     bool
+	Missing bool
+}
+
+// PostsOptions select the posts retrieved for a screen name or query
+// PostID is a string since not all networks use numeric post identifiers
+// backends able to resume start below MaxID and append to the posts file when it is set
+type PostsOptions struct {
+	Query  bool
+	List   string
+	PostID string
+	MaxID  string
+}
+
+// Interrupted wraps the error of a command canceled midway
+// Resume holds the command options which continue where it stopped, e.g. -max and the oldest post collected
+type Interrupted struct {
+	Err    error
+	Resume []string
+}
+
+func (e *Interrupted) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the cancellation cause
+func (e *Interrupted) Unwrap() error {
+	return e.Err
+}
+
+// Profile is the result of resolving a handle given as screen name or ID
+// Counts are labelled in the words of the network e.g. friends or follows
+// CreatedAt is only printed by networks which report it
+type Profile struct {
+	Handle     string
+	ID         string
+	ScreenName string
+	Counts     []Count
+	CreatedAt  string
+}
+
+// Count is a labelled profile statistic
+type Count struct {
+	Value int
+	Label string
+}
+
+// String formats the profile as printed by the resolve command
+func (p Profile) String() string {
+	cols := []string{p.Handle, p.ID}
+	if strings.EqualFold(p.Handle, p.ID) {
+		cols[1] = p.ScreenName
+	}
+	for _, c := range p.Counts {
+		cols = append(cols, fmt.Sprintf("%d %s", c.Value, c.Label))
+	}
+	if p.CreatedAt != "" {
+		cols = append(cols, "created "+p.CreatedAt)
+	}
+	return strings.Join(cols, ", ")
+}
+
+// Backend describes an implementation registered under the name passed to -n
+// Audit is set by backends which append a record of each request to the audit log
+type Backend struct {
+	Name        string
+	Description string
+	New         func() SocialNetworkService
+	Audit       bool
+}
+
+var backends = make(map[string]Backend)
+
+// Register makes a backend available by name, usually from the init function of its package
+// it panics if the name is registered twice
+func Register(b Backend) {
+	if b.New == nil {
+		panic("sns: Register backend is nil")
+	}
+	if _, dup := backends[b.Name]; dup {
+		panic(fmt.Sprintf("sns: Register called twice for backend %s", b.Name))
+	}
+	backends[b.Name] = b
+}
+
+// Lookup returns the backend registered under name
+func Lookup(name string) (Backend, bool) {
+	b, ok := backends[name]
+	return b, ok
+}
+
+// Backends returns the registered backends sorted by name
+func Backends() []Backend {
+	result := make([]Backend, 0, len(backends))
+	for _, b := range backends {
+		result = append(result, b)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
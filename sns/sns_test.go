@@ -0,0 +1,62 @@
+package sns
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type stub struct{}
+
+func (stub) Init(context.Context, InitOptions, []string) (string, error)         { return "", nil }
+func (stub) Fetch(context.Context, FetchOptions, []string) error                 { return nil }
+func (stub) Edgelist(context.Context, EdgelistOptions, []string) (string, error) { return "", nil }
+func (stub) Posts(context.Context, PostsOptions, []string) (string, error)       { return "", nil }
+func (stub) Resolve(context.Context, []string) ([]Profile, error)                { return nil, nil }
+
+func TestRegister(t *testing.T) {
+	defer func(saved map[string]Backend) { backends = saved }(backends)
+	backends = make(map[string]Backend)
+
+	for _, name := range []string{"zeta", "alpha"} {
+		Register(Backend{Name: name, New: func() SocialNetworkService { return stub{} }})
+	}
+	var names []string
+	for _, b := range Backends() {
+		names = append(names, b.Name)
+	}
+	if expected := []string{"alpha", "zeta"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Backends: expected %v, actual %v", expected, names)
+	}
+	if _, ok := Lookup("alpha"); !ok {
+		t.Fatalf("Lookup(alpha): expected backend")
+	}
+	if _, ok := Lookup("beta"); ok {
+		t.Fatalf("Lookup(beta): expected no backend")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Register: expected panic on duplicate name")
+		}
+	}()
+	Register(Backend{Name: "alpha", New: func() SocialNetworkService { return stub{} }})
+}
+
+func TestProfileString(t *testing.T) {
+	counts := []Count{{12, "friends"}, {3, "followers"}}
+	var tests = []struct {
+		profile  Profile
+		expected string
+	}{
+		{Profile{"jdevoo", "42", "jdevoo", counts, ""}, "jdevoo, 42, 12 friends, 3 followers"},
+		{Profile{"42", "42", "jdevoo", counts, ""}, "42, jdevoo, 12 friends, 3 followers"},
+		{Profile{"did:plc:Bob", "did:plc:bob", "bob.test", nil, ""}, "did:plc:Bob, bob.test"},
+		{Profile{"pg", "pg", "pg", []Count{{155, "karma"}}, "Mon Oct 09 18:21:18 +0000 2006"}, "pg, pg, 155 karma, created Mon Oct 09 18:21:18 +0000 2006"},
+	}
+	for _, test := range tests {
+		if actual := test.profile.String(); actual != test.expected {
+			t.Errorf("String: expected %q, actual %q", test.expected, actual)
+		}
+	}
+}
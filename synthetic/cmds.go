@@ -0,0 +1,452 @@
+package synthetic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/twitter"
+	"github.com/jdevoo/nucoll/util"
+)
+
+func init() {
+	sns.Register(sns.Backend{
+		Name:        "synthetic",
+		Description: "generated networks for teaching and dry runs, works offline",
+		New:         func() sns.SocialNetworkService { return Synthetic{} },
+	})
+}
+
+// Synthetic serves a generated network through the same files as the twitter backend
+// accounts are named user0, user1... with IDs starting at FirstID
+type Synthetic struct {
+	Config *Config
+	graph  *Graph
+}
+
+// FirstID is the ID of user0
+const FirstID = 1000
+
+// epoch is the time of the most recent synthetic tweet so that runs are reproducible
+var epoch = time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	words     = strings.Fields("network graph data node edge community centrality course workshop paper model theory study method result student research analysis tie bridge cluster hub")
+	hashtags  = []string{"#SNA", "#networkscience", "#datascience", "#rstats", "#python", "#gephi"}
+	locations = []string{"", "", "Brussels", "Ghent", "Leuven", "Paris", "London", "Boston", "Berlin", "Amsterdam"}
+)
+
+// load reads the generator settings and builds the graph
+// the default settings are stored on first usage so they can be edited
+func (ns *Synthetic) load() error {
+	var err error
+
+	if ns.graph != nil {
+		return nil
+	}
+	if ns.Config == nil {
+		config, err := util.ReadConfig()
+		if err != nil {
+			return err
+		}
+		c := DefaultConfig
+		if _, ok := config.Networks["synthetic"]; !ok {
+			if err = config.SetSection("synthetic", c); err != nil {
+				return err
+			}
+			if err = util.WriteConfig(config); err != nil {
+				return err
+			}
+		} else if err = config.Section("synthetic", &c); err != nil {
+			return err
+		}
+		ns.Config = &c
+	}
+	if ns.graph, err = Generate(*ns.Config); err != nil {
+		return err
+	}
+	log.Printf("generated %s model with %d nodes using seed %d\n", ns.Config.Model, ns.Config.Nodes, ns.Config.Seed)
+	return nil
+}
+
+// rng returns a generator dedicated to one purpose so that results do not depend on call order
+func (ns *Synthetic) rng(purpose string, n int) *rand.Rand {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%s/%d", ns.Config.Seed, purpose, n)
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+// node returns the graph node of a handle given as screen name or ID
+func (ns *Synthetic) node(handle string) (int, error) {
+	var n int
+	var err error
+
+	if util.DigitsOnly(handle) {
+		n, err = strconv.Atoi(handle)
+		n -= FirstID
+	} else {
+		n, err = strconv.Atoi(strings.TrimPrefix(strings.ToLower(handle), "user"))
+	}
+	if err != nil || n < 0 || n >= len(ns.graph.Following) {
+		return 0, fmt.Errorf("%s is not part of the synthetic network, use user0 to user%d", handle, len(ns.graph.Following)-1)
+	}
+	return n, nil
+}
+
+// screenName of node n
+func screenName(n int) string {
+	return fmt.Sprintf("user%d", n)
+}
+
+// user returns the attributes of node n
+// counts follow the graph while other attributes are drawn so that hubs look established
+func (ns *Synthetic) user(n int) twitter.UserObject {
+	rng := ns.rng("user", n)
+	followers := len(ns.graph.Followers[n])
+	created := epoch.AddDate(-1-rng.Intn(15), 0, -rng.Intn(365)).Add(-time.Duration(rng.Intn(86400)) * time.Second)
+	return twitter.UserObject{
+		ID:             uint64(FirstID + n),
+		ScreenName:     screenName(n),
+		Protected:      rng.Float64() < 0.05,
+		Verified:       rng.Float64() < float64(followers)/float64(len(ns.graph.Followers)),
+		FriendsCount:   len(ns.graph.Following[n]),
+		FollowersCount: followers,
+		ListedCount:    rng.Intn(followers/10 + 1),
+		StatusesCount:  ns.statusesCount(n),
+		CreatedAt:      created.Format(time.RubyDate),
+		Location:       locations[rng.Intn(len(locations))],
+	}
+}
+
+// statusesCount of node n drawn from a heavy-tailed distribution
+func (ns *Synthetic) statusesCount(n int) int {
+	return int(ns.rng("statuses", n).ExpFloat64()*500) + 1
+}
+
+// tweetID encodes the author and rank of a tweet so that replies can point to existing tweets
+func tweetID(n int, k int) uint64 {
+	return uint64(FirstID+n)<<20 | uint64(k)
+}
+
+// tweet returns the k-th most recent tweet of node n, some are replies to or mention friends
+func (ns *Synthetic) tweet(n int, k int) twitter.TweetObject {
+	var result twitter.TweetObject
+	var text []string
+
+	rng := ns.rng(fmt.Sprintf("tweet/%d", k), n)
+	result.ID = tweetID(n, k)
+	result.User.ScreenName = screenName(n)
+	result.CreatedAt = epoch.Add(-time.Duration(k*24+rng.Intn(24)) * time.Hour).Add(-time.Duration(n) * time.Second).Format(time.RubyDate)
+	friends := ns.graph.Following[n]
+	if len(friends) > 0 && rng.Float64() < 0.3 {
+		f := friends[rng.Intn(len(friends))]
+		result.InReplyToUser = uint64(FirstID + f)
+		result.InReplyToScreenName = screenName(f)
+		result.InReplyToTweet = tweetID(f, rng.Intn(ns.statusesCount(f)))
+		text = append(text, "@"+screenName(f))
+	} else if len(friends) > 0 && rng.Float64() < 0.2 {
+		text = append(text, "@"+screenName(friends[rng.Intn(len(friends))]))
+	}
+	for i, l := 0, 4+rng.Intn(10); i < l; i++ {
+		text = append(text, words[rng.Intn(len(words))])
+	}
+	if rng.Float64() < 0.3 {
+		text = append(text, hashtags[rng.Intn(len(hashtags))])
+	}
+	result.Text = strings.Join(text, " ")
+	followers := len(ns.graph.Followers[n])
+	result.RetweetCount = rng.Intn(followers/5 + 1)
+	result.FavoriteCount = rng.Intn(followers/2 + 1)
+	return result
+}
+
+// timeline returns up to count most recent tweets of node n
+func (ns *Synthetic) timeline(n int, count int) []twitter.TweetObject {
+	if c := ns.statusesCount(n); c < count {
+		count = c
+	}
+	result := make([]twitter.TweetObject, count)
+	for k := range result {
+		result[k] = ns.tweet(n, k)
+	}
+	return result
+}
+
+// ids converts nodes to the IDs written in .dat and fdat files
+func ids(nodes []int) []string {
+	result := make([]string, len(nodes))
+	for i, n := range nodes {
+		result[i] = fmt.Sprintf("%d", FirstID+n)
+	}
+	return result
+}
+
+// members returns the nodes in the block named list, blocks are named block0, block1...
+func (ns *Synthetic) members(list string) ([]int, error) {
+	var result []int
+
+	b, err := strconv.Atoi(strings.TrimPrefix(list, "block"))
+	if ns.Config.Model != "sbm" || err != nil {
+		return nil, errors.New("lists are the blocks of the sbm model named block0, block1...")
+	}
+	for n, block := range ns.graph.Block {
+		if block == b {
+			result = append(result, n)
+		}
+	}
+	return result, nil
+}
+
+// Init supports retrieve handles from: block membership, a query file, followers who reply or a friend/follow relationship
+func (ns Synthetic) Init(ctx context.Context, opts sns.InitOptions, args []string) (string, error) {
+	var nodes []int
+	var relation string
+	var err error
+
+	if opts.Images {
+		log.Println("synthetic accounts have no images")
+	}
+	if err = ns.load(); err != nil {
+		return "", err
+	}
+
+	if opts.Followers {
+		relation = "followers"
+	} else {
+		relation = "friends"
+	}
+
+	switch {
+	case opts.List != "":
+		// block members in place of list members
+		nodes, err = ns.members(opts.List)
+		relation = opts.List
+	case opts.Query:
+		// query search or manually created query file
+		var handles []string
+		if handles, err = util.QueryReader(args[0], opts.NoMention); err != nil {
+			return "", err
+		}
+		for _, handle := range handles {
+			n, err := ns.node(handle)
+			if err != nil {
+				return "", err
+			}
+			nodes = append(nodes, n)
+		}
+	case opts.MaxPostCount > 0:
+		// followers who reply to this handle
+		var n int
+		if n, err = ns.node(args[0]); err != nil {
+			return "", err
+		}
+		for _, f := range ns.graph.Followers[n] {
+			for _, t := range ns.timeline(f, opts.MaxPostCount) {
+				if t.InReplyToScreenName == screenName(n) {
+					nodes = append(nodes, f)
+					break
+				}
+			}
+		}
+		relation = "retweeter"
+	default:
+		var n int
+		if n, err = ns.node(args[0]); err == nil {
+			if opts.Followers {
+				nodes = ns.graph.Followers[n]
+			} else {
+				nodes = ns.graph.Following[n]
+			}
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	result := make([]twitter.UserObject, len(nodes))
+	for i, n := range nodes {
+		result[i] = ns.user(n)
+		result[i].Relation = relation
+		result[i].Subject = args[0]
+	}
+	filename, err := util.CSVWriter(args[0], util.DatExt, false, result)
+	if err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	log.Printf("processed %d users\n", len(result))
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// Fetch writes second-degree "friends" of handles collected with Init
+func (ns Synthetic) Fetch(ctx context.Context, opts sns.FetchOptions, args []string) error {
+	if err := ns.load(); err != nil {
+		return err
+	}
+
+	data := []twitter.UserObject{}
+	if err := util.CSVReader(args[0], util.DatExt, &data); err != nil {
+		return err
+	}
+	for _, user := range data {
+		uid := fmt.Sprintf("%d", user.ID)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// skip if file exists and flag to force call not set
+		if !opts.Force && util.FdatExists(uid) {
+			continue
+		}
+		if user.FriendsCount > opts.MaxFriends {
+			log.Printf("skipping %s (%d friends)\n", user.ScreenName, user.FriendsCount)
+			continue
+		}
+		n, err := ns.node(uid)
+		if err != nil {
+			return err
+		}
+		if _, err := util.FdatWriter(uid, ids(ns.graph.Following[n])); err != nil {
+			return fmt.Errorf("failed to write friends file: %w", err)
+		}
+		log.Printf("processed %s\n", user.ScreenName)
+	}
+	return nil
+}
+
+// Edgelist constructs the network of who is "friends" with whom among handles returned by Init
+func (ns Synthetic) Edgelist(ctx context.Context, opts sns.EdgelistOptions, args []string) (string, error) {
+	var cols = []string{
+		"ID",
+		"ScreenName",
+		"Protected",
+		"Verified",
+		"FriendsCount",
+		"FollowersCount",
+		"ListedCount",
+		"StatusesCount",
+		"CreatedAt",
+		"ProfileImageURL",
+		"Relation",
+		"Subject",
+	}
+	var filename string
+	var err error
+
+	data := []twitter.UserObject{}
+	for _, handle := range args {
+		if err = util.CSVReader(handle, util.DatExt, &data); err != nil {
+			return "", err
+		}
+		if opts.Ego {
+			if err = ns.load(); err != nil {
+				return "", err
+			}
+			n, err := ns.node(handle)
+			if err != nil {
+				return "", err
+			}
+			data = append(data, ns.user(n))
+		}
+	}
+	// call GMLWriter using ScreenName as label for nodes
+	if filename, err = util.GMLWriter(args, data, opts.Missing, cols, "ScreenName"); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// Posts generates tweets matching a query, from a block, replies to a given tweet ID or from a handle
+func (ns Synthetic) Posts(ctx context.Context, opts sns.PostsOptions, args []string) (string, error) {
+	var result []twitter.TweetObject
+	var postID uint64
+	var err error
+
+	if opts.PostID != "" {
+		if postID, err = strconv.ParseUint(opts.PostID, 10, 64); err != nil {
+			return "", fmt.Errorf("invalid tweet id %s", opts.PostID)
+		}
+	}
+	if err = ns.load(); err != nil {
+		return "", err
+	}
+
+	switch {
+	case opts.Query:
+		// a hundred accounts happen to tweet about the query
+		rng := ns.rng("query/"+args[0], 0)
+		for k := 0; k < 100; k++ {
+			n := rng.Intn(len(ns.graph.Following))
+			t := ns.tweet(n, k)
+			t.Text += " " + args[0]
+			result = append(result, t)
+		}
+	case opts.List != "":
+		nodes, err := ns.members(opts.List)
+		if err != nil {
+			return "", err
+		}
+		for _, n := range nodes {
+			result = append(result, ns.timeline(n, 20)...)
+		}
+	case postID != 0:
+		// followers of the author reply to the tweet
+		n := int(postID>>20) - FirstID
+		if n < 0 || n >= len(ns.graph.Followers) {
+			return "", fmt.Errorf("tweet %d is not part of the synthetic network", postID)
+		}
+		rng := ns.rng(fmt.Sprintf("replies/%d", postID), n)
+		for _, f := range ns.graph.Followers[n] {
+			if rng.Float64() < 0.2 {
+				t := ns.tweet(f, rng.Intn(ns.statusesCount(f)))
+				t.InReplyToTweet = postID
+				t.InReplyToUser = uint64(FirstID + n)
+				t.InReplyToScreenName = screenName(n)
+				t.Text = "@" + screenName(n) + " " + t.Text
+				result = append(result, t)
+			}
+		}
+	default:
+		n, err := ns.node(args[0])
+		if err != nil {
+			return "", err
+		}
+		result = ns.timeline(n, 200)
+	}
+	if len(result) == 0 {
+		return "", nil
+	}
+	filename, err := util.CSVWriter(args[0], util.QueryExt, false, result)
+	if err != nil {
+		return "", fmt.Errorf("failed to write posts: %w", err)
+	}
+	log.Printf("%s created\n", filename)
+	log.Printf("processed %d tweets\n", len(result))
+	return filename, nil
+}
+
+// Resolve converts screen names to IDs and vice versa along with basic stats
+func (ns Synthetic) Resolve(ctx context.Context, args []string) ([]sns.Profile, error) {
+	var result []sns.Profile
+
+	if err := ns.load(); err != nil {
+		return nil, err
+	}
+
+	for _, handle := range args {
+		n, err := ns.node(handle)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, ns.user(n).Profile(handle))
+	}
+	return result, nil
+}
@@ -0,0 +1,53 @@
+package synthetic
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/twitter"
+	"github.com/jdevoo/nucoll/util"
+	"github.com/jdevoo/nucoll/util/utiltest"
+)
+
+func TestInitFetch(t *testing.T) {
+	utiltest.InWorkspace(t)
+	c := DefaultConfig
+	c.Nodes = 100
+	ns := Synthetic{Config: &c}
+	ns.load()
+
+	ctx := context.Background()
+	if _, err := ns.Init(ctx, sns.InitOptions{}, []string{"user0"}); err != nil {
+		t.Fatal(err)
+	}
+	data := []twitter.UserObject{}
+	if err := util.CSVReader("user0", util.DatExt, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != len(ns.graph.Following[0]) {
+		t.Fatalf("got %d friends, want %d", len(data), len(ns.graph.Following[0]))
+	}
+	first := data[0]
+	if first.ScreenName != fmt.Sprintf("user%d", first.ID-FirstID) || first.CreatedAt == "" || first.StatusesCount == 0 {
+		t.Errorf("unexpected user %+v", first)
+	}
+
+	if err := ns.Fetch(ctx, sns.FetchOptions{MaxFriends: 5000}, []string{"user0"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(util.WorkspacePath(fmt.Sprintf("%s/%d%s", util.FdatDir, first.ID, util.FdatExt)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(b)); !reflect.DeepEqual(got, ids(ns.graph.Following[first.ID-FirstID])) {
+		t.Errorf("got friends %v", got)
+	}
+	if !reflect.DeepEqual(ns.timeline(3, 5), ns.timeline(3, 5)) {
+		t.Error("timeline is not reproducible")
+	}
+}
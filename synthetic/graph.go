@@ -0,0 +1,232 @@
+package synthetic
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Config holds the generator parameters stored in the "synthetic" section
+// Degree is m for ba, k for ws and the minimum degree for config
+type Config struct {
+	Model       string  `json:"model"`
+	Nodes       int     `json:"nodes"`
+	Seed        int64   `json:"seed"`
+	Degree      int     `json:"degree"`
+	Rewire      float64 `json:"rewire"`
+	Blocks      int     `json:"blocks"`
+	PIn         float64 `json:"p_in"`
+	POut        float64 `json:"p_out"`
+	Exponent    float64 `json:"exponent"`
+	Reciprocity float64 `json:"reciprocity"`
+}
+
+// DefaultConfig generates a scale-free network of a thousand accounts
+var DefaultConfig = Config{
+	Model:       "ba",
+	Nodes:       1000,
+	Seed:        1,
+	Degree:      4,
+	Rewire:      0.1,
+	Blocks:      4,
+	PIn:         0.05,
+	POut:        0.002,
+	Exponent:    2.5,
+	Reciprocity: 0.3,
+}
+
+// Graph is a directed follow graph where Following[u] lists the nodes u follows
+type Graph struct {
+	Following [][]int
+	Followers [][]int
+	Block     []int
+}
+
+// Generate builds the graph described by c, the same config always yields the same graph
+func Generate(c Config) (*Graph, error) {
+	var edges [][2]int
+
+	if c.Nodes < 2 {
+		return nil, fmt.Errorf("at least 2 nodes required, got %d", c.Nodes)
+	}
+	rng := rand.New(rand.NewSource(c.Seed))
+	g := &Graph{Block: make([]int, c.Nodes)}
+	switch c.Model {
+	case "ba":
+		edges = barabasiAlbert(rng, c.Nodes, c.Degree)
+	case "ws":
+		edges = wattsStrogatz(rng, c.Nodes, c.Degree, c.Rewire)
+	case "sbm":
+		if c.Blocks < 1 {
+			return nil, fmt.Errorf("at least 1 block required, got %d", c.Blocks)
+		}
+		for i := range g.Block {
+			g.Block[i] = i * c.Blocks / c.Nodes
+		}
+		edges = stochasticBlock(rng, g.Block, c.PIn, c.POut)
+	case "config":
+		edges = configuration(rng, c.Nodes, c.Degree, c.Exponent)
+	default:
+		return nil, fmt.Errorf("unknown model %q, use one of ba, ws, sbm or config", c.Model)
+	}
+
+	// follow back with probability reciprocity, duplicates and self loops are dropped
+	out := make([]map[int]bool, c.Nodes)
+	for i := range out {
+		out[i] = make(map[int]bool)
+	}
+	for _, e := range edges {
+		if e[0] == e[1] {
+			continue
+		}
+		out[e[0]][e[1]] = true
+		if rng.Float64() < c.Reciprocity {
+			out[e[1]][e[0]] = true
+		}
+	}
+	g.Following = make([][]int, c.Nodes)
+	g.Followers = make([][]int, c.Nodes)
+	for u := range out {
+		for v := range out[u] {
+			g.Following[u] = append(g.Following[u], v)
+			g.Followers[v] = append(g.Followers[v], u)
+		}
+	}
+	for u := range out {
+		sort.Ints(g.Following[u])
+		sort.Ints(g.Followers[u])
+	}
+	return g, nil
+}
+
+// barabasiAlbert lets each new node follow m existing nodes chosen proportionally to their degree
+func barabasiAlbert(rng *rand.Rand, n int, m int) [][2]int {
+	var edges [][2]int
+	var targets []int
+
+	if m < 1 {
+		m = 1
+	}
+	if m >= n {
+		m = n - 1
+	}
+	// seed with a small clique so that every early node has a degree
+	for u := 0; u <= m; u++ {
+		for v := 0; v < u; v++ {
+			edges = append(edges, [2]int{u, v})
+			targets = append(targets, u, v)
+		}
+	}
+	for u := m + 1; u < n; u++ {
+		chosen := make(map[int]bool)
+		for len(chosen) < m {
+			chosen[targets[rng.Intn(len(targets))]] = true
+		}
+		// map iteration order is random, sort to keep runs reproducible
+		picks := make([]int, 0, m)
+		for v := range chosen {
+			picks = append(picks, v)
+		}
+		sort.Ints(picks)
+		for _, v := range picks {
+			edges = append(edges, [2]int{u, v})
+			targets = append(targets, u, v)
+		}
+	}
+	return edges
+}
+
+// wattsStrogatz connects each node to its k nearest ring neighbors and rewires edges with probability p
+// edge direction is drawn at random
+func wattsStrogatz(rng *rand.Rand, n int, k int, p float64) [][2]int {
+	var edges [][2]int
+
+	if k < 2 {
+		k = 2
+	}
+	if k >= n {
+		k = n - 1
+	}
+	linked := make(map[[2]int]bool)
+	for u := 0; u < n; u++ {
+		for j := 1; j <= k/2; j++ {
+			v := (u + j) % n
+			if rng.Float64() < p {
+				// rewire to a node not yet linked to u
+				for tries := 0; tries < n; tries++ {
+					w := rng.Intn(n)
+					if w != u && !linked[[2]int{u, w}] {
+						v = w
+						break
+					}
+				}
+			}
+			if linked[[2]int{u, v}] {
+				continue
+			}
+			linked[[2]int{u, v}] = true
+			linked[[2]int{v, u}] = true
+			if rng.Intn(2) == 0 {
+				edges = append(edges, [2]int{u, v})
+			} else {
+				edges = append(edges, [2]int{v, u})
+			}
+		}
+	}
+	return edges
+}
+
+// stochasticBlock draws each directed edge with probability pin within blocks and pout across blocks
+func stochasticBlock(rng *rand.Rand, block []int, pin float64, pout float64) [][2]int {
+	var edges [][2]int
+
+	for u := range block {
+		for v := range block {
+			if u == v {
+				continue
+			}
+			p := pout
+			if block[u] == block[v] {
+				p = pin
+			}
+			if rng.Float64() < p {
+				edges = append(edges, [2]int{u, v})
+			}
+		}
+	}
+	return edges
+}
+
+// configuration pairs degree stubs drawn from a power law with the given exponent and minimum degree
+// edge direction is drawn at random
+func configuration(rng *rand.Rand, n int, min int, exponent float64) [][2]int {
+	var edges [][2]int
+	var stubs []int
+
+	if min < 1 {
+		min = 1
+	}
+	if exponent <= 1 {
+		exponent = 2.5
+	}
+	for u := 0; u < n; u++ {
+		// inverse transform sampling of a continuous power law
+		d := int(float64(min) * math.Pow(1-rng.Float64(), -1/(exponent-1)))
+		if d >= n {
+			d = n - 1
+		}
+		for i := 0; i < d; i++ {
+			stubs = append(stubs, u)
+		}
+	}
+	rng.Shuffle(len(stubs), func(i, j int) { stubs[i], stubs[j] = stubs[j], stubs[i] })
+	for i := 0; i+1 < len(stubs); i += 2 {
+		if rng.Intn(2) == 0 {
+			edges = append(edges, [2]int{stubs[i], stubs[i+1]})
+		} else {
+			edges = append(edges, [2]int{stubs[i+1], stubs[i]})
+		}
+	}
+	return edges
+}
@@ -0,0 +1,61 @@
+package synthetic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	for _, model := range []string{"ba", "ws", "sbm", "config"} {
+		c := DefaultConfig
+		c.Model = model
+		c.Nodes = 200
+		g, err := Generate(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		edges := 0
+		for u, friends := range g.Following {
+			for _, v := range friends {
+				if u == v {
+					t.Errorf("%s: self loop on %d", model, u)
+				}
+			}
+			edges += len(friends)
+		}
+		followers := 0
+		for _, f := range g.Followers {
+			followers += len(f)
+		}
+		if edges == 0 || edges != followers {
+			t.Errorf("%s: %d friendships but %d followerships", model, edges, followers)
+		}
+		again, _ := Generate(c)
+		if !reflect.DeepEqual(g, again) {
+			t.Errorf("%s: same seed generated different graphs", model)
+		}
+		c.Seed++
+		other, _ := Generate(c)
+		if reflect.DeepEqual(g.Following, other.Following) {
+			t.Errorf("%s: different seeds generated the same graph", model)
+		}
+	}
+	if _, err := Generate(Config{Model: "er", Nodes: 10}); err == nil {
+		t.Error("unknown model accepted")
+	}
+}
+
+func TestBarabasiAlbertDegree(t *testing.T) {
+	c := DefaultConfig
+	c.Reciprocity = 0
+	g, err := Generate(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every node after the seed clique follows exactly m nodes
+	for u := c.Degree + 1; u < c.Nodes; u++ {
+		if len(g.Following[u]) != c.Degree {
+			t.Fatalf("user%d follows %d, want %d", u, len(g.Following[u]), c.Degree)
+		}
+	}
+}
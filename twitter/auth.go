@@ -0,0 +1,149 @@
+package twitter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jdevoo/nucoll/util"
+)
+
+// tokenErrors are the codes of 401 responses rejecting the credentials rather than the resource
+// https://developer.twitter.com/en/support/twitter-api/error-troubleshooting
+var tokenErrors = map[int]bool{
+	32:  true, // could not authenticate you
+	89:  true, // invalid or expired token
+	99:  true, // unable to verify your credentials
+	215: true, // bad authentication data
+}
+
+// TokenError is returned when Twitter rejects the credentials themselves, e.g. a revoked bearer token
+// which could not be renewed
+type TokenError struct {
+	Credential string
+	Message    string
+}
+
+func (e *TokenError) Error() string {
+	return fmt.Sprintf("credentials of %s rejected: %s", e.Credential, e.Message)
+}
+
+// UnauthorizedError is returned for a resource the credentials may not access
+// such as the friends or tweets of a protected account
+type UnauthorizedError struct {
+	Resource string
+	Message  string
+}
+
+func (e *UnauthorizedError) Error() string {
+	return fmt.Sprintf("not authorized to access %s: %s", e.Resource, e.Message)
+}
+
+// token returns the bearer token of c, which may be renewed by another request
+func (t *NucollTransport) token(c *credential) string {
+	t.authMu.Lock()
+	defer t.authMu.Unlock()
+	return c.config.AccessToken
+}
+
+// unauthorized tells a rejected token from a resource out of reach given the 401 response to req sent with c
+// it renews a rejected bearer token once, reporting whether req may be sent again
+func (t *NucollTransport) unauthorized(req *http.Request, res *http.Response, c *credential, renewed bool) (bool, error) {
+	var body struct {
+		APIErrors
+		Error string `json:"error"`
+		// problem details of v2, whose 401 responses only reject the credentials
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+		Status int    `json:"status"`
+	}
+
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	json.Unmarshal(b, &body)
+	rejected := ""
+	for _, e := range body.Errors {
+		if tokenErrors[e.Code] {
+			rejected = e.Message
+			break
+		}
+	}
+	if body.Status == http.StatusUnauthorized && body.Title != "" {
+		rejected = body.Title
+		if body.Detail != "" && body.Detail != body.Title {
+			rejected += ": " + body.Detail
+		}
+	}
+	if rejected != "" {
+		if t.UserContext || renewed {
+			return false, util.Permanent(&TokenError{Credential: c.label, Message: rejected})
+		}
+		used := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if err := t.renew(c, used); err != nil {
+			return false, util.Permanent(&TokenError{Credential: c.label, Message: fmt.Sprintf("%s, failed to renew: %s", rejected, err)})
+		}
+		return true, nil
+	}
+	message := body.Error
+	if message == "" {
+		message = res.Status
+	}
+	resource := req.URL.Path
+	if req.URL.RawQuery != "" {
+		resource += "?" + req.URL.RawQuery
+	}
+	return false, util.Permanent(&UnauthorizedError{Resource: resource, Message: message})
+}
+
+// renew runs the oauth2/token exchange again for c unless its token differs from the rejected one,
+// i.e. another request renewed it already
+// the consumer credentials are those of c, else taken from the environment or asked for
+func (t *NucollTransport) renew(c *credential, rejected string) error {
+	t.authMu.Lock()
+	defer t.authMu.Unlock()
+	if c.config.AccessToken != rejected {
+		return nil
+	}
+	key, secret := c.config.ConsumerKey, c.config.ConsumerSecret
+	if key == "" || secret == "" {
+		var err error
+		if key, secret, err = t.consumer(); err != nil {
+			return err
+		}
+	}
+	if err := t.getToken(c.config, key, secret); err != nil {
+		return err
+	}
+	if c.config.AccessToken == rejected {
+		return fmt.Errorf("token of %s was not replaced", c.label)
+	}
+	log.Printf("token of %s rejected, obtained a new one\n", c.label)
+	return t.saveConfig()
+}
+
+// invalidateToken revokes the bearer token of the config with the given consumer credentials
+// https://developer.twitter.com/en/docs/authentication/api-reference/invalidate_bearer_token
+func (t *NucollTransport) invalidateToken(ctx context.Context, consumerKey string, consumerSecret string) error {
+	form := url.Values{"access_token": {t.Config.AccessToken}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpointURL(t.BaseURL, "oauth2/invalidate_token", ""), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(consumerKey, consumerSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return apiError(res, body)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package twitter
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jdevoo/nucoll/util"
+	"github.com/jdevoo/nucoll/util/utiltest"
+)
+
+// counting returns a server passing requests on to srv along with the number of calls made
+func counting(srv *httptest.Server) (*httptest.Server, *int) {
+	calls := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		srv.Config.Handler.ServeHTTP(w, r)
+	})), &calls
+}
+
+func TestUnauthorized(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+	proxy, calls := counting(srv)
+	defer proxy.Close()
+
+	retry := util.Retry{MaxAttempts: 3, MaxElapsed: time.Minute, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	config := &util.NucollConfig{TwitterConfig: util.TwitterConfig{TokenType: "bearer", AccessToken: "token"}}
+	client := &http.Client{Transport: &NucollTransport{Config: config, Retry: retry, Transport: http.DefaultTransport}}
+	ns := Twitter{Client: client, BaseURL: proxy.URL}
+	_, err := ns.ids(context.Background(), "friends", "erin")
+	var unauthorized *UnauthorizedError
+	if !errors.As(err, &unauthorized) || unauthorized.Message != "Not authorized." {
+		t.Fatalf("expected UnauthorizedError, actual %v", err)
+	}
+	if *calls != 1 {
+		t.Errorf("expected no retry, actual %d calls", *calls)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+	util.ConfigFlag = filepath.Join(utiltest.InWorkspace(t), "nucoll.json")
+	defer func() { util.ConfigFlag = "" }()
+
+	config := &util.NucollConfig{TwitterConfig: util.TwitterConfig{TokenType: "bearer", AccessToken: "revoked", ConsumerKey: "key", ConsumerSecret: "secret"}}
+	client := &http.Client{Transport: &NucollTransport{Config: config, BaseURL: srv.URL, Transport: http.DefaultTransport}}
+	ns := Twitter{Client: client, BaseURL: srv.URL}
+	profiles, err := ns.Resolve(context.Background(), []string{"bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 1 || profiles[0].ID != "2" || config.AccessToken != "token" {
+		t.Errorf("expected renewed token, actual %v %+v", profiles, config.TwitterConfig)
+	}
+	stored, err := util.ReadConfig()
+	if err != nil || stored.AccessToken != "token" {
+		t.Errorf("expected renewed token to be stored, actual %+v (%v)", stored, err)
+	}
+
+	// a token rejected right after being renewed is not renewed again
+	tr := client.Transport.(*NucollTransport)
+	req := httptest.NewRequest(http.MethodGet, endpointURL(srv.URL, "users/show", "screen_name=bob"), nil)
+	res := &http.Response{StatusCode: http.StatusUnauthorized, Body: ioutil.NopCloser(strings.NewReader(`{"errors":[{"code":89,"message":"Invalid or expired token."}]}`))}
+	again, err := tr.unauthorized(req, res, tr.active(), true)
+	var tokenErr *TokenError
+	if again || !errors.As(err, &tokenErr) || util.Transient(req, nil, err) {
+		t.Errorf("expected permanent TokenError, actual %v", err)
+	}
+}
+
+func TestRenewV2(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+	util.ConfigFlag = filepath.Join(utiltest.InWorkspace(t), "nucoll.json")
+	defer func() { util.ConfigFlag = "" }()
+
+	config := &util.NucollConfig{TwitterConfig: util.TwitterConfig{TokenType: "bearer", AccessToken: "revoked", ConsumerKey: "key", ConsumerSecret: "secret"}}
+	client := &http.Client{Transport: &NucollTransport{Config: config, BaseURL: srv.URL, Transport: http.DefaultTransport}}
+	ns := TwitterV2{Client: client, BaseURL: srv.URL}
+	profiles, err := ns.Resolve(context.Background(), []string{"alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 1 || profiles[0].ID != "1" || config.AccessToken != "token" {
+		t.Errorf("expected renewed token, actual %v %+v", profiles, config.TwitterConfig)
+	}
+
+	// without consumer credentials the problem details are reported as a rejected token
+	tr := client.Transport.(*NucollTransport)
+	req := httptest.NewRequest(http.MethodGet, srv.URL+"/2/users/by/username/alice", nil)
+	res := &http.Response{StatusCode: http.StatusUnauthorized, Body: ioutil.NopCloser(strings.NewReader(`{"title":"Unauthorized","type":"about:blank","status":401,"detail":"Unauthorized"}`))}
+	_, err = tr.unauthorized(req, res, tr.active(), true)
+	var tokenErr *TokenError
+	if !errors.As(err, &tokenErr) || tokenErr.Message != "Unauthorized" {
+		t.Errorf("expected TokenError, actual %v", err)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+	util.ConfigFlag = filepath.Join(utiltest.InWorkspace(t), "nucoll.json")
+	defer func() { util.ConfigFlag = "" }()
+
+	config := &util.NucollConfig{TwitterConfig: util.TwitterConfig{TokenType: "bearer", AccessToken: "token", ConsumerKey: "key", ConsumerSecret: "secret"}}
+	if err := util.WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Twitter{BaseURL: srv.URL}.Invalidate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "token "+fingerprint(&config.TwitterConfig)+" invalidated" {
+		t.Errorf("unexpected %q", result)
+	}
+	if stored, err := util.ReadConfig(); err != nil || stored.AccessToken != "" || stored.ConsumerKey != "key" {
+		t.Errorf("expected token to be removed, actual %+v (%v)", stored, err)
+	}
+}
@@ -10,17 +10,39 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"strconv"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jdevoo/nucoll/util"
 )
 
 // NucollTransport holds the config and the structure to deal with throttling
+// BaseURL is where tokens are requested, requests are paced by Scheduler when set
+// and transient failures are retried according to Retry, the zero value making a single attempt
+// requests are signed with the OAuth 1.0a credentials of the config in UserContext
+// and carry the bearer token otherwise
+// the top-level credentials paced by Scheduler come first in a pool which is rotated
+// when the rate limit of the active credential is reached
+// user objects found in Cache are served without being paced or counted against any window
+// each request sent to the API is appended to Audit when set
+// credentials given by environment variables are not stored, which fromEnv records
 type NucollTransport struct {
-	Config    *util.NucollConfig
-	Transport http.RoundTripper
+	Config      *util.NucollConfig
+	BaseURL     string
+	UserContext bool
+	Scheduler   *Scheduler
+	Retry       util.Retry
+	Cache       *util.Cache
+	Audit       *util.AuditLog
+	Transport   http.RoundTripper
+	mu          sync.Mutex
+	pool        []*credential
+	current     int
+	fromEnv     bool
+	authMu      sync.Mutex
 }
 
 // APIError to hold message and code
@@ -34,28 +56,161 @@ type APIErrors struct {
 	Errors []APIError `json:"errors"`
 }
 
-// RoundTrip intercepts API responses and checks if a throttling pause is required
-func (t *NucollTransport) RoundTrip(req *http.Request) (res *http.Response, err error) {
-	if t.Config.AccessToken != "" {
-		req.Header.Add("Authorization", "Bearer "+t.Config.AccessToken)
+// rateLimit headers sent with every response
+// https://developer.twitter.com/en/docs/basics/rate-limiting
+var rateLimit = util.RateLimit{Remaining: "x-rate-limit-remaining", Reset: "x-rate-limit-reset"}
+
+// cached matches the paths of v2 user lookups below /2/ which are cached like users/show and users/lookup
+var cached = regexp.MustCompile(`/2/users(/by(/username/[^/]+)?|/\d+)?$`)
+
+// cacheable reports whether the response to path is a user object which may be reused
+// friends, followers, members, timelines and searches are always fetched anew
+// so that fetch -f and repeated tweets commands collect current data
+func cacheable(path string) bool {
+	switch family(path) {
+	case "/users/show", "/users/lookup":
+		return true
+	}
+	return cached.MatchString(path)
+}
+
+// RoundTrip intercepts API responses and checks if a throttling pause or another attempt is required
+// only user objects are cached
+func (t *NucollTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Cache == nil || !cacheable(req.URL.Path) {
+		return t.Retry.Do(req, func() (*http.Response, error) {
+			return t.attempt(req)
+		})
+	}
+	key := util.CacheKey(req, t.scope())
+	if res := t.Cache.Get(req, key); res != nil {
+		return res, nil
+	}
+	res, err := t.Retry.Do(req, func() (*http.Response, error) {
+		return t.attempt(req)
+	})
+	if err != nil {
+		return res, err
+	}
+	return t.Cache.Put(req, key, res)
+}
+
+// scope separates cached responses obtained in user context, which may include protected accounts
+func (t *NucollTransport) scope() string {
+	if t.UserContext {
+		return "user:" + t.Config.ScreenName
+	}
+	return ""
+}
+
+// send authorizes req with c and passes it on, signatures are computed anew each time since nonces cannot be reused
+func (t *NucollTransport) send(req *http.Request, c *credential) (*http.Response, error) {
+	switch {
+	case t.UserContext:
+		if err := sign(req, *c.config, c.config.OAuthToken, c.config.OAuthTokenSecret, nil); err != nil {
+			return nil, err
+		}
+	default:
+		if token := t.token(c); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	if len(t.pool) > 1 {
+		log.Printf("%s %s served by %s\n", req.Method, req.URL.Path, c.label)
+	}
+	if t.Audit == nil {
+		return t.Transport.RoundTrip(req)
+	}
+	start := time.Now()
+	res, err := t.Transport.RoundTrip(req)
+	return t.audit(req, res, err, c, start)
+}
+
+// audit appends the record of a request sent with c to the audit log
+// the response body is read to count its bytes, failing to write the record is only logged
+func (t *NucollTransport) audit(req *http.Request, res *http.Response, err error, c *credential, start time.Time) (*http.Response, error) {
+	r := util.AuditRecord{
+		Time:       start,
+		Method:     req.Method,
+		Endpoint:   req.URL.Path,
+		Params:     req.URL.Query(),
+		Credential: c.label,
+	}
+	r.Command, r.Collection = util.CollectionOf(req.Context())
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		body, rerr := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if rerr != nil {
+			return nil, rerr
+		}
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.Status, r.Bytes = res.StatusCode, int64(len(body))
+		for _, h := range []string{"x-rate-limit-limit", "x-rate-limit-remaining", "x-rate-limit-reset"} {
+			if v := res.Header.Get(h); v != "" {
+				if r.RateLimit == nil {
+					r.RateLimit = make(map[string]string)
+				}
+				r.RateLimit[strings.TrimPrefix(h, "x-rate-limit-")] = v
+			}
+		}
+	}
+	r.DurationMS = time.Since(start).Milliseconds()
+	if aerr := t.Audit.Append(r); aerr != nil {
+		log.Printf("failed to write audit record: %s\n", aerr)
+	}
+	return res, err
+}
+
+// attempt sends req once paced by the scheduler of the credential picked for it
+// a 429 switches to another credential with quota, sleeping through throttling when there is none
+// a 401 renews a rejected bearer token once or fails with a TokenError or UnauthorizedError
+func (t *NucollTransport) attempt(req *http.Request) (res *http.Response, err error) {
+	f := family(req.URL.Path)
+	c := t.pick(f)
+	if c.scheduler != nil {
+		if err = c.scheduler.Wait(req); err != nil {
+			return nil, err
+		}
 	}
+	renewed := false
 RT:
-	for res, err = t.Transport.RoundTrip(req); err == nil; {
+	for res, err = t.send(req, c); err == nil; {
+		if c.scheduler != nil {
+			c.scheduler.Update(res)
+		}
+		if res.StatusCode == http.StatusTooManyRequests {
+			if next := t.rotate(c, f); next != nil {
+				res.Body.Close()
+				c = next
+				if c.scheduler != nil {
+					if err = c.scheduler.Wait(req); err != nil {
+						return nil, err
+					}
+				}
+				res, err = t.send(req, c)
+				continue
+			}
+		}
+		throttled, terr := rateLimit.Throttled(res)
+		if terr != nil {
+			return nil, terr
+		}
+		if throttled {
+			res, err = t.send(req, c)
+			continue
+		}
 		switch res.StatusCode {
 		case http.StatusUnauthorized:
-			log.Println(res.Status)
-			break RT
-		case http.StatusServiceUnavailable:
-			fallthrough
-		case http.StatusTooManyRequests:
-			x, err := strconv.ParseInt(res.Header.Get("x-rate-limit-reset"), 10, 64)
-			if err != nil {
-				return nil, err
+			var again bool
+			if again, err = t.unauthorized(req, res, c, renewed); again {
+				renewed = true
+				res, err = t.send(req, c)
+				continue
 			}
-			win := time.Unix(x+5, 0)
-			log.Printf("response code %d received; waiting until %s to resume", res.StatusCode, win.Format("15:04:05"))
-			time.Sleep(time.Until(win))
-			res, err = t.Transport.RoundTrip(req)
+			res = nil
+			break RT
 		case http.StatusOK:
 			break RT
 		default:
@@ -66,27 +221,20 @@ RT:
 	return res, err
 }
 
-func (t *NucollTransport) getToken(consumerKey string, consumerSecret string) error {
-	var endpoint = "https://api.twitter.com/oauth2/token"
+// getToken obtains the bearer token of an app and stores it in c
+func (t *NucollTransport) getToken(c *util.TwitterConfig, consumerKey string, consumerSecret string) error {
+	var endpoint = endpointURL(t.BaseURL, "oauth2/token", "")
 	req, _ := http.NewRequest("POST", endpoint, strings.NewReader("grant_type=client_credentials"))
 	req.SetBasicAuth(consumerKey, consumerSecret)
 	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
-	res, err := t.RoundTrip(req)
-	defer res.Body.Close()
+	res, err := t.Transport.RoundTrip(req)
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
 	body, _ := ioutil.ReadAll(res.Body)
 	if res.StatusCode != http.StatusOK {
-		var te APIErrors
-		if err := json.Unmarshal(body, &te); err != nil {
-			return errors.New(res.Status)
-		}
-		buf := bytes.NewBufferString("")
-		for i := range te.Errors {
-			fmt.Fprintf(buf, "%s (%d)\n", te.Errors[i].Message, te.Errors[i].Code)
-		}
-		return errors.New(buf.String())
+		return apiError(res, body)
 	}
 	var conf util.TwitterConfig
 	if err := json.Unmarshal(body, &conf); err != nil {
@@ -95,40 +243,161 @@ func (t *NucollTransport) getToken(consumerKey string, consumerSecret string) er
 	if conf.TokenType != "bearer" {
 		return errors.New("invalid token type")
 	}
-	t.Config.TokenType = conf.TokenType
-	t.Config.AccessToken = conf.AccessToken
+	c.TokenType = conf.TokenType
+	c.AccessToken = conf.AccessToken
 	return nil
 }
 
+// apiError returns the messages of the error body of res or its status
+func apiError(res *http.Response, body []byte) error {
+	var te APIErrors
+	if err := json.Unmarshal(body, &te); err != nil || len(te.Errors) == 0 {
+		return errors.New(res.Status)
+	}
+	buf := bytes.NewBufferString("")
+	for i := range te.Errors {
+		fmt.Fprintf(buf, "%s (%d)\n", te.Errors[i].Message, te.Errors[i].Code)
+	}
+	return errors.New(strings.TrimSpace(buf.String()))
+}
+
+// errHeadless explains how to provide credentials when they cannot be asked for
+var errHeadless = errors.New("no Twitter credentials and stdin is not a terminal: set NUCOLL_BEARER_TOKEN, or NUCOLL_CONSUMER_KEY and NUCOLL_CONSUMER_SECRET, or pass -config with a file holding a token")
+
+// consumer returns the credentials of the registered application taken from the environment,
+// in which case tokens obtained with them are not stored, or asks for them when stdin is a terminal
+func (t *NucollTransport) consumer() (string, string, error) {
+	key, secret := os.Getenv("NUCOLL_CONSUMER_KEY"), os.Getenv("NUCOLL_CONSUMER_SECRET")
+	if key != "" && secret != "" {
+		t.fromEnv = true
+		return key, secret, nil
+	}
+	if !util.Interactive() {
+		return "", "", errHeadless
+	}
+	key, secret = promptConsumer()
+	return key, secret, nil
+}
+
+// saveConfig stores the config unless its credentials were given by the environment
+func (t *NucollTransport) saveConfig() error {
+	if t.fromEnv {
+		return nil
+	}
+	return util.WriteConfig(t.Config)
+}
+
+// promptConsumer asks for the credentials of the registered application
+func promptConsumer() (string, string) {
+	fmt.Println(`
+===TWITTER API AUTHENTICATION SETUP==============================
+Open the following link and register this application...
+>>> https://apps.twitter.com/`)
+	fmt.Print("What is the consumer key? ")
+	reader := bufio.NewReader(os.Stdin)
+	consumerKey, _ := reader.ReadString('\n')
+	fmt.Print("What is the consumer secret? ")
+	consumerSecret, _ := reader.ReadString('\n')
+	return strings.TrimSpace(consumerKey), strings.TrimSpace(consumerSecret)
+}
+
 // NewClient implements application-only authentication for CLI usage
 // https://developer.twitter.com/en/docs/basics/authentication/overview/application-only
+// or user context when the auth mode is user, in which case the consumer credentials are stored
+// since every request is signed with them
+// credentials are asked for only when stdin is a terminal, headless runs take them from the environment
 func NewClient() (*http.Client, error) {
 	t := &NucollTransport{}
-	t.Transport = http.DefaultTransport
+	t.Transport = util.Transport()
 
 	var err error
 	if t.Config, err = util.ReadConfig(); err != nil {
 		return nil, err
 	}
-	if t.Config.TokenType == "" || t.Config.AccessToken == "" {
-		fmt.Println(`
-===TWITTER API AUTHENTICATION SETUP==============================
-Open the following link and register this application...
->>> https://apps.twitter.com/`)
-		fmt.Print("What is the consumer key? ")
-		reader := bufio.NewReader(os.Stdin)
-		consumerKey, _ := reader.ReadString('\n')
-		consumerKey = strings.TrimSpace(consumerKey)
-		fmt.Print("What is the consumer secret? ")
-		consumerSecret, _ := reader.ReadString('\n')
-		consumerSecret = strings.TrimSpace(consumerSecret)
-		if err = t.getToken(consumerKey, consumerSecret); err != nil {
-			return nil, err
+	t.BaseURL = t.Config.BaseURL("twitter", APIURL)
+	if t.Retry, err = t.Config.Retry("twitter"); err != nil {
+		return nil, err
+	}
+	switch util.AuthMode {
+	case "", "app":
+	case "user":
+		t.UserContext = true
+	default:
+		return nil, fmt.Errorf("unknown authentication mode %q, expected app or user", util.AuthMode)
+	}
+	// a bearer token given by the environment replaces the stored one
+	if token := os.Getenv("NUCOLL_BEARER_TOKEN"); token != "" && !t.UserContext {
+		t.Config.TokenType, t.Config.AccessToken = "bearer", token
+		t.fromEnv = true
+	}
+	// replayed exchanges need no credentials
+	switch {
+	case util.ReplayDir != "":
+	case t.UserContext && t.Config.OAuthToken == "":
+		if !util.Interactive() {
+			return nil, errors.New("signing in requires a terminal to enter the PIN, sign in once interactively and pass -config with the resulting file")
+		}
+		if t.Config.ConsumerKey == "" || t.Config.ConsumerSecret == "" {
+			if t.Config.ConsumerKey, t.Config.ConsumerSecret, err = t.consumer(); err != nil {
+				return nil, err
+			}
+		}
+		if err = t.signIn(promptPIN); err != nil {
+			return nil, fmt.Errorf("failed to sign in: %w", err)
 		}
+		log.Printf("signed in as %s\n", t.Config.ScreenName)
 		if err = util.WriteConfig(t.Config); err != nil {
 			return nil, err
 		}
+	case !t.UserContext && (t.Config.TokenType == "" || t.Config.AccessToken == ""):
+		consumerKey, consumerSecret, err := t.consumer()
+		if err != nil {
+			return nil, err
+		}
+		if err = t.getToken(&t.Config.TwitterConfig, consumerKey, consumerSecret); err != nil {
+			return nil, err
+		}
+		if err = t.saveConfig(); err != nil {
+			return nil, err
+		}
 	}
 
-	return &http.Client{Transport: t}, nil
+	if t.Cache, err = util.OpenCache(t.Config, "twitter"); err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: t}
+	// replays are not paced, leave the saved windows alone and make no calls to audit
+	if util.ReplayDir != "" {
+		return client, nil
+	}
+	if path := t.Config.AuditPath("twitter"); path != "" {
+		t.Audit = &util.AuditLog{Path: path}
+	}
+	path, err := util.ConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	// user context has its own limits
+	limits := "." + filepath.Base(os.Args[0]) + "-ratelimits"
+	if t.UserContext {
+		limits += "-user"
+	}
+	// as do the apps of other profiles
+	if p := t.Config.Profile(); p != util.DefaultProfile {
+		limits += "-" + p
+	}
+	if t.Scheduler, err = NewScheduler(filepath.Join(dir, limits)); err != nil {
+		return nil, err
+	}
+	// rate_limit_status is itself limited so windows saved by a previous run are reused until they reset
+	if !t.Scheduler.Current() {
+		if err = t.Scheduler.Seed(client, t.BaseURL); err != nil {
+			log.Printf("failed to seed rate limits: %s\n", err)
+		}
+	}
+	if err = t.loadPool(filepath.Join(dir, limits)); err != nil {
+		return nil, err
+	}
+	return client, nil
 }
@@ -1,19 +1,34 @@
 package twitter
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
+	"github.com/jdevoo/nucoll/sns"
 	"github.com/jdevoo/nucoll/util"
 )
 
+func init() {
+	sns.Register(sns.Backend{
+		Name:        "twitter",
+		Description: "Twitter API v1.1 (default)",
+		New:         func() sns.SocialNetworkService { return Twitter{} },
+		Audit:       true,
+	})
+}
+
 // Twitter client with custom RoundTripper to handle throttling
+// BaseURL defaults to the configured base URL, see APIURL
 type Twitter struct {
-	Client *http.Client
+	Client  *http.Client
+	BaseURL string
 }
 
 // IdsResult for ids REST call
@@ -70,19 +85,45 @@ type TweetObject struct {
 	FavoriteCount int `json:"favorite_count"`
 }
 
+// connect creates the client unless one was provided
+func (ns *Twitter) connect() error {
+	var err error
+
+	if ns.BaseURL == "" {
+		if ns.BaseURL, err = baseURL(); err != nil {
+			return fmt.Errorf("failed to read config: %w", err)
+		}
+	}
+	if ns.Client == nil {
+		if ns.Client, err = NewClient(); err != nil {
+			return fmt.Errorf("failed to create Twitter client: %w", err)
+		}
+	}
+	return nil
+}
+
+// get issues a GET request which is canceled along with ctx
+func (ns Twitter) get(ctx context.Context, endpoint string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ns.Client.Do(req)
+}
+
 // ids returns an array of numeric user IDs
-func (ns Twitter) ids(relation string, param string) ([]string, error) {
+func (ns Twitter) ids(ctx context.Context, relation string, param string) ([]string, error) {
 	var result IdsResult
 	var ids []string
 	var arg string
-	const endpoint = "https://api.twitter.com/1.1/%s/ids.json?%s&stringify_ids=true"
 
 	if util.DigitsOnly(param) {
 		arg = "user_id=" + param
 	} else {
 		arg = "screen_name=" + param
 	}
-	res, err := ns.Client.Get(fmt.Sprintf(endpoint, relation, arg))
+	endpoint := endpointURL(ns.BaseURL, relation+"/ids", arg+"&stringify_ids=true")
+	res, err := ns.get(ctx, endpoint)
 	if err != nil {
 		return nil, err
 	}
@@ -91,7 +132,7 @@ func (ns Twitter) ids(relation string, param string) ([]string, error) {
 	ids = append(ids, result.IDs...)
 	cursor := result.NextCursor
 	for cursor != 0 {
-		res, err = ns.Client.Get(fmt.Sprintf(endpoint+"&cursor=%d", relation, arg, cursor))
+		res, err = ns.get(ctx, fmt.Sprintf("%s&cursor=%d", endpoint, cursor))
 		if err != nil {
 			return nil, err
 		}
@@ -104,12 +145,12 @@ func (ns Twitter) ids(relation string, param string) ([]string, error) {
 }
 
 // members returns an array of hydrated nucoll user objects belonging to a list
-func (ns Twitter) members(list string, param string) ([]UserObject, error) {
+func (ns Twitter) members(ctx context.Context, list string, param string) ([]UserObject, error) {
 	var result MembersResult
 	var users []UserObject
-	const endpoint = "https://api.twitter.com/1.1/lists/members.json?slug=%s&owner_screen_name=%s&count=5000&skip_status=true"
+	endpoint := endpointURL(ns.BaseURL, "lists/members", fmt.Sprintf("slug=%s&owner_screen_name=%s&count=5000&skip_status=true", list, param))
 
-	res, err := ns.Client.Get(fmt.Sprintf(endpoint, list, param))
+	res, err := ns.get(ctx, endpoint)
 	if err != nil {
 		return nil, err
 	}
@@ -122,7 +163,7 @@ func (ns Twitter) members(list string, param string) ([]UserObject, error) {
 	users = append(users, result.Users...)
 	cursor := result.NextCursor
 	for cursor != 0 {
-		res, err = ns.Client.Get(fmt.Sprintf(endpoint+"&cursor=%d", list, param, cursor))
+		res, err = ns.get(ctx, fmt.Sprintf("%s&cursor=%d", endpoint, cursor))
 		if err != nil {
 			return nil, err
 		}
@@ -139,16 +180,16 @@ func (ns Twitter) members(list string, param string) ([]UserObject, error) {
 }
 
 // show returns a hydrated user object for a given handle
-func (ns Twitter) show(handle string) (UserObject, error) {
+func (ns Twitter) show(ctx context.Context, handle string) (UserObject, error) {
 	var endpoint string
 	var result UserObject
 
 	if util.DigitsOnly(handle) {
-		endpoint = "https://api.twitter.com/1.1/users/show.json?user_id=%s"
+		endpoint = endpointURL(ns.BaseURL, "users/show", "user_id="+handle)
 	} else {
-		endpoint = "https://api.twitter.com/1.1/users/show.json?screen_name=%s"
+		endpoint = endpointURL(ns.BaseURL, "users/show", "screen_name="+handle)
 	}
-	res, err := ns.Client.Get(fmt.Sprintf(endpoint, handle))
+	res, err := ns.get(ctx, endpoint)
 	if err != nil {
 		return result, err
 	}
@@ -158,24 +199,30 @@ func (ns Twitter) show(handle string) (UserObject, error) {
 	return result, nil
 }
 
-func (ns Twitter) retweetersOf(handle string, maxCount int) ([]string, error) {
+func (ns Twitter) retweetersOf(ctx context.Context, handle string, maxCount int) ([]string, error) {
 	var followers []string
 	var ids []string
 	var err error
 	var result SearchResult
-	var endpoint = "https://api.twitter.com/1.1/statuses/user_timeline.json?user_id=%s&count=200&include_rts=true"
+	var endpoint string
 
-	followers, err = ns.ids("followers", handle)
+	followers, err = ns.ids(ctx, "followers", handle)
 	if err != nil {
 		return nil, err
 	}
 	for _, id := range followers {
 	TWEETS:
 		for c, maxID := 0, uint64(0); c < maxCount; {
+			endpoint = endpointURL(ns.BaseURL, "statuses/user_timeline", fmt.Sprintf("user_id=%s&count=200&include_rts=true", id))
 			if maxID != 0 {
 				endpoint += fmt.Sprintf("&max_id=%d", maxID)
 			}
-			res, err := ns.Client.Get(fmt.Sprintf(endpoint, id))
+			res, err := ns.get(ctx, endpoint)
+			var unauthorized *UnauthorizedError
+			if errors.As(err, &unauthorized) {
+				log.Printf("skipping %s (%s)\n", id, unauthorized.Message)
+				break
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -206,7 +253,7 @@ func (ns Twitter) retweetersOf(handle string, maxCount int) ([]string, error) {
 }
 
 // Init supports retrieve handles from: list membership, a query file, followers who retweet or a friend/follow relationship
-func (ns Twitter) Init(followersFlag bool, maxPostCount int, queryFlag bool, nomentionFlag bool, membership string, imageFlag bool, args []string) {
+func (ns Twitter) Init(ctx context.Context, opts sns.InitOptions, args []string) (string, error) {
 	var result []UserObject
 	var err error
 	var ids []string
@@ -215,48 +262,50 @@ func (ns Twitter) Init(followersFlag bool, maxPostCount int, queryFlag bool, nom
 	var arg string
 	var filename string
 
-	ns.Client, err = NewClient()
-	if err != nil {
-		log.Fatal("failed to create Twitter client: ", err)
+	if err = ns.connect(); err != nil {
+		return "", err
 	}
 
 	// list members use case: write user objects to disk and return
-	if membership != "" {
-		result, err = ns.members(membership, args[0])
+	if opts.List != "" {
+		result, err = ns.members(ctx, opts.List, args[0])
 		if err != nil {
-			log.Fatal("failed to retrieve members: ", err)
+			return "", fmt.Errorf("failed to retrieve members: %w", err)
 		}
 		filename, err = util.CSVWriter(args[0], util.DatExt, false, result)
 		if err != nil {
-			log.Fatal("failed to write dat file: ", err)
+			return "", fmt.Errorf("failed to write dat file: %w", err)
 		}
 		log.Printf("%s created\n", filename)
-		return
+		return filename, nil
 	}
 
-	if followersFlag {
+	if opts.Followers {
 		relation = "followers"
 	} else {
 		relation = "friends"
 	}
 
 	switch {
-	case queryFlag:
+	case opts.Query:
 		// query search or manually created query file
-		ids, err = util.QueryReader(args[0], nomentionFlag)
-	case maxPostCount > 0:
+		ids, err = util.QueryReader(args[0], opts.NoMention)
+	case opts.MaxPostCount > 0:
 		// followers who retweet tweets by this handle
-		ids, err = ns.retweetersOf(args[0], maxPostCount)
+		ids, err = ns.retweetersOf(ctx, args[0], opts.MaxPostCount)
 	default:
 		// basic relation use case
-		ids, err = ns.ids(relation, args[0])
+		ids, err = ns.ids(ctx, relation, args[0])
 	}
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 
 	// populate a hydrated array of user objects based on array of IDs
 	for page, width := 0, 100; page*width < len(ids); page++ {
+		if err := ctx.Err(); err != nil {
+			return filename, err
+		}
 		if (page+1)*width >= len(ids) {
 			arg = fmt.Sprint(ids[page*width:])
 		} else {
@@ -264,73 +313,80 @@ func (ns Twitter) Init(followersFlag bool, maxPostCount int, queryFlag bool, nom
 		}
 		arg = strings.Trim(strings.Join(strings.Fields(arg), ","), "[]")
 		if util.DigitsOnly(ids[page*width]) {
-			endpoint = "https://api.twitter.com/1.1/users/lookup.json?user_id=%s"
+			endpoint = endpointURL(ns.BaseURL, "users/lookup", "user_id="+arg)
 		} else {
-			endpoint = "https://api.twitter.com/1.1/users/lookup.json?screen_name=%s"
+			endpoint = endpointURL(ns.BaseURL, "users/lookup", "screen_name="+arg)
 		}
-		res, err := ns.Client.Get(fmt.Sprintf(endpoint, arg))
+		res, err := ns.get(ctx, endpoint)
 		if err != nil {
-			log.Fatal("failed to use Twitter client: ", err)
+			return filename, fmt.Errorf("failed to use Twitter client: %w", err)
 		}
 		defer res.Body.Close()
 		json.NewDecoder(res.Body).Decode(&result)
 		for i := range result {
-			if maxPostCount > 0 {
+			if opts.MaxPostCount > 0 {
 				result[i].Relation = "retweeter"
 			} else {
 				result[i].Relation = relation
 			}
 			result[i].Subject = args[0]
 			// ignore erros on downloads
-			if imageFlag {
-				util.DownloadImage(result[i].ID, result[i].ProfileImageURL)
+			if opts.Images {
+				util.DownloadImage(ctx, fmt.Sprintf("%d", result[i].ID), result[i].ProfileImageURL)
 			}
 		}
 		filename, err = util.CSVWriter(args[0], util.DatExt, page > 0, result)
 		if err != nil {
-			log.Fatal("failed to write file: ", err)
+			return filename, fmt.Errorf("failed to write file: %w", err)
 		}
 		log.Printf("processed %d starting from %s\n", strings.Count(arg, ",")+1, ids[page*width])
 	}
 	log.Printf("%s created\n", filename)
+	return filename, nil
 }
 
 // Fetch retrieves second-degree "friends" from handles collected with Init
-func (ns Twitter) Fetch(forceFlag bool, fetchCount int, args []string) {
+// handles are fetched by opts.Workers in parallel, paced by the rate limit windows they share
+// protected accounts whose friends cannot be retrieved are skipped
+func (ns Twitter) Fetch(ctx context.Context, opts sns.FetchOptions, args []string) error {
 	var err error
 
-	ns.Client, err = NewClient()
-	if err != nil {
-		log.Fatal("failed to create Twitter client: ", err)
+	if err = ns.connect(); err != nil {
+		return err
 	}
 
 	data := []UserObject{}
 	if err = util.CSVReader(args[0], util.DatExt, &data); err != nil {
-		log.Fatal(err)
+		return err
 	}
-	for _, user := range data {
+	return util.ForEach(ctx, len(data), opts.Workers, func(ctx context.Context, i int) (string, error) {
+		user := data[i]
 		uid := fmt.Sprintf("%d", user.ID)
 		// skip if file exists and flag to force call not set
-		if !forceFlag && util.FdatExists(uid) {
-			continue
+		if !opts.Force && util.FdatExists(uid) {
+			return "", nil
 		}
-		if user.FriendsCount > fetchCount {
-			log.Printf("skipping %s (%d friends)\n", user.ScreenName, user.FriendsCount)
-			continue
+		if user.FriendsCount > opts.MaxFriends {
+			return fmt.Sprintf("skipping %s (%d friends)", user.ScreenName, user.FriendsCount), nil
+		}
+		ids, err := ns.ids(ctx, "friends", uid)
+		var unauthorized *UnauthorizedError
+		if errors.As(err, &unauthorized) {
+			// no friends file so the handle is fetched again with credentials allowed to see it
+			return fmt.Sprintf("skipping %s (%s)", user.ScreenName, unauthorized.Message), nil
 		}
-		ids, err := ns.ids("friends", uid)
 		if err != nil {
-			log.Fatal(err)
+			return "", err
 		}
 		if _, err := util.FdatWriter(uid, ids); err != nil {
-			log.Fatal("failed to write friends file: ", err)
+			return "", fmt.Errorf("failed to write friends file: %w", err)
 		}
-		log.Printf("processed %s\n", user.ScreenName)
-	}
+		return fmt.Sprintf("processed %s", user.ScreenName), nil
+	})
 }
 
 // Edgelist constructs the network of who is "friends" with whom among handles returned by Init
-func (ns Twitter) Edgelist(egoFlag bool, missingFlag bool, args []string) {
+func (ns Twitter) Edgelist(ctx context.Context, opts sns.EdgelistOptions, args []string) (string, error) {
 	var cols = []string{
 		"ID",
 		"ScreenName",
@@ -351,27 +407,27 @@ func (ns Twitter) Edgelist(egoFlag bool, missingFlag bool, args []string) {
 	data := []UserObject{}
 	for _, handle := range args {
 		if err = util.CSVReader(handle, util.DatExt, &data); err != nil {
-			log.Fatal(err)
+			return "", err
 		}
-		if egoFlag {
+		if opts.Ego {
 			var self UserObject
-			ns.Client, err = NewClient()
-			if err != nil {
-				log.Fatal("failed to create Twitter client: ", err)
+			if err = ns.connect(); err != nil {
+				return "", err
 			}
-			self, err = ns.show(handle)
+			self, err = ns.show(ctx, handle)
 			if err != nil {
-				log.Fatal("failed to retrieve handle details: ", err)
+				return "", fmt.Errorf("failed to retrieve handle details: %w", err)
 			}
 			data = append(data, self)
 		}
 	}
 	// call GMLWriter using ScreenName as label for nodes
-	if filename, err = util.GMLWriter(args, data, missingFlag, cols, "ScreenName"); err != nil {
-		log.Fatal(err)
+	if filename, err = util.GMLWriter(args, data, opts.Missing, cols, "ScreenName"); err != nil {
+		return "", err
 	}
 
 	log.Printf("%s created\n", filename)
+	return filename, nil
 }
 
 func (tweets *SearchResult) filterByTweetID(tweetID uint64) {
@@ -386,37 +442,60 @@ func (tweets *SearchResult) filterByTweetID(tweetID uint64) {
 }
 
 // Posts retrieves tweets from a search query, user list, replies to a given tweet ID or from a handle
-func (ns Twitter) Posts(queryFlag bool, list string, postID uint64, args []string) {
+// starting below opts.MaxID appends to the .qry file of an interrupted collection
+func (ns Twitter) Posts(ctx context.Context, opts sns.PostsOptions, args []string) (string, error) {
 	var result SearchResult
 	var err error
 	var endpoint string
 	var filename string
+	var postID uint64
 
-	ns.Client, err = NewClient()
-	if err != nil {
-		log.Fatal("failed to create Twitter client: ", err)
+	var maxID uint64
+
+	if opts.PostID != "" {
+		if postID, err = strconv.ParseUint(opts.PostID, 10, 64); err != nil {
+			return "", fmt.Errorf("invalid tweet id %s", opts.PostID)
+		}
+	}
+	if opts.MaxID != "" {
+		if maxID, err = strconv.ParseUint(opts.MaxID, 10, 64); err != nil {
+			return "", fmt.Errorf("invalid tweet id %s", opts.MaxID)
+		}
+	}
+	if err = ns.connect(); err != nil {
+		return "", err
 	}
 
-	for maxID := uint64(0); ; {
+	// a canceled collection resumes below the oldest tweet written
+	interrupted := func(err error) error {
+		if maxID == 0 || !errors.Is(err, context.Canceled) {
+			return err
+		}
+		return &sns.Interrupted{Err: err, Resume: []string{"-max", strconv.FormatUint(maxID, 10)}}
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return filename, interrupted(err)
+		}
 		switch {
-		case queryFlag:
-			endpoint = fmt.Sprintf("https://api.twitter.com/1.1/search/tweets.json?q=%s&result_type=recent&count=100", url.QueryEscape(args[0]))
-		case list != "":
-			endpoint = fmt.Sprintf("https://api.twitter.com/1.1/lists/statuses.json?slug=%s&owner_screen_name=%s&count=100", list, args[0])
+		case opts.Query:
+			endpoint = endpointURL(ns.BaseURL, "search/tweets", fmt.Sprintf("q=%s&result_type=recent&count=100", url.QueryEscape(args[0])))
+		case opts.List != "":
+			endpoint = endpointURL(ns.BaseURL, "lists/statuses", fmt.Sprintf("slug=%s&owner_screen_name=%s&count=100", opts.List, args[0]))
 		case postID != 0:
-			endpoint = fmt.Sprintf("https://api.twitter.com/1.1/search/tweets.json?q=%s&result_type=recent&count=100&since_id=%d", url.QueryEscape("to:"+args[0]), postID)
+			endpoint = endpointURL(ns.BaseURL, "search/tweets", fmt.Sprintf("q=%s&result_type=recent&count=100&since_id=%d", url.QueryEscape("to:"+args[0]), postID))
 		default:
-			endpoint = fmt.Sprintf("https://api.twitter.com/1.1/statuses/user_timeline.json?screen_name=%s&count=200&include_rts=true", args[0])
+			endpoint = endpointURL(ns.BaseURL, "statuses/user_timeline", fmt.Sprintf("screen_name=%s&count=200&include_rts=true", args[0]))
 		}
 		if maxID != 0 {
 			endpoint += fmt.Sprintf("&max_id=%d", maxID)
 		}
-		res, err := ns.Client.Get(endpoint)
+		res, err := ns.get(ctx, endpoint)
 		if err != nil {
-			log.Fatal("failed to use Twitter client: ", err)
+			return filename, fmt.Errorf("failed to use Twitter client: %w", interrupted(err))
 		}
 		defer res.Body.Close()
-		if queryFlag || postID != 0 {
+		if opts.Query || postID != 0 {
 			json.NewDecoder(res.Body).Decode(&result)
 		} else {
 			json.NewDecoder(res.Body).Decode(&result.Statuses)
@@ -432,7 +511,7 @@ func (ns Twitter) Posts(queryFlag bool, list string, postID uint64, args []strin
 			log.Printf("%s created\n", filename)
 		}
 		if err != nil {
-			log.Fatal("failed to write posts: ", err)
+			return filename, fmt.Errorf("failed to write posts: %w", err)
 		}
 		log.Printf("processed %d tweets\n", len(result.Statuses))
 		for _, tweet := range result.Statuses {
@@ -443,27 +522,95 @@ func (ns Twitter) Posts(queryFlag bool, list string, postID uint64, args []strin
 		// optimization for 64 bit integers
 		maxID--
 	}
+	return filename, nil
 }
 
 // Resolve converts screen names to IDs and vice versa along with basic stats
-func (ns Twitter) Resolve(args []string) {
+func (ns Twitter) Resolve(ctx context.Context, args []string) ([]sns.Profile, error) {
 	var uo UserObject
 	var err error
+	var result []sns.Profile
 
-	ns.Client, err = NewClient()
-	if err != nil {
-		log.Fatal("failed to create Twitter client: ", err)
+	if err = ns.connect(); err != nil {
+		return nil, err
 	}
 
 	for _, handle := range args {
-		uo, err = ns.show(handle)
+		uo, err = ns.show(ctx, handle)
 		if err != nil {
-			log.Fatal("failed to retrieve handle details: ", err)
+			return result, fmt.Errorf("failed to retrieve handle details: %w", err)
 		}
-		if util.DigitsOnly(handle) {
-			fmt.Printf("%s, %s, %d friends, %d followers, %d memberships, %d tweets\n", handle, uo.ScreenName, uo.FriendsCount, uo.FollowersCount, uo.ListedCount, uo.StatusesCount)
-		} else {
-			fmt.Printf("%s, %d, %d friends, %d followers, %d memberships, %d tweets\n", handle, uo.ID, uo.FriendsCount, uo.FollowersCount, uo.ListedCount, uo.StatusesCount)
+		result = append(result, uo.Profile(handle))
+	}
+	return result, nil
+}
+
+// Test checks the credentials of the profile with a rate limit status call, which costs no collection quota
+func (ns Twitter) Test(ctx context.Context) (string, error) {
+	var status struct {
+		Resources map[string]map[string]Window `json:"resources"`
+	}
+
+	if err := ns.connect(); err != nil {
+		return "", err
+	}
+	res, err := ns.get(ctx, endpointURL(ns.BaseURL, "application/rate_limit_status", "resources=application"))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("credentials rejected: %s", res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return "", err
+	}
+	w := status.Resources["application"]["/application/rate_limit_status"]
+	return fmt.Sprintf("%d of %d rate limit status calls left", w.Remaining, w.Limit), nil
+}
+
+// Invalidate revokes the bearer token of the profile with oauth2/invalidate_token and removes it from the config
+// the consumer credentials are those of the profile, else taken from the environment or asked for
+func (ns Twitter) Invalidate(ctx context.Context) (string, error) {
+	config, err := util.ReadConfig()
+	if err != nil {
+		return "", err
+	}
+	if config.AccessToken == "" {
+		return "", fmt.Errorf("no bearer token stored in profile %s", config.Profile())
+	}
+	t := &NucollTransport{Config: config, BaseURL: ns.BaseURL, Transport: util.Transport()}
+	if t.BaseURL == "" {
+		t.BaseURL = config.BaseURL("twitter", APIURL)
+	}
+	key, secret := config.ConsumerKey, config.ConsumerSecret
+	if key == "" || secret == "" {
+		if key, secret, err = t.consumer(); err != nil {
+			return "", err
 		}
 	}
+	if err = t.invalidateToken(ctx, key, secret); err != nil {
+		return "", fmt.Errorf("failed to invalidate token: %w", err)
+	}
+	revoked := label(&config.TwitterConfig)
+	config.TokenType, config.AccessToken = "", ""
+	if err = util.WriteConfig(config); err != nil {
+		return "", err
+	}
+	return revoked + " invalidated", nil
+}
+
+// Profile returns the resolve command output for a user object looked up as handle
+func (uo UserObject) Profile(handle string) sns.Profile {
+	return sns.Profile{
+		Handle:     handle,
+		ID:         fmt.Sprintf("%d", uo.ID),
+		ScreenName: uo.ScreenName,
+		Counts: []sns.Count{
+			{Value: uo.FriendsCount, Label: "friends"},
+			{Value: uo.FollowersCount, Label: "followers"},
+			{Value: uo.ListedCount, Label: "memberships"},
+			{Value: uo.StatusesCount, Label: "tweets"},
+		},
+	}
 }
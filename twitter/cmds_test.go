@@ -0,0 +1,392 @@
+package twitter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/util"
+	"github.com/jdevoo/nucoll/util/utiltest"
+)
+
+// users of the stand-in API where alice follows bob and carol, bob follows alice and carol follows nobody
+// erin is protected
+var users = map[string]UserObject{
+	"1": {ID: 1, ScreenName: "alice", FriendsCount: 2, FollowersCount: 1, StatusesCount: 1},
+	"2": {ID: 2, ScreenName: "bob", FriendsCount: 1, FollowersCount: 1},
+	"3": {ID: 3, ScreenName: "carol", FollowersCount: 1},
+	"4": {ID: 4, ScreenName: "erin", Protected: true},
+}
+
+var friends = map[string][]string{"1": {"2", "3"}, "2": {"1"}, "3": {}}
+
+// lookupUser finds a user by ID or screen name
+func lookupUser(key string) (UserObject, bool) {
+	if u, ok := users[key]; ok {
+		return u, true
+	}
+	for _, u := range users {
+		if u.ScreenName == key {
+			return u, true
+		}
+	}
+	return UserObject{}, false
+}
+
+// newAPI stands in for the v1.1 and v2 endpoints listed in the endpoint table
+// the bearer token called revoked is rejected with an error code by v1.1 and problem details by v2
+func newAPI() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v interface{}
+
+		q := r.URL.Query()
+		key := q.Get("user_id") + q.Get("screen_name")
+		if r.Header.Get("Authorization") == "Bearer revoked" {
+			w.WriteHeader(http.StatusUnauthorized)
+			if strings.HasPrefix(r.URL.Path, endpoints["v2"]) {
+				w.Write([]byte(`{"title":"Unauthorized","type":"about:blank","status":401,"detail":"Unauthorized"}`))
+				return
+			}
+			w.Write([]byte(`{"errors":[{"code":89,"message":"Invalid or expired token."}]}`))
+			return
+		}
+		switch r.URL.Path {
+		case endpoints["oauth2/token"]:
+			v = util.TwitterConfig{TokenType: "bearer", AccessToken: "token"}
+		case endpoints["oauth2/invalidate_token"]:
+			r.ParseForm()
+			v = map[string]string{"access_token": r.PostForm.Get("access_token")}
+		case endpoints["application/rate_limit_status"]:
+			w.Header().Set("x-rate-limit-limit", "180")
+			w.Header().Set("x-rate-limit-remaining", "179")
+			w.Header().Set("x-rate-limit-reset", fmt.Sprint(time.Now().Add(15*time.Minute).Unix()))
+			v = map[string]interface{}{"resources": map[string]interface{}{
+				"application": map[string]Window{"/application/rate_limit_status": {Limit: 180, Remaining: 179, Reset: time.Now().Add(15 * time.Minute).Unix()}},
+				"friends":     map[string]Window{"/friends/ids": {Limit: 15, Remaining: 0, Reset: time.Now().Add(10 * time.Minute).Unix()}},
+				"users":       map[string]Window{"/users/lookup": {Limit: 900, Remaining: 900, Reset: time.Now().Add(15 * time.Minute).Unix()}},
+			}}
+		case endpoints["friends/ids"]:
+			u, _ := lookupUser(key)
+			if u.Protected {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"request":"` + r.URL.Path + `","error":"Not authorized."}`))
+				return
+			}
+			v = IdsResult{IDs: friends[fmt.Sprint(u.ID)]}
+		case endpoints["users/show"]:
+			u, ok := lookupUser(key)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			v = u
+		case endpoints["users/lookup"]:
+			var result []UserObject
+			for _, k := range strings.Split(key, ",") {
+				if u, ok := lookupUser(k); ok {
+					result = append(result, u)
+				}
+			}
+			v = result
+		case endpoints["statuses/user_timeline"]:
+			var result []TweetObject
+			if q.Get("max_id") == "" {
+				t := TweetObject{ID: 100, Text: "hello"}
+				t.User.ScreenName = key
+				result = append(result, t)
+			}
+			v = result
+		default:
+			if strings.HasPrefix(r.URL.Path, endpoints["v2"]) {
+				v = serveV2(r)
+			}
+			if v == nil {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(v)
+	}))
+}
+
+func TestCommands(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+	utiltest.InWorkspace(t)
+
+	ns := Twitter{Client: srv.Client(), BaseURL: srv.URL}
+	ctx := context.Background()
+	if _, err := ns.Init(ctx, sns.InitOptions{}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	data := []UserObject{}
+	if err := util.CSVReader("alice", util.DatExt, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2 || data[0].ScreenName != "bob" || data[1].Relation != "friends" {
+		t.Fatalf("Init: unexpected %+v", data)
+	}
+
+	if err := ns.Fetch(ctx, sns.FetchOptions{MaxFriends: 5000, Workers: 2}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if !util.FdatExists("2") || !util.FdatExists("3") {
+		t.Fatal("Fetch: missing friends files")
+	}
+
+	filename, err := ns.Edgelist(ctx, sns.EdgelistOptions{Ego: true}, []string{"alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "node ["); n != 3 {
+		t.Errorf("Edgelist: expected 3 nodes, actual %d", n)
+	}
+
+	if _, err := ns.Posts(ctx, sns.PostsOptions{}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadFile(util.WorkspacePath("alice" + util.QueryExt))
+	if err != nil || !strings.Contains(string(b), "hello") {
+		t.Errorf("Posts: unexpected %q (%v)", b, err)
+	}
+
+	profiles, err := ns.Resolve(ctx, []string{"alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "alice, 1, 2 friends, 1 followers, 0 memberships, 1 tweets"
+	if len(profiles) != 1 || profiles[0].String() != expected {
+		t.Errorf("Resolve: expected %q, actual %v", expected, profiles)
+	}
+}
+
+func TestResolveV2(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+
+	ns := TwitterV2{Client: srv.Client(), BaseURL: srv.URL}
+	profiles, err := ns.Resolve(context.Background(), []string{"alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 1 || profiles[0].ID != "1" {
+		t.Errorf("Resolve: unexpected %v", profiles)
+	}
+}
+
+func TestGetToken(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+
+	tr := &NucollTransport{Config: &util.NucollConfig{}, BaseURL: srv.URL, Transport: http.DefaultTransport}
+	if err := tr.getToken(&tr.Config.TwitterConfig, "key", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Config.AccessToken != "token" {
+		t.Errorf("getToken: unexpected %+v", tr.Config.TwitterConfig)
+	}
+}
+
+func TestTransportRetry(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+	// the first call fails with a 503 lacking rate limit headers
+	failed := false
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !failed {
+			failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+
+	retry := util.Retry{MaxAttempts: 2, MaxElapsed: time.Minute, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	client := &http.Client{Transport: &NucollTransport{Config: &util.NucollConfig{}, Retry: retry, Transport: http.DefaultTransport}}
+	ns := Twitter{Client: client, BaseURL: flaky.URL}
+	profiles, err := ns.Resolve(context.Background(), []string{"bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 1 || profiles[0].ID != "2" {
+		t.Errorf("Resolve: unexpected %v", profiles)
+	}
+}
+
+func TestTransportCache(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+	calls := 0
+	counting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer counting.Close()
+	utiltest.InWorkspace(t)
+
+	cache, err := util.NewCache(util.WorkspacePath(util.CacheDir), time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &NucollTransport{Config: &util.NucollConfig{}, Cache: cache, Transport: http.DefaultTransport}}
+	ns := Twitter{Client: client, BaseURL: counting.URL}
+	for i := 0; i < 2; i++ {
+		profiles, err := ns.Resolve(context.Background(), []string{"bob"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(profiles) != 1 || profiles[0].ID != "2" {
+			t.Errorf("Resolve: unexpected %v", profiles)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected second resolve to be served from the cache, actual %d calls", calls)
+	}
+
+	// friends lists are collected anew
+	for i := 0; i < 2; i++ {
+		if _, err := ns.ids(context.Background(), "friends", "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("expected friends/ids not to be cached, actual %d calls", calls)
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	for path, expected := range map[string]bool{
+		endpoints["users/show"]:             true,
+		endpoints["users/lookup"]:           true,
+		"/2/users/by/username/alice":        true,
+		"/2/users/by":                       true,
+		"/2/users/1":                        true,
+		"/2/users":                          true,
+		endpoints["friends/ids"]:            false,
+		endpoints["followers/ids"]:          false,
+		endpoints["statuses/user_timeline"]: false,
+		endpoints["search/tweets"]:          false,
+		"/2/users/1/following":              false,
+		"/2/users/1/tweets":                 false,
+		"/2/tweets/search/recent":           false,
+	} {
+		if actual := cacheable(path); actual != expected {
+			t.Errorf("cacheable(%s): expected %t, actual %t", path, expected, actual)
+		}
+	}
+}
+
+// cancelAfter cancels a context once the first response has been received
+type cancelAfter struct {
+	cancel context.CancelFunc
+}
+
+func (c cancelAfter) RoundTrip(req *http.Request) (*http.Response, error) {
+	defer c.cancel()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestPostsInterrupted(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+	utiltest.InWorkspace(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ns := Twitter{Client: &http.Client{Transport: cancelAfter{cancel}}, BaseURL: srv.URL}
+	_, err := ns.Posts(ctx, sns.PostsOptions{}, []string{"alice"})
+	var interrupted *sns.Interrupted
+	if !errors.As(err, &interrupted) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected interruption, actual %v", err)
+	}
+	if strings.Join(interrupted.Resume, " ") != "-max 99" {
+		t.Errorf("expected resume below 100, actual %v", interrupted.Resume)
+	}
+
+	// resuming appends to the posts written before the interruption
+	ns.Client = srv.Client()
+	if _, err := ns.Posts(context.Background(), sns.PostsOptions{MaxID: "99"}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(util.WorkspacePath("alice" + util.QueryExt))
+	if err != nil || !strings.Contains(string(b), "hello") {
+		t.Errorf("Posts: unexpected %q (%v)", b, err)
+	}
+}
+
+func TestTransportAudit(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+	utiltest.InWorkspace(t)
+
+	audit := &util.AuditLog{Path: util.WorkspacePath("audit.jsonl")}
+	client := &http.Client{Transport: &NucollTransport{Config: &util.NucollConfig{}, Audit: audit, Transport: http.DefaultTransport}}
+	ns := Twitter{Client: client, BaseURL: srv.URL}
+	ctx := util.WithCollection(context.Background(), "resolve", "bob")
+	if _, err := ns.Resolve(ctx, []string{"bob", "dave"}); err == nil {
+		t.Fatal("expected unknown handle to fail")
+	}
+	b, err := ioutil.ReadFile(audit.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, actual %q", b)
+	}
+	var r util.AuditRecord
+	if err := json.Unmarshal([]byte(lines[1]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Command != "resolve" || r.Endpoint != endpoints["users/show"] || r.Params.Get("screen_name") != "dave" || r.Status != http.StatusNotFound || r.Bytes == 0 {
+		t.Errorf("unexpected record %+v", r)
+	}
+}
+
+func TestTest(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+
+	var ns sns.SocialNetworkService = Twitter{Client: srv.Client(), BaseURL: srv.URL}
+	tester, ok := ns.(sns.Tester)
+	if !ok {
+		t.Fatal("expected Twitter to test credentials")
+	}
+	result, err := tester.Test(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "179 of 180 rate limit status calls left" {
+		t.Errorf("unexpected %q", result)
+	}
+}
+
+func TestConsumerFromEnv(t *testing.T) {
+	os.Setenv("NUCOLL_CONSUMER_KEY", "key")
+	os.Setenv("NUCOLL_CONSUMER_SECRET", "secret")
+	defer os.Unsetenv("NUCOLL_CONSUMER_KEY")
+	defer os.Unsetenv("NUCOLL_CONSUMER_SECRET")
+
+	tr := &NucollTransport{Config: &util.NucollConfig{}}
+	key, secret, err := tr.consumer()
+	if err != nil || key != "key" || secret != "secret" {
+		t.Fatalf("unexpected %q %q (%v)", key, secret, err)
+	}
+	// tokens obtained with credentials from the environment are not stored
+	if !tr.fromEnv || tr.saveConfig() != nil {
+		t.Errorf("expected config not to be saved")
+	}
+}
@@ -0,0 +1,44 @@
+package twitter
+
+import "github.com/jdevoo/nucoll/util"
+
+// APIURL is the default base URL of the Twitter API
+// it can be pointed at a stand-in server with the -u flag, NUCOLL_TWITTER_URL
+// or the base_url setting of the twitter section in the config file
+const APIURL = "https://api.twitter.com"
+
+// endpoints lists every path called by the twitter and twitter2 backends relative to the base URL
+var endpoints = map[string]string{
+	"oauth2/token":                  "/oauth2/token",
+	"oauth2/invalidate_token":       "/oauth2/invalidate_token",
+	"oauth/request_token":           "/oauth/request_token",
+	"oauth/authorize":               "/oauth/authorize",
+	"oauth/access_token":            "/oauth/access_token",
+	"application/rate_limit_status": "/1.1/application/rate_limit_status.json",
+	"friends/ids":                   "/1.1/friends/ids.json",
+	"followers/ids":                 "/1.1/followers/ids.json",
+	"lists/members":                 "/1.1/lists/members.json",
+	"lists/statuses":                "/1.1/lists/statuses.json",
+	"users/show":                    "/1.1/users/show.json",
+	"users/lookup":                  "/1.1/users/lookup.json",
+	"statuses/user_timeline":        "/1.1/statuses/user_timeline.json",
+	"search/tweets":                 "/1.1/search/tweets.json",
+	"v2":                            "/2/",
+}
+
+// endpointURL returns the URL of the named endpoint below base with an optional query string
+func endpointURL(base string, name string, query string) string {
+	if query == "" {
+		return base + endpoints[name]
+	}
+	return base + endpoints[name] + "?" + query
+}
+
+// baseURL returns the configured base URL of the Twitter API
+func baseURL() (string, error) {
+	config, err := util.ReadConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.BaseURL("twitter", APIURL), nil
+}
@@ -0,0 +1,183 @@
+package twitter
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jdevoo/nucoll/util"
+)
+
+// OAuth 1.0a user context with PIN-based sign-in
+// https://developer.twitter.com/en/docs/authentication/oauth-1-0a/pin-based-oauth
+
+// percentEncode escapes s as specified by RFC 3986 section 2.1
+func percentEncode(s string) string {
+	var sb strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// signature computes the HMAC-SHA1 signature of req over its query, form body and oauth parameters
+// https://developer.twitter.com/en/docs/authentication/oauth-1-0a/creating-a-signature
+func signature(req *http.Request, oauth map[string]string, consumerSecret string, tokenSecret string) (string, error) {
+	params := url.Values{}
+	for k, vs := range req.URL.Query() {
+		params[k] = append(params[k], vs...)
+	}
+	if req.Body != nil && req.GetBody != nil && strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			return "", err
+		}
+		form, err := url.ParseQuery(string(b))
+		if err != nil {
+			return "", err
+		}
+		for k, vs := range form {
+			params[k] = append(params[k], vs...)
+		}
+	}
+	for k, v := range oauth {
+		params.Set(k, v)
+	}
+	var pairs []string
+	for k, vs := range params {
+		for _, v := range vs {
+			pairs = append(pairs, percentEncode(k)+"="+percentEncode(v))
+		}
+	}
+	sort.Strings(pairs)
+	u := *req.URL
+	u.RawQuery, u.Fragment = "", ""
+	u.Scheme, u.Host = strings.ToLower(u.Scheme), strings.ToLower(u.Host)
+	base := strings.Join([]string{req.Method, percentEncode(u.String()), percentEncode(strings.Join(pairs, "&"))}, "&")
+	mac := hmac.New(sha1.New, []byte(percentEncode(consumerSecret)+"&"+percentEncode(tokenSecret)))
+	mac.Write([]byte(base))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// nonce returns a random value unique to each request
+func nonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sign sets the OAuth Authorization header of req
+// token is empty when requesting a request token, extra holds oauth_callback or oauth_verifier
+func sign(req *http.Request, c util.TwitterConfig, token string, tokenSecret string, extra map[string]string) error {
+	oauth := map[string]string{
+		"oauth_consumer_key":     c.ConsumerKey,
+		"oauth_nonce":            nonce(),
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_version":          "1.0",
+	}
+	if token != "" {
+		oauth["oauth_token"] = token
+	}
+	for k, v := range extra {
+		oauth[k] = v
+	}
+	sig, err := signature(req, oauth, c.ConsumerSecret, tokenSecret)
+	if err != nil {
+		return err
+	}
+	oauth["oauth_signature"] = sig
+	var pairs []string
+	for k, v := range oauth {
+		pairs = append(pairs, percentEncode(k)+`="`+percentEncode(v)+`"`)
+	}
+	sort.Strings(pairs)
+	req.Header.Set("Authorization", "OAuth "+strings.Join(pairs, ", "))
+	return nil
+}
+
+// tokenRequest posts a signed request to one of the oauth endpoints and returns the form-encoded answer
+func (t *NucollTransport) tokenRequest(name string, token string, tokenSecret string, extra map[string]string) (url.Values, error) {
+	req, err := http.NewRequest(http.MethodPost, endpointURL(t.BaseURL, name, ""), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = sign(req, t.Config.TwitterConfig, token, tokenSecret, extra); err != nil {
+		return nil, err
+	}
+	res, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s %s", name, res.Status, strings.TrimSpace(string(body)))
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	if values.Get("oauth_token") == "" || values.Get("oauth_token_secret") == "" {
+		return nil, fmt.Errorf("%s: no token returned", name)
+	}
+	return values, nil
+}
+
+// signIn obtains an access token for the account which authorizes the application
+// the user opens the authorize URL and types the PIN displayed by Twitter
+func (t *NucollTransport) signIn(pin func(authorizeURL string) (string, error)) error {
+	rt, err := t.tokenRequest("oauth/request_token", "", "", map[string]string{"oauth_callback": "oob"})
+	if err != nil {
+		return err
+	}
+	verifier, err := pin(endpointURL(t.BaseURL, "oauth/authorize", "oauth_token="+url.QueryEscape(rt.Get("oauth_token"))))
+	if err != nil {
+		return err
+	}
+	if verifier == "" {
+		return errors.New("no PIN entered")
+	}
+	at, err := t.tokenRequest("oauth/access_token", rt.Get("oauth_token"), rt.Get("oauth_token_secret"), map[string]string{"oauth_verifier": verifier})
+	if err != nil {
+		return err
+	}
+	t.Config.OAuthToken = at.Get("oauth_token")
+	t.Config.OAuthTokenSecret = at.Get("oauth_token_secret")
+	t.Config.ScreenName = at.Get("screen_name")
+	return nil
+}
+
+// promptPIN asks the user to authorize the application in a browser
+func promptPIN(authorizeURL string) (string, error) {
+	fmt.Printf(`
+===TWITTER USER SIGN-IN==========================================
+Open the following link, authorize this application and enter
+the PIN displayed by Twitter...
+>>> %s
+`, authorizeURL)
+	fmt.Print("What is the PIN? ")
+	verifier, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(verifier), nil
+}
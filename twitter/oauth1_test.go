@@ -0,0 +1,76 @@
+package twitter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jdevoo/nucoll/util"
+)
+
+// example from https://developer.twitter.com/en/docs/authentication/oauth-1-0a/creating-a-signature
+func TestSignature(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "https://api.twitter.com/1.1/statuses/update.json?include_entities=true",
+		strings.NewReader("status=Hello%20Ladies%20%2b%20Gentlemen%2c%20a%20signed%20OAuth%20request%21"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	oauth := map[string]string{
+		"oauth_consumer_key":     "xvz1evFS4wEEPTGEFPHBog",
+		"oauth_nonce":            "kYjzVBB8Y0ZFabxSWbWovY3uYSQ2pTgmZeNu2VS4cg",
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        "1318622958",
+		"oauth_token":            "370773112-GmHxMAgYyLbNEtIKZeRNFsMKPR9EyMZeS9weJAEb",
+		"oauth_version":          "1.0",
+	}
+	sig, err := signature(req, oauth, "kAcSOqF21Fu85e7zjz7ZN2U4ZRhfV3WpwPAoE3Z7kBw", "LswwdoUaIvS8ltyTt5jkRh4J50vUPVVHtR2YPi5kE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "hCtSmYh+iHYCEqBWrE7C7hYmtUk="; sig != expected {
+		t.Errorf("signature: expected %s, actual %s", expected, sig)
+	}
+}
+
+func TestSignIn(t *testing.T) {
+	var authorizations []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case endpoints["oauth/request_token"]:
+			w.Write([]byte("oauth_token=request&oauth_token_secret=rs&oauth_callback_confirmed=true"))
+		case endpoints["oauth/access_token"]:
+			w.Write([]byte("oauth_token=access&oauth_token_secret=as&user_id=1&screen_name=alice"))
+		default:
+			w.Write([]byte("{}"))
+		}
+	}))
+	defer srv.Close()
+
+	config := &util.NucollConfig{TwitterConfig: util.TwitterConfig{ConsumerKey: "key", ConsumerSecret: "secret"}}
+	tr := &NucollTransport{Config: config, BaseURL: srv.URL, UserContext: true, Transport: http.DefaultTransport}
+	var authorizeURL string
+	err := tr.signIn(func(u string) (string, error) {
+		authorizeURL = u
+		return "1234", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorizeURL != srv.URL+"/oauth/authorize?oauth_token=request" {
+		t.Errorf("unexpected authorize URL %s", authorizeURL)
+	}
+	if config.OAuthToken != "access" || config.OAuthTokenSecret != "as" || config.ScreenName != "alice" {
+		t.Errorf("unexpected credentials %+v", config.TwitterConfig)
+	}
+
+	res, err := (&http.Client{Transport: tr}).Get(srv.URL + endpoints["users/show"] + "?screen_name=alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	for i, expected := range []string{`oauth_callback="oob"`, `oauth_verifier="1234"`, `oauth_token="access"`} {
+		if !strings.HasPrefix(authorizations[i], "OAuth ") || !strings.Contains(authorizations[i], expected) {
+			t.Errorf("request %d: expected %s in %q", i, expected, authorizations[i])
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package twitter
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"log"
+
+	"github.com/jdevoo/nucoll/util"
+)
+
+// credential is one of the apps of the pool along with its rate limit windows
+type credential struct {
+	config    *util.TwitterConfig
+	label     string
+	scheduler *Scheduler
+}
+
+// fingerprint identifies a credential without revealing its token
+func fingerprint(c *util.TwitterConfig) string {
+	token := c.AccessToken
+	if c.OAuthToken != "" {
+		token = c.OAuthToken
+	}
+	return fmt.Sprintf("%x", sha1.Sum([]byte(token)))[:8]
+}
+
+// label names a credential in logs
+func label(c *util.TwitterConfig) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return "token " + fingerprint(c)
+}
+
+// active returns the credential requests are currently sent with
+// the pool starts with the top-level credentials of the config and Scheduler
+func (t *NucollTransport) active() *credential {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pool) == 0 {
+		t.pool = []*credential{{config: &t.Config.TwitterConfig, label: label(&t.Config.TwitterConfig), scheduler: t.Scheduler}}
+	}
+	return t.pool[t.current]
+}
+
+// addCredential appends c to the pool with its own rate limit windows
+func (t *NucollTransport) addCredential(c *util.TwitterConfig, scheduler *Scheduler) {
+	t.active()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pool = append(t.pool, &credential{config: c, label: label(c), scheduler: scheduler})
+}
+
+// loadPool adds the credentials listed in the config after the top-level ones
+// bearer tokens of apps given by consumer key and secret are obtained on first usage
+// windows are saved next to limits and learned from responses
+func (t *NucollTransport) loadPool(limits string) error {
+	obtained := false
+	for i := range t.Config.Credentials {
+		c := &t.Config.Credentials[i]
+		switch {
+		case t.UserContext && (c.OAuthToken == "" || c.ConsumerKey == ""):
+			log.Printf("skipping credential %d without user access token\n", i+1)
+			continue
+		case !t.UserContext && c.AccessToken == "":
+			if c.ConsumerKey == "" {
+				log.Printf("skipping credential %d without token or consumer key\n", i+1)
+				continue
+			}
+			if err := t.getToken(c, c.ConsumerKey, c.ConsumerSecret); err != nil {
+				return fmt.Errorf("failed to get token of credential %d: %w", i+1, err)
+			}
+			obtained = true
+		}
+		s, err := NewScheduler(limits + "-" + fingerprint(c))
+		if err != nil {
+			return err
+		}
+		t.addCredential(c, s)
+	}
+	if obtained {
+		return t.saveConfig()
+	}
+	return nil
+}
+
+// pick returns the active credential unless the window of family f is exhausted
+// and another credential still has quota for it
+func (t *NucollTransport) pick(f string) *credential {
+	c := t.active()
+	if c.scheduler == nil || c.scheduler.Available(f) {
+		return c
+	}
+	if next := t.rotate(c, f); next != nil {
+		return next
+	}
+	return c
+}
+
+// rotate makes the credential following from with quota left for family f the active one
+// it returns nil when no other credential has quota in which case the caller waits for a reset
+func (t *NucollTransport) rotate(from *credential, f string) *credential {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, c := range t.pool {
+		if c != from {
+			continue
+		}
+		for k := 1; k < len(t.pool); k++ {
+			next := t.pool[(i+k)%len(t.pool)]
+			if next.scheduler == nil || next.scheduler.Available(f) {
+				t.current = (i + k) % len(t.pool)
+				log.Printf("%s exhausted for %s, switching to %s\n", from.label, f, next.label)
+				return next
+			}
+		}
+	}
+	return nil
+}
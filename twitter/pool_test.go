@@ -0,0 +1,83 @@
+package twitter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jdevoo/nucoll/util"
+)
+
+func TestRotate(t *testing.T) {
+	var tokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		tokens = append(tokens, token)
+		w.Header().Set("x-rate-limit-limit", "15")
+		w.Header().Set("x-rate-limit-reset", fmt.Sprint(time.Now().Add(15*time.Minute).Unix()))
+		if token == "Bearer a" {
+			w.Header().Set("x-rate-limit-remaining", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("x-rate-limit-remaining", "14")
+		w.Write([]byte(`{"ids":[]}`))
+	}))
+	defer srv.Close()
+
+	primary, _ := NewScheduler("")
+	tr := &NucollTransport{Config: &util.NucollConfig{TwitterConfig: util.TwitterConfig{AccessToken: "a"}}, Scheduler: primary, Transport: http.DefaultTransport}
+	second, _ := NewScheduler("")
+	tr.addCredential(&util.TwitterConfig{AccessToken: "b", Name: "lab"}, second)
+	client := &http.Client{Transport: tr}
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(srv.URL + endpoints["friends/ids"] + "?screen_name=alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	expected := []string{"Bearer a", "Bearer b", "Bearer b"}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected %v, actual %v", expected, tokens)
+	}
+	if primary.Available("/friends/ids") || !second.Available("/friends/ids") {
+		t.Error("windows not tracked per token")
+	}
+	if c := tr.pick("/users/lookup"); c.label != "lab" {
+		t.Errorf("pick: expected to keep the active credential, actual %s", c.label)
+	}
+}
+
+func TestRotatePaced(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer a" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"ids":[]}`))
+	}))
+	defer srv.Close()
+
+	// the second token has one call left to spread over what remains of its window
+	now := time.Now()
+	primary, _ := NewScheduler("")
+	second, _ := NewScheduler("")
+	second.windows["/friends/ids"] = &Window{Limit: 15, Remaining: 1, Reset: now.Add(2 * time.Second).Unix(), last: now}
+	tr := &NucollTransport{Config: &util.NucollConfig{TwitterConfig: util.TwitterConfig{AccessToken: "a"}}, Scheduler: primary, Transport: http.DefaultTransport}
+	tr.addCredential(&util.TwitterConfig{AccessToken: "b", Name: "lab"}, second)
+	res, err := (&http.Client{Transport: tr}).Get(srv.URL + endpoints["friends/ids"] + "?screen_name=alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if elapsed := time.Since(now); elapsed < 500*time.Millisecond {
+		t.Errorf("expected the rotated request to be paced, sent after %s", elapsed)
+	}
+	if w := second.windows["/friends/ids"]; w.Remaining != 0 || w.pending != 1 {
+		t.Errorf("expected the call booked on the second token, actual %+v", w)
+	}
+}
@@ -0,0 +1,235 @@
+package twitter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jdevoo/nucoll/util"
+)
+
+// Window is the state of the rate limit window of an endpoint family
+// pending counts calls booked but not answered yet, which the announced remaining calls do not reflect
+type Window struct {
+	Limit     int   `json:"limit"`
+	Remaining int   `json:"remaining"`
+	Reset     int64 `json:"reset"`
+	last      time.Time
+	pending   int
+}
+
+// Scheduler paces requests so the remaining calls of each endpoint family are spread
+// evenly until its window resets instead of being used up at once followed by a 429
+// it is the budget shared by concurrent requests, which book calls before they are sent
+// windows are persisted to Path between runs unless it is empty, when a window starts and at exit
+type Scheduler struct {
+	Path    string
+	mu      sync.Mutex
+	saveMu  sync.Mutex
+	windows map[string]*Window
+	dirty   bool
+}
+
+// family returns the rate limit family of a path, named as by rate_limit_status for v1.1 e.g. /friends/ids
+// and after the endpoint with its parameters for v2 e.g. /2/users/:id/following, or an empty string for
+// paths which are not tracked
+func family(path string) string {
+	if i := strings.Index(path, "/1.1/"); i >= 0 {
+		return strings.TrimSuffix(path[i+len("/1.1"):], ".json")
+	}
+	i := strings.Index(path, "/2/")
+	if i < 0 {
+		return ""
+	}
+	parts := strings.Split(path[i+1:], "/")
+	for j := 2; j < len(parts); j++ {
+		switch parts[j-1] {
+		case "username":
+			parts[j] = ":username"
+		case "users", "tweets", "lists":
+			if parts[j] != "by" && parts[j] != "search" {
+				parts[j] = ":id"
+			}
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// NewScheduler returns a scheduler restoring the windows saved in path if any
+// a damaged file is ignored since the windows are seeded again
+func NewScheduler(path string) (*Scheduler, error) {
+	s := &Scheduler{Path: path, windows: make(map[string]*Window)}
+	if path == "" {
+		return s, nil
+	}
+	util.AtExit(func() {
+		if err := s.Save(); err != nil {
+			log.Printf("failed to save rate limits: %s\n", err)
+		}
+	})
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.windows); err != nil {
+		log.Printf("ignoring invalid rate limits in %s: %s\n", path, err)
+		s.windows = make(map[string]*Window)
+	}
+	return s, nil
+}
+
+// Current reports whether a window restored or seeded earlier has not reset yet
+func (s *Scheduler) Current() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range s.windows {
+		if time.Unix(w.Reset, 0).After(time.Now()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Available reports whether family f has calls left in its window or the window has reset
+func (s *Scheduler) Available(f string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.windows[f]
+	return !ok || w.Remaining > 0 || !time.Unix(w.Reset, 0).After(time.Now())
+}
+
+// reserve books the next call of family f and returns how long to wait before making it
+// the remaining calls are spread over what is left of the window
+func (s *Scheduler) reserve(f string, now time.Time) time.Duration {
+	var delay time.Duration
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.windows[f]
+	if !ok {
+		return 0
+	}
+	reset := time.Unix(w.Reset, 0)
+	switch {
+	case !reset.After(now):
+		// the window has reset, the next response tells its new state
+		w.pending = 0
+		return 0
+	case w.Remaining <= 0:
+		delay = reset.Sub(now) + time.Second
+	default:
+		delay = reset.Sub(now)/time.Duration(w.Remaining) - now.Sub(w.last)
+		w.Remaining--
+		w.pending++
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	w.last = now.Add(delay)
+	return delay
+}
+
+// Wait blocks until req may be sent without exceeding the pace of its endpoint family
+func (s *Scheduler) Wait(req *http.Request) error {
+	f := family(req.URL.Path)
+	if f == "" {
+		return nil
+	}
+	delay := s.reserve(f, time.Now())
+	if delay == 0 {
+		return nil
+	}
+	if delay > time.Minute {
+		log.Printf("%s limit reached; waiting until %s to resume", f, time.Now().Add(delay).Format("15:04:05"))
+	}
+	return util.Sleep(req, delay)
+}
+
+// Update records the window announced by the rate limit headers of res
+func (s *Scheduler) Update(res *http.Response) {
+	if res.Request == nil {
+		return
+	}
+	f := family(res.Request.URL.Path)
+	limit, lerr := strconv.Atoi(res.Header.Get("x-rate-limit-limit"))
+	remaining, rerr := strconv.Atoi(res.Header.Get("x-rate-limit-remaining"))
+	reset, serr := strconv.ParseInt(res.Header.Get("x-rate-limit-reset"), 10, 64)
+	if f == "" || lerr != nil || rerr != nil || serr != nil {
+		return
+	}
+	s.mu.Lock()
+	w, ok := s.windows[f]
+	if !ok {
+		w = &Window{}
+		s.windows[f] = w
+	}
+	started := w.Reset != reset
+	if started {
+		w.pending = 0
+	} else if w.pending > 0 {
+		w.pending--
+	}
+	w.Limit, w.Remaining, w.Reset = limit, remaining-w.pending, reset
+	s.dirty = true
+	s.mu.Unlock()
+	// remaining calls are saved at exit, a new window right away so concurrent runs see it
+	if started {
+		if err := s.Save(); err != nil {
+			log.Printf("failed to save rate limits: %s\n", err)
+		}
+	}
+}
+
+// Seed replaces the windows with those reported by application/rate_limit_status
+func (s *Scheduler) Seed(client *http.Client, base string) error {
+	var status struct {
+		Resources map[string]map[string]Window `json:"resources"`
+	}
+
+	res, err := client.Get(endpointURL(base, "application/rate_limit_status", "resources=application,friends,followers,lists,search,statuses,users"))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for _, families := range status.Resources {
+		for f, w := range families {
+			w := w
+			s.windows[f] = &w
+		}
+	}
+	s.dirty = true
+	s.mu.Unlock()
+	return s.Save()
+}
+
+// Save writes the windows to Path if they changed since they were last saved
+// the file is written outside the lock so requests are not held up by the disk
+func (s *Scheduler) Save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	if s.Path == "" || !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(s.windows)
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(s.Path, b, 0600)
+}
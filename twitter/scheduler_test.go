@@ -0,0 +1,186 @@
+package twitter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jdevoo/nucoll/util"
+)
+
+func TestFamily(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/1.1/friends/ids.json":          "/friends/ids",
+		"/gateway/1.1/users/lookup.json": "/users/lookup",
+		"/2/users/by/username/alice":     "/2/users/by/username/:username",
+		"/2/users/12/following":          "/2/users/:id/following",
+		"/2/users/by":                    "/2/users/by",
+		"/2/tweets/search/recent":        "/2/tweets/search/recent",
+		"/2/tweets/100/retweeted_by":     "/2/tweets/:id/retweeted_by",
+		"/2/lists/7/members":             "/2/lists/:id/members",
+		"/oauth2/token":                  "",
+	} {
+		if actual := family(path); actual != expected {
+			t.Errorf("family(%s): expected %q, actual %q", path, expected, actual)
+		}
+	}
+}
+
+func TestUpdateV2(t *testing.T) {
+	s, _ := NewScheduler("")
+	for _, uid := range []string{"12", "13"} {
+		req, _ := http.NewRequest(http.MethodGet, "https://api.twitter.com/2/users/"+uid+"/following", nil)
+		res := &http.Response{Request: req, Header: http.Header{}}
+		res.Header.Set("x-rate-limit-limit", "15")
+		res.Header.Set("x-rate-limit-remaining", "0")
+		res.Header.Set("x-rate-limit-reset", fmt.Sprint(time.Now().Add(time.Minute).Unix()))
+		s.Update(res)
+	}
+	if len(s.windows) != 1 || s.Available("/2/users/:id/following") {
+		t.Errorf("expected one exhausted window for following, actual %v", s.windows)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	now := time.Now()
+	s, _ := NewScheduler("")
+	s.windows["/users/lookup"] = &Window{Limit: 900, Remaining: 2, Reset: now.Add(10 * time.Second).Unix()}
+	s.windows["/friends/ids"] = &Window{Limit: 15, Remaining: 0, Reset: now.Add(time.Minute).Unix()}
+	s.windows["/search/tweets"] = &Window{Limit: 180, Remaining: 0, Reset: now.Add(-time.Minute).Unix()}
+
+	if d := s.reserve("/users/lookup", now); d != 0 {
+		t.Errorf("first call: expected no delay, actual %s", d)
+	}
+	if d := s.reserve("/users/lookup", now); d < 9*time.Second || d > 10*time.Second {
+		t.Errorf("second call: expected the rest of the window, actual %s", d)
+	}
+	if d := s.reserve("/friends/ids", now); d < time.Minute {
+		t.Errorf("exhausted window: expected to wait for the reset, actual %s", d)
+	}
+	if d := s.reserve("/search/tweets", now); d != 0 {
+		t.Errorf("reset window: expected no delay, actual %s", d)
+	}
+	if d := s.reserve("/statuses/user_timeline", now); d != 0 {
+		t.Errorf("unknown window: expected no delay, actual %s", d)
+	}
+}
+
+func TestSeedPersist(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "nucoll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "limits")
+
+	s, err := NewScheduler(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Current() {
+		t.Fatal("empty scheduler is current")
+	}
+	client := &http.Client{Transport: &NucollTransport{Config: &util.NucollConfig{}, Scheduler: s, Transport: http.DefaultTransport}}
+	if err := s.Seed(client, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := NewScheduler(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Current() {
+		t.Fatal("restored scheduler is not current")
+	}
+	for f, remaining := range map[string]int{"/friends/ids": 0, "/users/lookup": 900, "/application/rate_limit_status": 179} {
+		if w, ok := restored.windows[f]; !ok || w.Remaining != remaining {
+			t.Errorf("%s: expected %d remaining, actual %+v", f, remaining, w)
+		}
+	}
+}
+
+func TestPending(t *testing.T) {
+	now := time.Now()
+	reset := now.Add(time.Hour).Unix()
+	s, _ := NewScheduler("")
+	s.windows["/friends/ids"] = &Window{Limit: 15, Remaining: 3, Reset: reset}
+	for i := 0; i < 3; i++ {
+		s.reserve("/friends/ids", now)
+	}
+	if s.Available("/friends/ids") {
+		t.Fatal("booked calls left quota")
+	}
+	// the first answer still counts the two calls in flight
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/1.1/friends/ids.json", nil)
+	res := &http.Response{Request: req, Header: http.Header{}}
+	res.Header.Set("x-rate-limit-limit", "15")
+	res.Header.Set("x-rate-limit-remaining", "2")
+	res.Header.Set("x-rate-limit-reset", fmt.Sprint(reset))
+	s.Update(res)
+	if w := s.windows["/friends/ids"]; w.Remaining != 0 || w.pending != 2 {
+		t.Errorf("expected no quota with 2 pending, actual %+v", w)
+	}
+}
+
+func TestSchedulerSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nucoll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "limits")
+
+	// a file truncated by an interrupted run is ignored
+	if err := ioutil.WriteFile(path, []byte(`{"/friends/ids":{"limit":15,"rem`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewScheduler(path)
+	if err != nil || s.Current() {
+		t.Fatalf("expected empty scheduler, actual %v", err)
+	}
+
+	reset := time.Now().Add(time.Hour).Unix()
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/1.1/friends/ids.json", nil)
+	update := func(remaining int) {
+		res := &http.Response{Request: req, Header: http.Header{}}
+		res.Header.Set("x-rate-limit-limit", "15")
+		res.Header.Set("x-rate-limit-remaining", fmt.Sprint(remaining))
+		res.Header.Set("x-rate-limit-reset", fmt.Sprint(reset))
+		s.Update(res)
+	}
+	remaining := func() int {
+		restored, err := NewScheduler(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w, ok := restored.windows["/friends/ids"]; ok {
+			return w.Remaining
+		}
+		return -1
+	}
+
+	// a new window is saved right away, calls made within it at exit
+	update(14)
+	if r := remaining(); r != 14 {
+		t.Errorf("new window: expected 14 remaining saved, actual %d", r)
+	}
+	update(13)
+	if r := remaining(); r != 14 {
+		t.Errorf("same window: expected no write, actual %d remaining saved", r)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if r := remaining(); r != 13 {
+		t.Errorf("saved: expected 13 remaining, actual %d", r)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected no temporary file left, actual %d files", len(files))
+	}
+}
@@ -0,0 +1,641 @@
+package twitter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/util"
+)
+
+// fields and expansions requested from v2 endpoints to populate UserObject and TweetObject
+// https://developer.twitter.com/en/docs/twitter-api/fields
+const (
+	userFieldsV2  = "created_at,location,profile_image_url,protected,public_metrics,url,verified"
+	tweetFieldsV2 = "author_id,conversation_id,created_at,in_reply_to_user_id,public_metrics,referenced_tweets"
+	expansionsV2  = "author_id,in_reply_to_user_id"
+)
+
+func init() {
+	sns.Register(sns.Backend{
+		Name:        "twitter2",
+		Description: "Twitter API v2 using the same credentials",
+		New:         func() sns.SocialNetworkService { return TwitterV2{} },
+		Audit:       true,
+	})
+}
+
+// TwitterV2 client using the v2 endpoints with the same authentication and base URL as Twitter
+type TwitterV2 struct {
+	Client  *http.Client
+	BaseURL string
+}
+
+// UserV2 defines attributes of a user returned with userFieldsV2
+type UserV2 struct {
+	ID              string `json:"id"`
+	Username        string `json:"username"`
+	Protected       bool   `json:"protected"`
+	Verified        bool   `json:"verified"`
+	CreatedAt       string `json:"created_at"`
+	URL             string `json:"url"`
+	ProfileImageURL string `json:"profile_image_url"`
+	Location        string `json:"location"`
+	PublicMetrics   struct {
+		FollowersCount int `json:"followers_count"`
+		FollowingCount int `json:"following_count"`
+		TweetCount     int `json:"tweet_count"`
+		ListedCount    int `json:"listed_count"`
+	} `json:"public_metrics"`
+}
+
+// TweetV2 defines attributes of a tweet returned with tweetFieldsV2
+type TweetV2 struct {
+	ID               string `json:"id"`
+	Text             string `json:"text"`
+	AuthorID         string `json:"author_id"`
+	CreatedAt        string `json:"created_at"`
+	InReplyToUserID  string `json:"in_reply_to_user_id"`
+	ReferencedTweets []struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	} `json:"referenced_tweets"`
+	PublicMetrics struct {
+		RetweetCount int `json:"retweet_count"`
+		LikeCount    int `json:"like_count"`
+	} `json:"public_metrics"`
+}
+
+// ResponseV2 is the envelope of v2 responses; Data holds an object or an array
+type ResponseV2 struct {
+	Data     json.RawMessage `json:"data"`
+	Includes struct {
+		Users []UserV2 `json:"users"`
+	} `json:"includes"`
+	Meta struct {
+		NextToken string `json:"next_token"`
+	} `json:"meta"`
+	Errors []ProblemV2 `json:"errors"`
+}
+
+// ProblemV2 is an error reported in the body of a v2 response
+// https://developer.twitter.com/en/support/twitter-api/error-troubleshooting
+type ProblemV2 struct {
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+	Type   string `json:"type"`
+}
+
+func (p *ProblemV2) Error() string {
+	return fmt.Sprintf("%s: %s", p.Title, p.Detail)
+}
+
+// inaccessible reports whether the problem concerns a protected, suspended or deleted account
+// the type then ends with not-authorized-for-resource or resource-not-found
+func (p *ProblemV2) inaccessible() bool {
+	return strings.HasSuffix(p.Type, "/not-authorized-for-resource") || strings.HasSuffix(p.Type, "/resource-not-found")
+}
+
+// connect creates the client on first use
+func (ns *TwitterV2) connect() error {
+	var err error
+
+	if ns.BaseURL == "" {
+		if ns.BaseURL, err = baseURL(); err != nil {
+			return fmt.Errorf("failed to read config: %w", err)
+		}
+	}
+	if ns.Client == nil {
+		if ns.Client, err = NewClient(); err != nil {
+			return fmt.Errorf("failed to create Twitter client: %w", err)
+		}
+	}
+	return nil
+}
+
+// get decodes a v2 response; errors are only returned when no data came back
+func (ns TwitterV2) get(ctx context.Context, path string, params url.Values) (*ResponseV2, error) {
+	var result ResponseV2
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpointURL(ns.BaseURL, "v2", "")+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := ns.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Data) == 0 && len(result.Errors) > 0 {
+		return nil, &result.Errors[0]
+	}
+	return &result, nil
+}
+
+// pages calls fn for each page of a paginated endpoint until it returns false or pages run out
+// search endpoints expect next_token while others expect pagination_token
+func (ns TwitterV2) pages(ctx context.Context, path string, params url.Values, fn func(*ResponseV2) (bool, error)) error {
+	token := "pagination_token"
+	if strings.HasPrefix(path, "tweets/search") {
+		token = "next_token"
+	}
+	for {
+		res, err := ns.get(ctx, path, params)
+		if err != nil {
+			return err
+		}
+		more, err := fn(res)
+		if err != nil || !more || res.Meta.NextToken == "" {
+			return err
+		}
+		params.Set(token, res.Meta.NextToken)
+	}
+}
+
+// users paginates through an endpoint returning users
+func (ns TwitterV2) users(ctx context.Context, path string, params url.Values) ([]UserV2, error) {
+	var result []UserV2
+
+	err := ns.pages(ctx, path, params, func(res *ResponseV2) (bool, error) {
+		var page []UserV2
+		if len(res.Data) > 0 {
+			if err := json.Unmarshal(res.Data, &page); err != nil {
+				return false, err
+			}
+		}
+		result = append(result, page...)
+		return true, nil
+	})
+	return result, err
+}
+
+// show returns a user given a username or numeric ID
+func (ns TwitterV2) show(ctx context.Context, handle string) (UserV2, error) {
+	var result UserV2
+	var path string
+
+	if util.DigitsOnly(handle) {
+		path = "users/" + handle
+	} else {
+		path = "users/by/username/" + handle
+	}
+	res, err := ns.get(ctx, path, url.Values{"user.fields": {userFieldsV2}})
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(res.Data, &result)
+	return result, err
+}
+
+// userID returns the numeric ID of a handle
+func (ns TwitterV2) userID(ctx context.Context, handle string) (string, error) {
+	if util.DigitsOnly(handle) {
+		return handle, nil
+	}
+	u, err := ns.show(ctx, handle)
+	return u.ID, err
+}
+
+// listID returns the ID of a list given its ID or the name of a list owned by handle
+func (ns TwitterV2) listID(ctx context.Context, list string, handle string) (string, error) {
+	if util.DigitsOnly(list) {
+		return list, nil
+	}
+	uid, err := ns.userID(ctx, handle)
+	if err != nil {
+		return "", err
+	}
+	var id string
+	err = ns.pages(ctx, "users/"+uid+"/owned_lists", url.Values{"max_results": {"100"}}, func(res *ResponseV2) (bool, error) {
+		var lists []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(res.Data, &lists); err != nil {
+			return false, err
+		}
+		for _, l := range lists {
+			if strings.EqualFold(l.Name, list) {
+				id = l.ID
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err == nil && id == "" {
+		err = fmt.Errorf("list %q not found for %s", list, handle)
+	}
+	return id, err
+}
+
+// lookup hydrates up to 100 usernames or numeric IDs, each kind with the endpoint it belongs to
+func (ns TwitterV2) lookup(ctx context.Context, handles []string) ([]UserV2, error) {
+	var result []UserV2
+	var ids, usernames []string
+
+	for _, handle := range handles {
+		if util.DigitsOnly(handle) {
+			ids = append(ids, handle)
+		} else {
+			usernames = append(usernames, handle)
+		}
+	}
+	for _, batch := range []struct {
+		path    string
+		param   string
+		handles []string
+	}{{"users", "ids", ids}, {"users/by", "usernames", usernames}} {
+		if len(batch.handles) == 0 {
+			continue
+		}
+		res, err := ns.get(ctx, batch.path, url.Values{"user.fields": {userFieldsV2}, batch.param: {strings.Join(batch.handles, ",")}})
+		if err != nil {
+			return nil, err
+		}
+		// none of the handles was found
+		if len(res.Data) == 0 {
+			continue
+		}
+		var page []UserV2
+		if err := json.Unmarshal(res.Data, &page); err != nil {
+			return nil, err
+		}
+		result = append(result, page...)
+	}
+	return result, nil
+}
+
+// retweetersOf returns followers of handle who retweeted one of its last maxCount tweets
+func (ns TwitterV2) retweetersOf(ctx context.Context, handle string, maxCount int) ([]UserV2, error) {
+	var result []UserV2
+
+	uid, err := ns.userID(ctx, handle)
+	if err != nil {
+		return nil, err
+	}
+	followers, err := ns.users(ctx, "users/"+uid+"/followers", url.Values{"max_results": {"1000"}})
+	if err != nil {
+		return nil, err
+	}
+	isFollower := make(map[string]bool)
+	for _, u := range followers {
+		isFollower[u.ID] = true
+	}
+	seen := make(map[string]bool)
+	c := 0
+	params := url.Values{"max_results": {"100"}, "exclude": {"retweets,replies"}, "tweet.fields": {tweetFieldsV2}}
+	err = ns.pages(ctx, "users/"+uid+"/tweets", params, func(res *ResponseV2) (bool, error) {
+		var tweets []TweetV2
+		if err := json.Unmarshal(res.Data, &tweets); err != nil {
+			return false, err
+		}
+		for _, tweet := range tweets {
+			if tweet.PublicMetrics.RetweetCount == 0 {
+				continue
+			}
+			retweeters, err := ns.users(ctx, "tweets/"+tweet.ID+"/retweeted_by", url.Values{"max_results": {"100"}, "user.fields": {userFieldsV2}})
+			if err != nil {
+				return false, err
+			}
+			for _, u := range retweeters {
+				if isFollower[u.ID] && !seen[u.ID] {
+					seen[u.ID] = true
+					result = append(result, u)
+				}
+			}
+		}
+		log.Printf("processed %d tweets from %s\n", len(tweets), handle)
+		c += len(tweets)
+		return c < maxCount, nil
+	})
+	return result, err
+}
+
+// userObject maps a v2 user to the v1.1 layout of .dat files
+func userObject(u UserV2, relation string, subject string) UserObject {
+	id, _ := strconv.ParseUint(u.ID, 10, 64)
+	return UserObject{
+		ID:              id,
+		ScreenName:      u.Username,
+		Protected:       u.Protected,
+		Verified:        u.Verified,
+		FriendsCount:    u.PublicMetrics.FollowingCount,
+		FollowersCount:  u.PublicMetrics.FollowersCount,
+		ListedCount:     u.PublicMetrics.ListedCount,
+		StatusesCount:   u.PublicMetrics.TweetCount,
+		CreatedAt:       util.RubyDate(u.CreatedAt),
+		URL:             u.URL,
+		ProfileImageURL: u.ProfileImageURL,
+		Location:        u.Location,
+		Relation:        relation,
+		Subject:         subject,
+	}
+}
+
+// tweetObjects maps v2 tweets to the v1.1 layout of .qry files using expanded users
+func tweetObjects(res *ResponseV2) ([]TweetObject, error) {
+	var tweets []TweetV2
+
+	if len(res.Data) == 0 {
+		return nil, nil
+	}
+	if err := json.Unmarshal(res.Data, &tweets); err != nil {
+		return nil, err
+	}
+	usernames := make(map[string]string)
+	for _, u := range res.Includes.Users {
+		usernames[u.ID] = u.Username
+	}
+	result := make([]TweetObject, len(tweets))
+	for i, t := range tweets {
+		result[i].CreatedAt = util.RubyDate(t.CreatedAt)
+		result[i].ID, _ = strconv.ParseUint(t.ID, 10, 64)
+		result[i].User.ScreenName = usernames[t.AuthorID]
+		result[i].Text = t.Text
+		for _, ref := range t.ReferencedTweets {
+			if ref.Type == "replied_to" {
+				result[i].InReplyToTweet, _ = strconv.ParseUint(ref.ID, 10, 64)
+			}
+		}
+		result[i].InReplyToUser, _ = strconv.ParseUint(t.InReplyToUserID, 10, 64)
+		result[i].InReplyToScreenName = usernames[t.InReplyToUserID]
+		result[i].RetweetCount = t.PublicMetrics.RetweetCount
+		result[i].FavoriteCount = t.PublicMetrics.LikeCount
+	}
+	return result, nil
+}
+
+// Init supports retrieve handles from: list membership, a query file, followers who retweet or a following/followers relationship
+func (ns TwitterV2) Init(ctx context.Context, opts sns.InitOptions, args []string) (string, error) {
+	var users []UserV2
+	var err error
+	var relation string
+	var filename string
+
+	if err = ns.connect(); err != nil {
+		return "", err
+	}
+
+	if opts.Followers {
+		relation = "followers"
+	} else {
+		relation = "friends"
+	}
+
+	switch {
+	case opts.List != "":
+		// list members use case
+		var id string
+		if id, err = ns.listID(ctx, opts.List, args[0]); err == nil {
+			users, err = ns.users(ctx, "lists/"+id+"/members", url.Values{"max_results": {"100"}, "user.fields": {userFieldsV2}})
+		}
+		relation = opts.List
+	case opts.Query:
+		// query search or manually created query file
+		var handles []string
+		if handles, err = util.QueryReader(args[0], opts.NoMention); err == nil {
+			for page, width := 0, 100; page*width < len(handles) && err == nil; page++ {
+				end := (page + 1) * width
+				if end > len(handles) {
+					end = len(handles)
+				}
+				var result []UserV2
+				result, err = ns.lookup(ctx, handles[page*width:end])
+				users = append(users, result...)
+				log.Printf("processed %d starting from %s\n", end-page*width, handles[page*width])
+			}
+		}
+	case opts.MaxPostCount > 0:
+		// followers who retweet tweets by this handle
+		users, err = ns.retweetersOf(ctx, args[0], opts.MaxPostCount)
+		relation = "retweeter"
+	default:
+		// basic relation use case
+		var uid string
+		path := "following"
+		if opts.Followers {
+			path = "followers"
+		}
+		if uid, err = ns.userID(ctx, args[0]); err == nil {
+			users, err = ns.users(ctx, "users/"+uid+"/"+path, url.Values{"max_results": {"1000"}, "user.fields": {userFieldsV2}})
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	result := make([]UserObject, len(users))
+	for i, u := range users {
+		result[i] = userObject(u, relation, args[0])
+		// ignore erros on downloads
+		if opts.Images {
+			util.DownloadImage(ctx, u.ID, u.ProfileImageURL)
+		}
+	}
+	filename, err = util.CSVWriter(args[0], util.DatExt, false, result)
+	if err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// Fetch retrieves second-degree following from handles collected with Init
+func (ns TwitterV2) Fetch(ctx context.Context, opts sns.FetchOptions, args []string) error {
+	var err error
+
+	if err = ns.connect(); err != nil {
+		return err
+	}
+
+	data := []UserObject{}
+	if err = util.CSVReader(args[0], util.DatExt, &data); err != nil {
+		return err
+	}
+	return util.ForEach(ctx, len(data), opts.Workers, func(ctx context.Context, i int) (string, error) {
+		user := data[i]
+		uid := fmt.Sprintf("%d", user.ID)
+		// skip if file exists and flag to force call not set
+		if !opts.Force && util.FdatExists(uid) {
+			return "", nil
+		}
+		if user.FriendsCount > opts.MaxFriends {
+			return fmt.Sprintf("skipping %s (%d friends)", user.ScreenName, user.FriendsCount), nil
+		}
+		following, err := ns.users(ctx, "users/"+uid+"/following", url.Values{"max_results": {"1000"}})
+		var unauthorized *UnauthorizedError
+		if errors.As(err, &unauthorized) {
+			// no friends file so the handle is fetched again with credentials allowed to see it
+			return fmt.Sprintf("skipping %s (%s)", user.ScreenName, unauthorized.Message), nil
+		}
+		var problem *ProblemV2
+		if errors.As(err, &problem) && problem.inaccessible() {
+			return fmt.Sprintf("skipping %s (%s)", user.ScreenName, problem.Detail), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		ids := make([]string, len(following))
+		for i, u := range following {
+			ids[i] = u.ID
+		}
+		if _, err := util.FdatWriter(uid, ids); err != nil {
+			return "", fmt.Errorf("failed to write friends file: %w", err)
+		}
+		return fmt.Sprintf("processed %s", user.ScreenName), nil
+	})
+}
+
+// Edgelist constructs the network of who is "friends" with whom among handles returned by Init
+func (ns TwitterV2) Edgelist(ctx context.Context, opts sns.EdgelistOptions, args []string) (string, error) {
+	var cols = []string{
+		"ID",
+		"ScreenName",
+		"Protected",
+		"Verified",
+		"FriendsCount",
+		"FollowersCount",
+		"ListedCount",
+		"StatusesCount",
+		"CreatedAt",
+		"ProfileImageURL",
+		"Relation",
+		"Subject",
+	}
+	var filename string
+	var err error
+
+	data := []UserObject{}
+	for _, handle := range args {
+		if err = util.CSVReader(handle, util.DatExt, &data); err != nil {
+			return "", err
+		}
+		if opts.Ego {
+			if err = ns.connect(); err != nil {
+				return "", err
+			}
+			self, err := ns.show(ctx, handle)
+			if err != nil {
+				return "", fmt.Errorf("failed to retrieve handle details: %w", err)
+			}
+			data = append(data, userObject(self, "", ""))
+		}
+	}
+	// call GMLWriter using ScreenName as label for nodes
+	if filename, err = util.GMLWriter(args, data, opts.Missing, cols, "ScreenName"); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s created\n", filename)
+	return filename, nil
+}
+
+// Posts retrieves tweets from a recent search query, a list, replies to a given tweet ID or from a handle
+func (ns TwitterV2) Posts(ctx context.Context, opts sns.PostsOptions, args []string) (string, error) {
+	var err error
+	var path string
+	var filename string
+	var postID uint64
+
+	if opts.PostID != "" {
+		if postID, err = strconv.ParseUint(opts.PostID, 10, 64); err != nil {
+			return "", fmt.Errorf("invalid tweet id %s", opts.PostID)
+		}
+	}
+	if err = ns.connect(); err != nil {
+		return "", err
+	}
+
+	params := url.Values{"max_results": {"100"}, "tweet.fields": {tweetFieldsV2}, "expansions": {expansionsV2}, "user.fields": {"username"}}
+	switch {
+	case opts.Query:
+		path = "tweets/search/recent"
+		params.Set("query", args[0])
+	case opts.List != "":
+		var id string
+		if id, err = ns.listID(ctx, opts.List, args[0]); err != nil {
+			return "", fmt.Errorf("failed to retrieve list: %w", err)
+		}
+		path = "lists/" + id + "/tweets"
+	case postID != 0:
+		path = "tweets/search/recent"
+		params.Set("query", fmt.Sprintf("conversation_id:%d to:%s", postID, args[0]))
+	default:
+		var uid string
+		if uid, err = ns.userID(ctx, args[0]); err != nil {
+			return "", fmt.Errorf("failed to retrieve handle details: %w", err)
+		}
+		path = "users/" + uid + "/tweets"
+	}
+
+	page := 0
+	err = ns.pages(ctx, path, params, func(res *ResponseV2) (bool, error) {
+		tweets, err := tweetObjects(res)
+		if err != nil {
+			return false, err
+		}
+		if postID != 0 {
+			result := SearchResult{Statuses: tweets}
+			(&result).filterByTweetID(postID)
+			tweets = result.Statuses
+		}
+		if len(tweets) == 0 {
+			return true, nil
+		}
+		filename, err = util.CSVWriter(args[0], util.QueryExt, page > 0, tweets)
+		if err != nil {
+			return false, errors.New("failed to write posts: " + err.Error())
+		}
+		if page == 0 {
+			log.Printf("%s created\n", filename)
+		}
+		log.Printf("processed %d tweets\n", len(tweets))
+		page++
+		return true, nil
+	})
+	if err != nil {
+		return filename, fmt.Errorf("failed to use Twitter client: %w", err)
+	}
+	return filename, nil
+}
+
+// Test checks the credentials shared with v1.1 with the same call
+func (ns TwitterV2) Test(ctx context.Context) (string, error) {
+	if err := ns.connect(); err != nil {
+		return "", err
+	}
+	return Twitter{Client: ns.Client, BaseURL: ns.BaseURL}.Test(ctx)
+}
+
+// Invalidate revokes the bearer token shared with v1.1
+func (ns TwitterV2) Invalidate(ctx context.Context) (string, error) {
+	return Twitter{BaseURL: ns.BaseURL}.Invalidate(ctx)
+}
+
+// Resolve converts screen names to IDs and vice versa along with basic stats
+func (ns TwitterV2) Resolve(ctx context.Context, args []string) ([]sns.Profile, error) {
+	var result []sns.Profile
+
+	if err := ns.connect(); err != nil {
+		return nil, err
+	}
+
+	for _, handle := range args {
+		u, err := ns.show(ctx, handle)
+		if err != nil {
+			return result, fmt.Errorf("failed to retrieve handle details: %w", err)
+		}
+		result = append(result, userObject(u, "", "").Profile(handle))
+	}
+	return result, nil
+}
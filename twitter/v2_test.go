@@ -0,0 +1,206 @@
+package twitter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jdevoo/nucoll/sns"
+	"github.com/jdevoo/nucoll/util"
+	"github.com/jdevoo/nucoll/util/utiltest"
+)
+
+// userV2 maps a user of the stand-in API to the v2 layout
+func userV2(u UserObject) UserV2 {
+	result := UserV2{ID: fmt.Sprint(u.ID), Username: u.ScreenName, Protected: u.Protected}
+	result.PublicMetrics.FollowingCount = u.FriendsCount
+	result.PublicMetrics.FollowersCount = u.FollowersCount
+	result.PublicMetrics.TweetCount = u.StatusesCount
+	return result
+}
+
+// serveV2 answers v2 requests of the stand-in API, or returns nil for unknown paths
+// users takes ids only and users/by takes usernames only
+// following is served one user per page and search one tweet per page, each with the token parameter its endpoint expects
+func serveV2(r *http.Request) interface{} {
+	q := r.URL.Query()
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, endpoints["v2"]), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "users" && q.Get("usernames") == "",
+		len(parts) == 2 && parts[0] == "users" && parts[1] == "by" && q.Get("ids") == "":
+		var result []UserV2
+		for _, k := range strings.Split(q.Get("ids")+q.Get("usernames"), ",") {
+			if u, ok := lookupUser(k); ok {
+				result = append(result, userV2(u))
+			}
+		}
+		if len(result) == 0 {
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{"data": result}
+	case len(parts) == 4 && parts[0] == "users" && parts[1] == "by" && parts[2] == "username",
+		len(parts) == 2 && parts[0] == "users":
+		u, ok := lookupUser(parts[len(parts)-1])
+		if !ok {
+			return nil
+		}
+		return map[string]interface{}{"data": userV2(u)}
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "following":
+		if q.Get("next_token") != "" {
+			return nil
+		}
+		// protected and unknown users are reported in the body of a 200 response
+		if u, ok := lookupUser(parts[1]); !ok || u.Protected {
+			problem := ProblemV2{Title: "Forbidden", Detail: "User has been suspended: [" + parts[1] + "].", Type: "https://api.twitter.com/2/problems/resource-not-found"}
+			if ok {
+				problem = ProblemV2{Title: "Authorization Error", Detail: "Sorry, you are not authorized to see the user with id: [" + parts[1] + "].", Type: "https://api.twitter.com/2/problems/not-authorized-for-resource"}
+			}
+			return map[string]interface{}{"errors": []ProblemV2{problem}}
+		}
+		ids := friends[parts[1]]
+		i, _ := strconv.Atoi(q.Get("pagination_token"))
+		if i >= len(ids) {
+			return map[string]interface{}{"meta": map[string]int{"result_count": 0}}
+		}
+		u, _ := lookupUser(ids[i])
+		meta := map[string]string{}
+		if i+1 < len(ids) {
+			meta["next_token"] = fmt.Sprint(i + 1)
+		}
+		return map[string]interface{}{"data": []UserV2{userV2(u)}, "meta": meta}
+	case strings.Join(parts, "/") == "tweets/search/recent":
+		if q.Get("pagination_token") != "" {
+			return nil
+		}
+		alice, bob := userV2(users["1"]), userV2(users["2"])
+		if q.Get("next_token") == "" {
+			t := TweetV2{ID: "100", Text: "hello", AuthorID: "1", CreatedAt: "2021-01-02T15:04:05Z"}
+			return map[string]interface{}{
+				"data":     []TweetV2{t},
+				"includes": map[string][]UserV2{"users": {alice}},
+				"meta":     map[string]string{"next_token": "1"},
+			}
+		}
+		t := TweetV2{ID: "101", Text: "hi", AuthorID: "2", InReplyToUserID: "1"}
+		t.ReferencedTweets = append(t.ReferencedTweets, struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+		}{"replied_to", "100"})
+		return map[string]interface{}{
+			"data":     []TweetV2{t},
+			"includes": map[string][]UserV2{"users": {bob, alice}},
+			"meta":     map[string]string{},
+		}
+	}
+	return nil
+}
+
+func TestCommandsV2(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+	utiltest.InWorkspace(t)
+
+	ns := TwitterV2{Client: srv.Client(), BaseURL: srv.URL}
+	ctx := context.Background()
+	if _, err := ns.Init(ctx, sns.InitOptions{}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	data := []UserObject{}
+	if err := util.CSVReader("alice", util.DatExt, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2 || data[0].ScreenName != "bob" || data[1].ScreenName != "carol" || data[1].Relation != "friends" {
+		t.Fatalf("Init: expected both pages of following, actual %+v", data)
+	}
+
+	if err := ns.Fetch(ctx, sns.FetchOptions{MaxFriends: 5000}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if !util.FdatExists("2") || !util.FdatExists("3") {
+		t.Fatal("Fetch: missing friends files")
+	}
+	filename, err := ns.Edgelist(ctx, sns.EdgelistOptions{Ego: true}, []string{"alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// alice follows bob and carol as collected by Init, bob follows alice as collected by Fetch
+	if n := strings.Count(string(b), "edge ["); n != 3 {
+		t.Errorf("Edgelist: expected 3 edges, actual %d", n)
+	}
+}
+
+func TestPostsV2(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+	utiltest.InWorkspace(t)
+
+	ns := TwitterV2{Client: srv.Client(), BaseURL: srv.URL}
+	filename, err := ns.Posts(context.Background(), sns.PostsOptions{Query: true}, []string{"nucoll"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Posts: expected a header and a tweet from each page, actual %q", b)
+	}
+	// authors and reply targets are named after the expanded users
+	if !strings.Contains(lines[1], "Sat Jan 02 15:04:05 +0000 2021,100,@alice,hello") {
+		t.Errorf("Posts: unexpected first tweet %q", lines[1])
+	}
+	if !strings.Contains(lines[2], ",101,@bob,hi,100,1,alice,") {
+		t.Errorf("Posts: unexpected reply %q", lines[2])
+	}
+}
+
+func TestLookupV2(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+
+	ns := TwitterV2{Client: srv.Client(), BaseURL: srv.URL}
+	result, err := ns.lookup(context.Background(), []string{"alice", "2", "carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, u := range result {
+		names = append(names, u.Username)
+	}
+	if strings.Join(names, " ") != "bob alice carol" {
+		t.Errorf("lookup: expected ids and usernames to be hydrated, actual %v", names)
+	}
+	result, err = ns.lookup(context.Background(), []string{"dave"})
+	if err != nil || len(result) != 0 {
+		t.Errorf("lookup: expected no results, actual %v (%v)", result, err)
+	}
+}
+
+func TestFetchV2Skips(t *testing.T) {
+	srv := newAPI()
+	defer srv.Close()
+	utiltest.InWorkspace(t)
+
+	// erin is protected and dave suspended
+	data := []UserObject{{ID: 4, ScreenName: "erin"}, {ID: 9, ScreenName: "dave"}, {ID: 2, ScreenName: "bob", FriendsCount: 1}}
+	if _, err := util.CSVWriter("alice", util.DatExt, false, data); err != nil {
+		t.Fatal(err)
+	}
+	ns := TwitterV2{Client: srv.Client(), BaseURL: srv.URL}
+	if err := ns.Fetch(context.Background(), sns.FetchOptions{MaxFriends: 5000, Workers: 2}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if util.FdatExists("4") || util.FdatExists("9") || !util.FdatExists("2") {
+		t.Error("expected erin and dave to be skipped and bob to be fetched")
+	}
+}
@@ -0,0 +1,172 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// AuditFlag is the audit log given on the command line, it overrides the audit_log setting of the network
+var AuditFlag string
+
+// AuditRecord documents one request sent to an API
+// Command and Collection name the run which made it, e.g. fetch and the handle of the .dat file
+type AuditRecord struct {
+	Time       time.Time         `json:"time"`
+	Command    string            `json:"command,omitempty"`
+	Collection string            `json:"collection,omitempty"`
+	Method     string            `json:"method"`
+	Endpoint   string            `json:"endpoint"`
+	Params     url.Values        `json:"params,omitempty"`
+	Status     int               `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	RateLimit  map[string]string `json:"rate_limit,omitempty"`
+	Bytes      int64             `json:"bytes"`
+	DurationMS int64             `json:"duration_ms"`
+	Credential string            `json:"credential,omitempty"`
+}
+
+// AuditLog appends records as JSON Lines to Path
+// the file is opened for each record so it stays complete whenever a run stops
+type AuditLog struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// Append writes r at the end of the log
+func (a *AuditLog) Append(r AuditRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// AuditPath returns the audit log of network taken from the command line or the audit_log setting of its section
+// it is empty when requests are not audited
+func (c *NucollConfig) AuditPath(network string) string {
+	var section struct {
+		AuditLog string `json:"audit_log"`
+	}
+
+	if AuditFlag != "" {
+		return AuditFlag
+	}
+	c.Section(network, &section)
+	return section.AuditLog
+}
+
+// auditKey is the context key of the command and collection requests are made for
+type auditKey struct{}
+
+// WithCollection returns a context whose requests are audited as made by command for collection
+func WithCollection(ctx context.Context, command string, collection string) context.Context {
+	return context.WithValue(ctx, auditKey{}, [2]string{command, collection})
+}
+
+// CollectionOf returns the command and collection set with WithCollection
+func CollectionOf(ctx context.Context) (string, string) {
+	v, _ := ctx.Value(auditKey{}).([2]string)
+	return v[0], v[1]
+}
+
+// auditTotal accumulates the records of one line of a summary
+type auditTotal struct {
+	calls    int
+	errors   int
+	bytes    int64
+	duration int64
+}
+
+func (t *auditTotal) add(r AuditRecord) {
+	t.calls++
+	if r.Error != "" || r.Status >= 400 {
+		t.errors++
+	}
+	t.bytes += r.Bytes
+	t.duration += r.DurationMS
+}
+
+// AuditSummary reads the audit log at path and writes the calls made per endpoint, per day and per collection to w
+func AuditSummary(path string, w io.Writer) error {
+	var count int
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	groups := []struct {
+		title  string
+		key    func(AuditRecord) string
+		totals map[string]*auditTotal
+	}{
+		{"endpoint", func(r AuditRecord) string { return r.Method + " " + r.Endpoint }, nil},
+		{"day", func(r AuditRecord) string { return r.Time.UTC().Format("2006-01-02") }, nil},
+		{"collection", func(r AuditRecord) string {
+			if r.Command == "" {
+				return "-"
+			}
+			return r.Command + " " + r.Collection
+		}, nil},
+	}
+	for i := range groups {
+		groups[i].totals = make(map[string]*auditTotal)
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var r AuditRecord
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("invalid audit record on line %d of %s: %w", line, path, err)
+		}
+		count++
+		for _, g := range groups {
+			k := g.key(r)
+			if g.totals[k] == nil {
+				g.totals[k] = &auditTotal{}
+			}
+			g.totals[k].add(r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "%d calls recorded in %s\n", count, path)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, g := range groups {
+		keys := make([]string, 0, len(g.totals))
+		for k := range g.totals {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(tw, "\n%s\tcalls\terrors\tbytes\tavg ms\n", g.title)
+		for _, k := range keys {
+			t := g.totals[k]
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", k, t.calls, t.errors, t.bytes, t.duration/int64(t.calls))
+		}
+	}
+	return tw.Flush()
+}
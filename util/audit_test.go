@@ -0,0 +1,59 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestAuditSummary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nucoll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := &AuditLog{Path: filepath.Join(dir, "audit.jsonl")}
+
+	day := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	records := []AuditRecord{
+		{Time: day, Command: "init", Collection: "alice", Method: "GET", Endpoint: "/1.1/friends/ids.json", Status: 200, Bytes: 100, DurationMS: 10},
+		{Time: day, Command: "init", Collection: "alice", Method: "GET", Endpoint: "/1.1/users/lookup.json", Status: 200, Bytes: 300, DurationMS: 30},
+		{Time: day.Add(24 * time.Hour), Command: "fetch", Collection: "alice", Method: "GET", Endpoint: "/1.1/friends/ids.json", Status: 429, Bytes: 50, DurationMS: 20},
+	}
+	for _, r := range records {
+		if err := a.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	if err := AuditSummary(a.Path, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`3 calls recorded`,
+		`GET /1.1/friends/ids.json\s+2\s+1\s+150\s+15\n`,
+		`2026-10-16\s+2\s+0\s+400\s+20\n`,
+		`2026-10-17\s+1\s+1\s+50\s+20\n`,
+		`init alice\s+2\s+0\s+400\s+20\n`,
+		`fetch alice\s+1\s+1\s+50\s+20\n`,
+	} {
+		if !regexp.MustCompile(expected).Match(out.Bytes()) {
+			t.Errorf("expected %q in\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestCollectionOf(t *testing.T) {
+	ctx := WithCollection(context.Background(), "fetch", "alice")
+	if command, collection := CollectionOf(ctx); command != "fetch" || collection != "alice" {
+		t.Errorf("unexpected %q %q", command, collection)
+	}
+	if command, collection := CollectionOf(context.Background()); command != "" || collection != "" {
+		t.Errorf("unexpected %q %q", command, collection)
+	}
+}
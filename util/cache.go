@@ -0,0 +1,264 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheDir response cache directory
+const CacheDir = "cache"
+
+// NoCache is set on the command line to bypass the response cache
+var NoCache bool
+
+// DefaultCacheTTL and DefaultCacheSize apply unless the network section has cache settings
+const (
+	DefaultCacheTTL  = 24 * time.Hour
+	DefaultCacheSize = 100 << 20
+)
+
+// cachedResponse is a response as stored in the cache directory
+type cachedResponse struct {
+	Key        string      `json:"key"`
+	Stored     int64       `json:"stored"`
+	StatusCode int         `json:"status_code"`
+	Status     string      `json:"status"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+}
+
+// cacheEntry tracks the size and age of a file for eviction
+type cacheEntry struct {
+	name     string
+	size     int64
+	modified time.Time
+}
+
+// Cache stores successful responses to GET requests on disk for TTL
+// the least recently stored entries are evicted once the files exceed MaxSize bytes
+type Cache struct {
+	Dir     string
+	TTL     time.Duration
+	MaxSize int64
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	size    int64
+	hits    int
+	misses  int
+	stores  int
+	evicted int
+}
+
+// CacheKey normalizes the URL of req: scheme and host are lowercased and query parameters sorted
+// scope separates responses which depend on who asks, e.g. in user context
+func CacheKey(req *http.Request, scope string) string {
+	u := *req.URL
+	u.Scheme, u.Host = strings.ToLower(u.Scheme), strings.ToLower(u.Host)
+	u.RawQuery = u.Query().Encode()
+	u.Fragment = ""
+	return strings.TrimSpace(scope + " " + u.String())
+}
+
+// NewCache opens the cache stored in dir
+func NewCache(dir string, ttl time.Duration, maxSize int64) (*Cache, error) {
+	c := &Cache{Dir: dir, TTL: ttl, MaxSize: maxSize, entries: make(map[string]cacheEntry)}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".json") {
+			c.entries[f.Name()] = cacheEntry{f.Name(), f.Size(), f.ModTime()}
+			c.size += f.Size()
+		}
+	}
+	return c, nil
+}
+
+// filename returns the name of the file storing key
+func (c *Cache) filename(key string) string {
+	return fmt.Sprintf("%x.json", sha1.Sum([]byte(key)))
+}
+
+// Get returns the stored response to req unless it expired
+func (c *Cache) Get(req *http.Request, key string) *http.Response {
+	var cr cachedResponse
+
+	if req.Method != http.MethodGet {
+		return nil
+	}
+	name := c.filename(key)
+	b, err := ioutil.ReadFile(filepath.Join(c.Dir, name))
+	if err == nil {
+		err = json.Unmarshal(b, &cr)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil || cr.Key != key || time.Since(time.Unix(cr.Stored, 0)) > c.TTL {
+		c.misses++
+		return nil
+	}
+	c.hits++
+	return &http.Response{
+		Status:        cr.Status,
+		StatusCode:    cr.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        cr.Header,
+		Body:          ioutil.NopCloser(strings.NewReader(cr.Body)),
+		ContentLength: int64(len(cr.Body)),
+		Request:       req,
+	}
+}
+
+// Put stores res when it answered a GET with 200 OK and returns it with a fresh body
+// failing to store is only logged since the response itself is fine
+func (c *Cache) Put(req *http.Request, key string, res *http.Response) (*http.Response, error) {
+	if req.Method != http.MethodGet || res.StatusCode != http.StatusOK {
+		return res, nil
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	b, err := json.Marshal(cachedResponse{
+		Key:        key,
+		Stored:     time.Now().Unix(),
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+		Header:     res.Header,
+		Body:       string(body),
+	})
+	name := c.filename(key)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(c.Dir, name), b, 0600)
+	}
+	if err != nil {
+		log.Printf("failed to cache %s: %s\n", req.URL.Path, err)
+		return res, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stores++
+	c.size += int64(len(b)) - c.entries[name].size
+	c.entries[name] = cacheEntry{name, int64(len(b)), time.Now()}
+	c.evict()
+	return res, nil
+}
+
+// evict removes the oldest entries until the cache fits MaxSize, callers hold the lock
+func (c *Cache) evict() {
+	if c.MaxSize <= 0 || c.size <= c.MaxSize {
+		return
+	}
+	entries := make([]cacheEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modified.Before(entries[j].modified) })
+	for _, e := range entries {
+		if c.size <= c.MaxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.Dir, e.name)); err == nil || os.IsNotExist(err) {
+			c.size -= e.size
+			delete(c.entries, e.name)
+			c.evicted++
+		}
+	}
+}
+
+// Stats summarizes the use of the cache during the run
+func (c *Cache) Stats() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fmt.Sprintf("cache: %d hits, %d misses, %d stored, %d evicted, %d entries (%.1f MB)",
+		c.hits, c.misses, c.stores, c.evicted, len(c.entries), float64(c.size)/(1<<20))
+}
+
+var (
+	cache     *Cache
+	cacheErr  error
+	cacheOnce sync.Once
+)
+
+// OpenCache returns the cache of the run under CacheDir of the workspace, opened on first call with the settings of network
+// there is none with -no-cache or while recording or replaying since exchanges must then reach the transport
+func OpenCache(config *NucollConfig, network string) (*Cache, error) {
+	if NoCache || RecordDir != "" || ReplayDir != "" {
+		return nil, nil
+	}
+	cacheOnce.Do(func() {
+		var ttl time.Duration
+		var size int64
+
+		if ttl, size, cacheErr = config.Cache(network); cacheErr == nil {
+			cache, cacheErr = NewCache(WorkspacePath(CacheDir), ttl, size)
+		}
+	})
+	return cache, cacheErr
+}
+
+// CacheStats summarizes the use of the cache of the run or returns an empty string when it was not used
+func CacheStats() string {
+	if cache == nil {
+		return ""
+	}
+	cache.mu.Lock()
+	used := cache.hits+cache.misses > 0
+	cache.mu.Unlock()
+	if !used {
+		return ""
+	}
+	return cache.Stats()
+}
+
+// cacheSettings as stored in the cache object of a network section, e.g. {"ttl":"168h","max_mb":500}
+type cacheSettings struct {
+	TTL   string `json:"ttl"`
+	MaxMB int64  `json:"max_mb"`
+}
+
+// Cache returns the TTL and size cap of the response cache for network
+func (c *NucollConfig) Cache(network string) (time.Duration, int64, error) {
+	var section struct {
+		Cache *cacheSettings `json:"cache"`
+	}
+
+	ttl, size := DefaultCacheTTL, int64(DefaultCacheSize)
+	if err := c.Section(network, &section); err != nil {
+		return ttl, size, fmt.Errorf("invalid cache settings for %s: %w", network, err)
+	}
+	s := section.Cache
+	if s == nil {
+		return ttl, size, nil
+	}
+	if s.TTL != "" {
+		d, err := time.ParseDuration(s.TTL)
+		if err != nil {
+			return ttl, size, fmt.Errorf("invalid cache settings for %s: %w", network, err)
+		}
+		ttl = d
+	}
+	if s.MaxMB > 0 {
+		size = s.MaxMB << 20
+	}
+	return ttl, size, nil
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nucoll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := NewCache(dir, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := func(status int, body string) *http.Response {
+		return &http.Response{StatusCode: status, Status: http.StatusText(status), Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(body))}
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://API.example.com/users/show.json?screen_name=jdevoo&include_entities=false", nil)
+	same, _ := http.NewRequest(http.MethodGet, "https://api.example.com/users/show.json?include_entities=false&screen_name=jdevoo", nil)
+	if CacheKey(req, "") != CacheKey(same, "") {
+		t.Errorf("expected same key, actual %q and %q", CacheKey(req, ""), CacheKey(same, ""))
+	}
+	if CacheKey(req, "") == CacheKey(req, "user:jdevoo") {
+		t.Error("expected scopes to be kept apart")
+	}
+	key := CacheKey(req, "")
+	if res := c.Get(req, key); res != nil {
+		t.Fatal("expected miss on empty cache")
+	}
+	res, err := c.Put(req, key, response(http.StatusOK, `{"id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(res.Body); string(b) != `{"id":1}` {
+		t.Errorf("expected body to be readable after Put, actual %q", b)
+	}
+	res = c.Get(same, CacheKey(same, ""))
+	if res == nil {
+		t.Fatal("expected hit")
+	}
+	if b, _ := ioutil.ReadAll(res.Body); string(b) != `{"id":1}` {
+		t.Errorf("expected cached body, actual %q", b)
+	}
+
+	other, _ := http.NewRequest(http.MethodGet, "https://api.example.com/users/show.json?screen_name=dave", nil)
+	c.Put(other, CacheKey(other, ""), response(http.StatusNotFound, `{}`))
+	if c.Get(other, CacheKey(other, "")) != nil {
+		t.Error("expected errors not to be cached")
+	}
+
+	// entries older than the TTL are misses
+	c.TTL = -time.Second
+	if c.Get(req, key) != nil {
+		t.Error("expected expired entry to be a miss")
+	}
+	c.TTL = time.Hour
+	if stats := c.Stats(); !strings.HasPrefix(stats, "cache: 1 hits, 3 misses, 1 stored") {
+		t.Errorf("unexpected stats %q", stats)
+	}
+
+	// reopening finds the stored entries and evicts the oldest past the cap
+	c, err = NewCache(dir, time.Hour, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.entries) != 1 {
+		t.Errorf("expected 1 entry, actual %d", len(c.entries))
+	}
+	c.Put(other, CacheKey(other, ""), response(http.StatusOK, `{"id":2}`))
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 0 || c.size != 0 {
+		t.Errorf("expected entries over the cap to be evicted, actual %v", files)
+	}
+}
+
+func TestCacheConfig(t *testing.T) {
+	config := &NucollConfig{}
+	ttl, size, err := config.Cache("twitter")
+	if err != nil || ttl != DefaultCacheTTL || size != DefaultCacheSize {
+		t.Errorf("expected defaults, actual %s %d %v", ttl, size, err)
+	}
+	config.Networks = map[string]json.RawMessage{"twitter": json.RawMessage(`{"cache":{"ttl":"168h","max_mb":5}}`)}
+	ttl, size, err = config.Cache("twitter")
+	if err != nil || ttl != 168*time.Hour || size != 5<<20 {
+		t.Errorf("expected 168h and 5 MB, actual %s %d %v", ttl, size, err)
+	}
+	config.Networks["twitter"] = json.RawMessage(`{"cache":{"ttl":"week"}}`)
+	if _, _, err = config.Cache("twitter"); err == nil {
+		t.Error("expected invalid ttl to fail")
+	}
+}
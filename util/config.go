@@ -2,64 +2,398 @@ package util
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 )
 
+// BaseURLFlag is the API base URL given on the command line, it overrides any other setting
+var BaseURLFlag string
+
+// AuthMode is the authentication given on the command line for networks offering several
+var AuthMode string
+
+// ConfigFlag is the config file given on the command line instead of the one in the home directory
+var ConfigFlag string
+
+// ProfileFlag is the profile given on the command line, it overrides the default profile of the config file
+var ProfileFlag string
+
+// DefaultProfile names the settings kept at the top level of the config file
+const DefaultProfile = "default"
+
+// profileName matches the names allowed for profiles
+var profileName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 // TwitterConfig stores oauth2 client credential grants
+// and the OAuth 1.0a credentials of the account signed in for user context
 type TwitterConfig struct {
-	TokenType   string `json:"token_type"`
-	AccessToken string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	AccessToken      string `json:"access_token"`
+	ConsumerKey      string `json:"consumer_key,omitempty"`
+	ConsumerSecret   string `json:"consumer_secret,omitempty"`
+	OAuthToken       string `json:"oauth_token,omitempty"`
+	OAuthTokenSecret string `json:"oauth_token_secret,omitempty"`
+	ScreenName       string `json:"screen_name,omitempty"`
+	Name             string `json:"name,omitempty"`
 }
 
-//type PinterestConfig struct {
-//	PinterestID string `json:"clientId"`
-//	PinterestSecret string `json:"clientSecret"`
-//}
-
 // NucollConfig holds access details to all supported SNSes
+// Twitter credentials stay at the top level for compatibility with existing files
+// while other backends keep their settings in a section named after the network
+// Credentials lists further Twitter apps used in turn once the rate limit of one is reached
+// profile is the name of the profile the config was read from
 type NucollConfig struct {
 	TwitterConfig
-	//	PinterestConfig
+	Credentials []TwitterConfig            `json:"credentials,omitempty"`
+	Networks    map[string]json.RawMessage `json:"networks,omitempty"`
+	profile     string
 }
 
-// ReadConfig from .nucoll in home directory
-func ReadConfig() (*NucollConfig, error) {
-	var config NucollConfig
+// configFile is the layout of the config file: the default profile at the top level
+// and named profiles, one of which may replace the default one when -profile is not given
+// sealed files are encrypted with a passphrase and written back encrypted
+type configFile struct {
+	NucollConfig
+	Profiles       map[string]*NucollConfig `json:"profiles,omitempty"`
+	DefaultProfile string                   `json:"default_profile,omitempty"`
+	sealed         bool
+}
+
+// Profile returns the name of the profile the config was read from
+func (c *NucollConfig) Profile() string {
+	if c.profile == "" {
+		return DefaultProfile
+	}
+	return c.profile
+}
+
+// Describe summarizes the credentials and network sections of the config without revealing them
+func (c *NucollConfig) Describe() string {
+	var parts []string
+
+	if c.AccessToken != "" {
+		parts = append(parts, "app token")
+	}
+	if c.OAuthToken != "" {
+		parts = append(parts, "user @"+c.ScreenName)
+	}
+	if n := len(c.Credentials); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d more credentials", n))
+	}
+	if len(c.Networks) > 0 {
+		var networks []string
+		for n := range c.Networks {
+			networks = append(networks, n)
+		}
+		sort.Strings(networks)
+		parts = append(parts, "networks: "+strings.Join(networks, ", "))
+	}
+	if len(parts) == 0 {
+		return "no credentials"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Section decodes the settings of network into v which is left untouched if there are none
+func (c *NucollConfig) Section(network string, v interface{}) error {
+	if raw, ok := c.Networks[network]; ok {
+		return json.Unmarshal(raw, v)
+	}
+	return nil
+}
 
+// SetSection stores v as the settings of network
+func (c *NucollConfig) SetSection(network string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if c.Networks == nil {
+		c.Networks = make(map[string]json.RawMessage)
+	}
+	c.Networks[network] = raw
+	return nil
+}
+
+// BaseURL returns the API base URL of network taken from, in order, the command line,
+// the NUCOLL_<NETWORK>_URL environment variable, the base_url setting of its section or def
+func (c *NucollConfig) BaseURL(network string, def string) string {
+	var section struct {
+		BaseURL string `json:"base_url"`
+	}
+
+	url := BaseURLFlag
+	if url == "" {
+		url = os.Getenv("NUCOLL_" + strings.ToUpper(network) + "_URL")
+	}
+	if url == "" && c.Section(network, &section) == nil {
+		url = section.BaseURL
+	}
+	if url == "" {
+		url = def
+	}
+	return strings.TrimSuffix(url, "/")
+}
+
+// ConfigPath returns the location of the config file, .nucoll in the home directory unless -config is given
+func ConfigPath() (string, error) {
+	if ConfigFlag != "" {
+		return ConfigFlag, nil
+	}
 	configDir, err := DotNucollPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "."+filepath.Base(os.Args[0])), nil
+}
+
+// readConfigFile reads all profiles, a missing file holding an empty default profile
+// an encrypted file is decrypted with the passphrase
+func readConfigFile() (*configFile, error) {
+	var f configFile
+
+	path, err := ConfigPath()
 	if err != nil {
 		return nil, err
 	}
-	file, err := ioutil.ReadFile(filepath.Join(configDir, "."+filepath.Base(os.Args[0])))
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &f, nil
+	}
 	if err != nil {
-		return &NucollConfig{}, nil
+		return nil, err
 	}
-
-	if err := json.Unmarshal(file, &config); err != nil {
+	if isSealed(b) {
+		pass, err := Passphrase(false)
+		if err != nil {
+			return nil, err
+		}
+		if b, err = unseal(b, pass); err != nil {
+			return nil, fmt.Errorf("cannot decrypt %s: %w", path, err)
+		}
+		f.sealed = true
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
 		return nil, err
 	}
-
-	return &config, nil
+	return &f, nil
 }
 
-// WriteConfig to .nucoll file in home directory
-func WriteConfig(config *NucollConfig) error {
-	configDir, err := DotNucollPath()
+// writeConfigFile replaces the config file with f, readable by the user only since it holds credentials
+// the file is renamed into place so an interrupted write leaves the previous one intact
+func writeConfigFile(f *configFile) (string, error) {
+	path, err := ConfigPath()
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(f)
 	if err != nil {
+		return "", err
+	}
+	if f.sealed {
+		pass, err := Passphrase(true)
+		if err != nil {
+			return "", err
+		}
+		if b, err = seal(b, pass); err != nil {
+			return "", err
+		}
+	}
+	return path, WriteFileAtomic(path, b, 0600)
+}
+
+// selected returns the name of the profile used by commands
+func (f *configFile) selected() string {
+	switch {
+	case ProfileFlag != "":
+		return ProfileFlag
+	case f.DefaultProfile != "":
+		return f.DefaultProfile
+	}
+	return DefaultProfile
+}
+
+// profile returns the config of the profile called name
+func (f *configFile) profile(name string) (*NucollConfig, error) {
+	var c NucollConfig
+
+	if name == DefaultProfile {
+		c = f.NucollConfig
+	} else {
+		p, ok := f.Profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q, see the config command", name)
+		}
+		c = *p
+	}
+	c.profile = name
+	return &c, nil
+}
+
+// setProfile stores c as the profile it was read from
+func (f *configFile) setProfile(c *NucollConfig) {
+	if c.Profile() == DefaultProfile {
+		f.NucollConfig = *c
+		return
+	}
+	if f.Profiles == nil {
+		f.Profiles = make(map[string]*NucollConfig)
+	}
+	f.Profiles[c.Profile()] = c
+}
+
+// names returns the profiles of the file in order, the default one first
+func (f *configFile) names() []string {
+	var names []string
+
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{DefaultProfile}, names...)
+}
+
+// add creates the empty profile name
+func (f *configFile) add(name string) error {
+	if !profileName.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q, use letters, digits, - and _", name)
+	}
+	if _, ok := f.Profiles[name]; ok || name == DefaultProfile {
+		return fmt.Errorf("profile %q already exists", name)
+	}
+	f.setProfile(&NucollConfig{profile: name})
+	return nil
+}
+
+// remove deletes the profile name, the default profile takes over if it was used by commands
+func (f *configFile) remove(name string) error {
+	if name == DefaultProfile {
+		return errors.New("the default profile cannot be removed")
+	}
+	if _, ok := f.Profiles[name]; !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	delete(f.Profiles, name)
+	if f.DefaultProfile == name {
+		f.DefaultProfile = ""
+	}
+	return nil
+}
+
+// setDefault makes commands use the profile name when -profile is not given
+func (f *configFile) setDefault(name string) error {
+	if _, err := f.profile(name); err != nil {
 		return err
 	}
-	configJSON, err := json.Marshal(config)
+	f.DefaultProfile = name
+	if name == DefaultProfile {
+		f.DefaultProfile = ""
+	}
+	return nil
+}
+
+// ReadConfig from .nucoll in home directory or the file given by -config
+// settings are those of the profile given by -profile, else the default profile of the file
+func ReadConfig() (*NucollConfig, error) {
+	f, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	return f.profile(f.selected())
+}
+
+// WriteConfig to .nucoll file in home directory or the file given by -config, leaving the other profiles untouched
+func WriteConfig(config *NucollConfig) error {
+	f, err := readConfigFile()
 	if err != nil {
 		return err
 	}
-	err = ioutil.WriteFile(filepath.Join(configDir, "."+filepath.Base(os.Args[0])), configJSON, 0600)
+	f.setProfile(config)
+	path, err := writeConfigFile(f)
 	if err != nil {
 		return err
 	}
-	log.Printf("credentials stored in %s\n", filepath.Join(configDir, "."+filepath.Base(os.Args[0])))
+	log.Printf("credentials stored in %s\n", path)
 
 	return nil
 }
+
+// Profiles returns the names of the profiles of the config file, the default one first,
+// along with the profile used by commands
+func Profiles() ([]string, string, error) {
+	f, err := readConfigFile()
+	if err != nil {
+		return nil, "", err
+	}
+	return f.names(), f.selected(), nil
+}
+
+// ReadProfile returns the settings of the profile called name
+func ReadProfile(name string) (*NucollConfig, error) {
+	f, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	return f.profile(name)
+}
+
+// AddProfile creates the empty profile name whose credentials are set up on first usage
+func AddProfile(name string) error {
+	return updateConfigFile(func(f *configFile) error { return f.add(name) })
+}
+
+// RemoveProfile deletes the profile name along with its credentials
+func RemoveProfile(name string) error {
+	return updateConfigFile(func(f *configFile) error { return f.remove(name) })
+}
+
+// SetDefaultProfile makes commands use the profile name when -profile is not given
+func SetDefaultProfile(name string) error {
+	return updateConfigFile(func(f *configFile) error { return f.setDefault(name) })
+}
+
+// EncryptConfig encrypts the config file with a passphrase, which every later run needs to read it
+func EncryptConfig() (string, error) {
+	f, err := readConfigFile()
+	if err != nil {
+		return "", err
+	}
+	if f.sealed {
+		return "", errors.New("the config file is encrypted already")
+	}
+	f.sealed = true
+	return writeConfigFile(f)
+}
+
+// DecryptConfig stores the config file as plain JSON again
+func DecryptConfig() (string, error) {
+	f, err := readConfigFile()
+	if err != nil {
+		return "", err
+	}
+	if !f.sealed {
+		return "", errors.New("the config file is not encrypted")
+	}
+	f.sealed = false
+	return writeConfigFile(f)
+}
+
+// updateConfigFile applies change to the config file
+func updateConfigFile(change func(*configFile) error) error {
+	f, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+	if err := change(f); err != nil {
+		return err
+	}
+	_, err = writeConfigFile(f)
+	return err
+}
@@ -0,0 +1,204 @@
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSection(t *testing.T) {
+	type section struct {
+		Instance string `json:"instance"`
+	}
+
+	var config NucollConfig
+	if err := json.Unmarshal([]byte(`{"token_type":"bearer","access_token":"x","networks":{"mastodon":{"instance":"a.example"}}}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	var actual section
+	if err := config.Section("mastodon", &actual); err != nil || actual.Instance != "a.example" {
+		t.Fatalf("Section(mastodon): expected a.example, actual %q (%v)", actual.Instance, err)
+	}
+	if err := config.Section("bluesky", &actual); err != nil || actual.Instance != "a.example" {
+		t.Fatalf("Section(bluesky): expected untouched value, actual %q (%v)", actual.Instance, err)
+	}
+	if err := config.SetSection("bluesky", section{"b.example"}); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(&config)
+	expected := `{"token_type":"bearer","access_token":"x","networks":{"bluesky":{"instance":"b.example"},"mastodon":{"instance":"a.example"}}}`
+	if string(b) != expected {
+		t.Fatalf("SetSection: expected %s, actual %s", expected, b)
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	var config NucollConfig
+	if err := json.Unmarshal([]byte(`{"networks":{"twitter":{"base_url":"http://config.example/"}}}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if actual := config.BaseURL("github", "https://api.github.com"); actual != "https://api.github.com" {
+		t.Errorf("default: actual %q", actual)
+	}
+	if actual := config.BaseURL("twitter", "https://api.twitter.com"); actual != "http://config.example" {
+		t.Errorf("config: actual %q", actual)
+	}
+	os.Setenv("NUCOLL_TWITTER_URL", "http://env.example")
+	defer os.Unsetenv("NUCOLL_TWITTER_URL")
+	if actual := config.BaseURL("twitter", "https://api.twitter.com"); actual != "http://env.example" {
+		t.Errorf("environment: actual %q", actual)
+	}
+	BaseURLFlag = "http://flag.example"
+	defer func() { BaseURLFlag = "" }()
+	if actual := config.BaseURL("twitter", "https://api.twitter.com"); actual != "http://flag.example" {
+		t.Errorf("flag: actual %q", actual)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	defer func() { ProfileFlag = "" }()
+	var f configFile
+	if err := json.Unmarshal([]byte(`{"token_type":"bearer","access_token":"x"}`), &f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.add("lab"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"lab", DefaultProfile, "a b"} {
+		if err := f.add(name); err == nil {
+			t.Errorf("add(%q): expected error", name)
+		}
+	}
+	c, err := f.profile(f.selected())
+	if err != nil || c.AccessToken != "x" || c.Profile() != DefaultProfile {
+		t.Fatalf("expected default profile, actual %+v (%v)", c, err)
+	}
+
+	// writing a profile leaves the others untouched
+	ProfileFlag = "lab"
+	c, err = f.profile(f.selected())
+	if err != nil || c.AccessToken != "" {
+		t.Fatalf("expected empty lab profile, actual %+v (%v)", c, err)
+	}
+	c.TokenType, c.AccessToken = "bearer", "y"
+	f.setProfile(c)
+	b, _ := json.Marshal(&f)
+	expected := `{"token_type":"bearer","access_token":"x","profiles":{"lab":{"token_type":"bearer","access_token":"y"}}}`
+	if string(b) != expected {
+		t.Fatalf("setProfile: expected %s, actual %s", expected, b)
+	}
+
+	ProfileFlag = ""
+	if err := f.setDefault("lab"); err != nil || f.selected() != "lab" {
+		t.Fatalf("setDefault: selected %q (%v)", f.selected(), err)
+	}
+	if err := f.setDefault("nope"); err == nil {
+		t.Error("setDefault: expected unknown profile to fail")
+	}
+	if names := f.names(); len(names) != 2 || names[0] != DefaultProfile || names[1] != "lab" {
+		t.Errorf("names: unexpected %v", names)
+	}
+	if err := f.remove(DefaultProfile); err == nil {
+		t.Error("remove: expected default profile to stay")
+	}
+	if err := f.remove("lab"); err != nil || f.selected() != DefaultProfile {
+		t.Errorf("remove: selected %q (%v)", f.selected(), err)
+	}
+	if _, err := f.profile("lab"); err == nil {
+		t.Error("profile: expected removed profile to be unknown")
+	}
+}
+
+func TestConfigFlag(t *testing.T) {
+	defer func() { ConfigFlag = "" }()
+	dir, err := ioutil.TempDir("", "nucoll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ConfigFlag = filepath.Join(dir, "lab.json")
+	config, err := ReadConfig()
+	if err != nil || config.AccessToken != "" {
+		t.Fatalf("expected empty config for missing file, actual %+v (%v)", config, err)
+	}
+	config.TokenType, config.AccessToken = "bearer", "x"
+	if err := WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if config, err = ReadConfig(); err != nil || config.AccessToken != "x" {
+		t.Errorf("expected token read back from %s, actual %+v (%v)", ConfigFlag, config, err)
+	}
+
+	// an unreadable file must not pass for a missing one which setup would then overwrite
+	ConfigFlag = dir
+	if config, err = ReadConfig(); err == nil {
+		t.Errorf("expected an error reading the directory %s, actual %+v", dir, config)
+	}
+}
+
+func TestEncryptConfig(t *testing.T) {
+	defer func() { ConfigFlag, passphrase = "", "" }()
+	dir, err := ioutil.TempDir("", "nucoll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("NUCOLL_PASSPHRASE", "correct horse")
+	defer os.Unsetenv("NUCOLL_PASSPHRASE")
+
+	ConfigFlag = filepath.Join(dir, "nucoll.json")
+	if err := WriteConfig(&NucollConfig{TwitterConfig: TwitterConfig{TokenType: "bearer", AccessToken: "secret-token"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EncryptConfig(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(ConfigFlag)
+	if err != nil || strings.Contains(string(b), "secret-token") || !isSealed(b) {
+		t.Fatalf("expected encrypted file, actual %q (%v)", b, err)
+	}
+	if err := AddProfile("lab"); err != nil {
+		t.Fatal(err)
+	}
+	if config, err := ReadConfig(); err != nil || config.AccessToken != "secret-token" {
+		t.Errorf("expected token read back, actual %+v (%v)", config, err)
+	}
+	if b, _ = ioutil.ReadFile(ConfigFlag); !isSealed(b) {
+		t.Error("expected file to stay encrypted once updated")
+	}
+
+	passphrase = "wrong"
+	if _, err := ReadConfig(); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Errorf("expected wrong passphrase, actual %v", err)
+	}
+	passphrase = ""
+	if _, err := DecryptConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ = ioutil.ReadFile(ConfigFlag); !strings.Contains(string(b), "secret-token") || !strings.Contains(string(b), "lab") {
+		t.Errorf("expected plain file, actual %q", b)
+	}
+}
+
+func TestUnsealLimits(t *testing.T) {
+	var s sealedConfig
+
+	b, err := seal([]byte(`{}`), "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ n, r, p int }{{1 << 40, 8, 1}, {1 << 15, 1 << 20, 1}, {1 << 15, 8, 1 << 20}, {1 << 15, 0, 1}} {
+		s.N, s.R, s.P = c.n, c.r, c.p
+		damaged, _ := json.Marshal(s)
+		if _, err := unseal(damaged, "pass"); err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("n=%d r=%d p=%d: expected parameters to be rejected, actual %v", c.n, c.r, c.p, err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// ConfigCipher names the key derivation and encryption of sealed config files
+const ConfigCipher = "scrypt+aes-256-gcm"
+
+// scrypt cost of new config files, around 32 MB and a fraction of a second per run
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// ceilings of the scrypt cost read from a file, which would otherwise let a damaged file
+// claim more memory than any machine has, N = 2^20 and r*p = 64 needing 8 GB at most
+const (
+	maxScryptN  = 1 << 20
+	maxScryptRP = 64
+)
+
+// sealedConfig is the layout of an encrypted config file, the parameters travel with the ciphertext
+// so the cost can be raised without breaking existing files
+type sealedConfig struct {
+	Encrypted  string `json:"encrypted"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+var (
+	passphrase   string
+	passphraseMu sync.Mutex
+)
+
+var errNoPassphrase = errors.New("the config file is encrypted and stdin is not a terminal: set NUCOLL_PASSPHRASE")
+
+// isSealed reports whether b is the content of an encrypted config file
+func isSealed(b []byte) bool {
+	var s sealedConfig
+	return json.Unmarshal(b, &s) == nil && s.Encrypted != ""
+}
+
+// seal encrypts plain with a key derived from pass and a random salt
+func seal(plain []byte, pass string) ([]byte, error) {
+	s := sealedConfig{Encrypted: ConfigCipher, N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, 16)}
+	if _, err := rand.Read(s.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := s.aead(pass)
+	if err != nil {
+		return nil, err
+	}
+	s.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(s.Nonce); err != nil {
+		return nil, err
+	}
+	s.Ciphertext = aead.Seal(nil, s.Nonce, plain, []byte(s.Encrypted))
+	return json.Marshal(s)
+}
+
+// unseal decrypts the encrypted config file b with pass
+func unseal(b []byte, pass string) ([]byte, error) {
+	var s sealedConfig
+
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	if s.Encrypted != ConfigCipher {
+		return nil, fmt.Errorf("unsupported config encryption %q", s.Encrypted)
+	}
+	if s.N > maxScryptN || s.R <= 0 || s.P <= 0 || s.R > maxScryptRP || s.R*s.P > maxScryptRP {
+		return nil, fmt.Errorf("scrypt parameters n=%d r=%d p=%d out of range", s.N, s.R, s.P)
+	}
+	aead, err := s.aead(pass)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	plain, err := aead.Open(nil, s.Nonce, s.Ciphertext, []byte(s.Encrypted))
+	if err != nil {
+		return nil, errors.New("wrong passphrase or damaged file")
+	}
+	return plain, nil
+}
+
+// aead returns AES-256-GCM keyed by scrypt of pass with the parameters of s
+func (s *sealedConfig) aead(pass string) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(pass), s.Salt, s.N, s.R, s.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Passphrase returns the passphrase of the config file taken from NUCOLL_PASSPHRASE,
+// else asked for once per run when stdin is a terminal
+// confirm asks twice, which is done when a passphrase is chosen
+func Passphrase(confirm bool) (string, error) {
+	passphraseMu.Lock()
+	defer passphraseMu.Unlock()
+	if passphrase != "" {
+		return passphrase, nil
+	}
+	if p := os.Getenv("NUCOLL_PASSPHRASE"); p != "" {
+		passphrase = p
+		return p, nil
+	}
+	if !Interactive() {
+		return "", errNoPassphrase
+	}
+	p, err := ReadSecret("What is the passphrase of the config file? ")
+	if err != nil {
+		return "", err
+	}
+	if p == "" {
+		return "", errors.New("empty passphrase")
+	}
+	if confirm {
+		again, err := ReadSecret("Repeat the passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != p {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	passphrase = p
+	return p, nil
+}
+
+// ReadSecret asks for a line on the terminal without showing what is typed
+func ReadSecret(prompt string) (string, error) {
+	fmt.Print(prompt)
+	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("cannot read from the terminal: %w", err)
+	}
+	return string(b), nil
+}
@@ -1,13 +1,39 @@
 package util
 
 import (
+	"net/http"
 	"os"
 	"os/user"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
 )
 
+var (
+	atExit   []func()
+	atExitMu sync.Mutex
+)
+
+// AtExit registers f to run when the command completes or fails, e.g. to save state kept in memory
+func AtExit(f func()) {
+	atExitMu.Lock()
+	defer atExitMu.Unlock()
+	atExit = append(atExit, f)
+}
+
+// RunAtExit runs the functions registered with AtExit, most recent first
+func RunAtExit() {
+	atExitMu.Lock()
+	fs := atExit
+	atExit = nil
+	atExitMu.Unlock()
+	for i := len(fs) - 1; i >= 0; i-- {
+		fs[i]()
+	}
+}
+
 // DigitsOnly checks if sitrng s is a number
 func DigitsOnly(s string) bool {
 	re, _ := regexp.Compile("^\\d+$")
@@ -32,6 +58,14 @@ func DaysSince(d string) time.Duration {
 	return 0
 }
 
+// RubyDate converts ISO 8601 timestamps to the format used in twitter .dat files
+func RubyDate(s string) string {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Format(time.RubyDate)
+	}
+	return s
+}
+
 // DotNucollPath returns the location of the configuration file
 // with CGO_ENABLED=0 golang throws user: Current not implemented on linux/amd64
 // so we look for alternatives including $HOME and current directory
@@ -48,3 +82,35 @@ func DotNucollPath() (string, error) {
 	}
 	return filepath.Dir(ex), nil
 }
+
+// Interactive reports whether stdin is a terminal in which credentials can be typed
+// the null device, which containers and schedulers often attach, is a character device too
+func Interactive() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(fi, null)
+}
+
+// NextLink returns the URL tagged rel="next" in a Link header or an empty string
+// see https://www.rfc-editor.org/rfc/rfc8288
+func NextLink(h http.Header) string {
+	for _, v := range h.Values("Link") {
+		for _, link := range strings.Split(v, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, p := range parts[1:] {
+				p = strings.TrimSpace(p)
+				if p == `rel="next"` || p == "rel=next" {
+					return strings.Trim(target, "<>")
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -1,6 +1,9 @@
 package util
 
-import "testing"
+import (
+	"net/http"
+	"testing"
+)
 
 func TestExists(t *testing.T) {
 	var tests = []struct {
@@ -41,3 +44,48 @@ func TestDigitsOnly(t *testing.T) {
 		}
 	}
 }
+
+func TestNextLink(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{`<https://a.example/api/v1/accounts/1/following?max_id=7>; rel="next", <https://a.example/api/v1/accounts/1/following?since_id=9>; rel="prev"`, "https://a.example/api/v1/accounts/1/following?max_id=7"},
+		{`<https://api.github.com/user/1/following?page=1>; rel="prev", <https://api.github.com/user/1/following?page=3>; rel="next"`, "https://api.github.com/user/1/following?page=3"},
+		{`<https://a.example/api/v1/accounts/1/following?since_id=9>; rel="prev"`, ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		h := http.Header{}
+		if test.input != "" {
+			h.Set("Link", test.input)
+		}
+		actual := NextLink(h)
+		if actual != test.expected {
+			t.Fatalf("NextLink(%s): expected %s, actual %s", test.input, test.expected, actual)
+		} else {
+			t.Logf("NextLink(%s): %s", test.input, test.expected)
+		}
+	}
+}
+
+func TestRubyDate(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{"2021-01-02T15:04:05Z", "Sat Jan 02 15:04:05 +0000 2021"},
+		{"2022-11-01T00:00:00.000Z", "Tue Nov 01 00:00:00 +0000 2022"},
+		{"yesterday", "yesterday"},
+	}
+
+	for _, test := range tests {
+		actual := RubyDate(test.input)
+		if actual != test.expected {
+			t.Fatalf("RubyDate(%s): expected %s, actual %s", test.input, test.expected, actual)
+		} else {
+			t.Logf("RubyDate(%s): %s", test.input, test.expected)
+		}
+	}
+}
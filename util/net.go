@@ -0,0 +1,34 @@
+package util
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// ConnectTimeout bounds establishing a connection including the TLS handshake
+// ReadTimeout bounds waiting for the response to a request once it is sent
+var (
+	ConnectTimeout = 30 * time.Second
+	ReadTimeout    = 2 * time.Minute
+)
+
+// StatusError reports a response other than 200 OK, callers tell missing accounts from other failures by StatusCode
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return e.Status
+}
+
+// HTTPTransport returns a transport to the network enforcing ConnectTimeout and ReadTimeout
+// there is no overall deadline since paced requests may wait for a rate limit window to reset
+func HTTPTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{Timeout: ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	t.TLSHandshakeTimeout = ConnectTimeout
+	t.ResponseHeaderTimeout = ReadTimeout
+	return t
+}
@@ -2,9 +2,11 @@ package util
 
 import (
 	"bufio"
+	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -29,17 +31,69 @@ const (
 	GmlExt string = ".gml"
 )
 
+// Workspace is the directory holding the files of collections, the current directory when empty
+var Workspace string
+
+// SharedFdatDir is a friends file directory shared by the collections of several workspaces
+// it replaces FdatDir of the workspace when set since friends files only depend on the account within a network
+var SharedFdatDir string
+
+// Network is the backend collections are made with, friends files of each network are kept apart
+// in SharedFdatDir since several number their accounts alike
+var Network string
+
+// WorkspacePath returns the path to name in the workspace
+func WorkspacePath(name string) string {
+	if Workspace == "" {
+		return name
+	}
+	return filepath.Join(Workspace, name)
+}
+
+// fdatDir returns the directory of friends files, the subdirectory of SharedFdatDir named after Network when shared
+func fdatDir() string {
+	if SharedFdatDir != "" {
+		return filepath.Join(SharedFdatDir, Network)
+	}
+	return WorkspacePath(FdatDir)
+}
+
+// WriteFileAtomic writes b to a temporary file renamed to path once complete
+// so an interrupted run never leaves a truncated file behind
+func WriteFileAtomic(path string, b []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
 // QueryReader extracts twitter handles from query file
 func QueryReader(handle string, firstHandleOnly bool) ([]string, error) {
+	return HandleReader(handle, firstHandleOnly, regexp.MustCompile("@([\\w]+)"))
+}
+
+// HandleReader extracts handles matched by the first group of re from query file
+func HandleReader(handle string, firstHandleOnly bool, re *regexp.Regexp) ([]string, error) {
 	var handles []string
 
-	twtFile, err := os.Open(handle + QueryExt)
+	twtFile, err := os.Open(WorkspacePath(handle + QueryExt))
 	if err != nil {
 		return nil, err
 	}
 	defer twtFile.Close()
 
-	re := regexp.MustCompile("@([\\w]+)")
 	scanner := bufio.NewScanner(twtFile)
 	for scanner.Scan() {
 		if firstHandleOnly {
@@ -65,7 +119,7 @@ func QueryReader(handle string, firstHandleOnly bool) ([]string, error) {
 // CSVReader dynamic data loader
 // Expect first row to contain struct field names preceded by comment hash '#'
 func CSVReader(handle string, ext string, data interface{}) error {
-	csvFile, err := os.Open(handle + ext)
+	csvFile, err := os.Open(WorkspacePath(handle + ext))
 	if err != nil {
 		return err
 	}
@@ -141,7 +195,7 @@ func CSVWriter(handle string, ext string, appendFlag bool, data interface{}) (st
 	} else {
 		perm = os.O_CREATE | os.O_TRUNC | perm
 	}
-	filename := handle + ext
+	filename := WorkspacePath(handle + ext)
 	csvFile, err := os.OpenFile(filename, perm, 0644)
 	if err != nil {
 		return "", err
@@ -195,57 +249,75 @@ func CSVWriter(handle string, ext string, appendFlag bool, data interface{}) (st
 		return "", err
 	}
 
-	return filename, nil
+	return filename, csvFile.Close()
+}
+
+// fdatFilename returns the path to the friends file of handle
+// colons found in identifiers such as DIDs are not allowed in Windows file names
+func fdatFilename(handle string) string {
+	return filepath.Join(fdatDir(), strings.Replace(handle, ":", "_", -1)+FdatExt)
 }
 
 // FdatExists if friends file found
 func FdatExists(handle string) bool {
-	if _, err := os.Stat(FdatDir + "/" + handle + FdatExt); os.IsNotExist(err) {
+	if _, err := os.Stat(fdatFilename(handle)); os.IsNotExist(err) {
 		return false
 	}
 	return true
 }
 
 // FdatWriter spills list of friends to disk
+// the list is written to a temporary file renamed once complete so concurrent calls
+// and interrupted runs never leave a partial file behind to be mistaken for a fetched handle
 func FdatWriter(handle string, ids []string) (string, error) {
-	if _, err := os.Stat(FdatDir); os.IsNotExist(err) {
-		if err := os.MkdirAll(FdatDir, 0755); err != nil {
-			return "", err
-		}
+	if err := os.MkdirAll(fdatDir(), 0755); err != nil {
+		return "", err
 	}
 
-	const perm = os.O_CREATE | os.O_TRUNC | os.O_WRONLY
-	filename := FdatDir + "/" + handle + FdatExt
-	fdatFile, err := os.OpenFile(filename, perm, 0644)
+	filename := fdatFilename(handle)
+	fdatFile, err := ioutil.TempFile(fdatDir(), ".fdat")
 	if err != nil {
 		return "", err
 	}
-	defer fdatFile.Close()
+	defer os.Remove(fdatFile.Name())
+	w := bufio.NewWriter(fdatFile)
 	for _, id := range ids {
-		fdatFile.WriteString(id + "\n")
+		w.WriteString(id + "\n")
 	}
-	return filename, nil
+	if err = w.Flush(); err != nil {
+		fdatFile.Close()
+		return "", err
+	}
+	if err = fdatFile.Close(); err != nil {
+		return "", err
+	}
+	if err = os.Chmod(fdatFile.Name(), 0644); err != nil {
+		return "", err
+	}
+	return filename, os.Rename(fdatFile.Name(), filename)
 }
 
 // DownloadImage save avatar for user id
-func DownloadImage(id uint64, url string) (string, error) {
-	if _, err := os.Stat(ImgDir); os.IsNotExist(err) {
-		if err := os.MkdirAll(ImgDir, 0755); err != nil {
-			return "", err
-		}
+// it goes through Transport so timeouts, cancellation and -record or -replay apply as to API requests
+func DownloadImage(ctx context.Context, id string, url string) (string, error) {
+	imgDir := WorkspacePath(ImgDir)
+	if err := os.MkdirAll(imgDir, 0755); err != nil {
+		return "", err
 	}
 
-	res, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := (&http.Client{Transport: Transport()}).Do(req)
 	if err != nil {
 		return "", err
 	}
 	defer res.Body.Close()
-	if _, err := os.Stat(ImgDir); os.IsNotExist(err) {
-		if err := os.Mkdir(ImgDir, 0777); err != nil {
-			return "", err
-		}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: %s", url, res.Status)
 	}
-	filename := filepath.Join(ImgDir, fmt.Sprintf("%d", id))
+	filename := filepath.Join(imgDir, strings.Replace(id, ":", "_", -1))
 	switch res.Header.Get("Content-Type") {
 	case "image/gif":
 		filename += ".gif"
@@ -257,15 +329,15 @@ func DownloadImage(id uint64, url string) (string, error) {
 		filename += ".png"
 	}
 	image, err := os.Create(filename)
-	defer image.Close()
 	if err != nil {
 		return "", err
 	}
 	if _, err := io.Copy(image, res.Body); err != nil {
+		image.Close()
 		return "", err
 	}
 
-	return filename, nil
+	return filename, image.Close()
 }
 
 // GMLWriter generates GML file for given array of handles using cols as node properties and sets label to given attribute
@@ -278,13 +350,22 @@ func GMLWriter(handles []string, data interface{}, includeMissingIDs bool, cols
 		return "", nil
 	}
 
-	filename := strings.Join(handles, "_") + GmlExt
+	filename := WorkspacePath(strings.Join(handles, "_") + GmlExt)
 	gmlFile, err := os.OpenFile(filename, perm, 0644)
 	if err != nil {
 		return "", err
 	}
 	defer gmlFile.Close()
 
+	// identifiers which are not numeric such as DIDs are mapped to sequential node ids
+	var nodeMap map[string]string
+	for i := 0; i < items.Len(); i++ {
+		id := fmt.Sprintf("%v", reflect.Indirect(items.Index(i)).FieldByName("ID").Interface())
+		if !DigitsOnly(id) {
+			nodeMap = make(map[string]string)
+			break
+		}
+	}
 	friendMap := make(map[string]string)
 	handleMap := make(map[string]string)
 	gmlFile.WriteString("graph [\n  directed 1\n")
@@ -293,11 +374,18 @@ func GMLWriter(handles []string, data interface{}, includeMissingIDs bool, cols
 		t := reflect.Indirect(item)
 		subject := fmt.Sprintf("%v", t.FieldByName("Subject").Interface())
 		handle := fmt.Sprintf("%v", t.FieldByName("ScreenName").Interface())
-		processed := FdatExists(fmt.Sprintf("%v", t.FieldByName("ID").Interface()))
+		id := fmt.Sprintf("%v", t.FieldByName("ID").Interface())
+		processed := FdatExists(id)
 		if !includeMissingIDs && !processed && subject != "" {
 			continue
 		}
 		gmlFile.WriteString("  node [\n")
+		if nodeMap != nil {
+			if _, ok := nodeMap[id]; !ok {
+				nodeMap[id] = strconv.Itoa(len(nodeMap) + 1)
+			}
+			gmlFile.WriteString(fmt.Sprintf("    id %s\n", nodeMap[id]))
+		}
 		for _, c := range cols {
 			v := fmt.Sprintf("%v", t.FieldByName(c).Interface())
 			switch {
@@ -324,12 +412,12 @@ func GMLWriter(handles []string, data interface{}, includeMissingIDs bool, cols
 		case subject == "":
 			for to, handle := range friendMap {
 				if handle == handleMap[from] {
-					gmlFile.WriteString(fmt.Sprintf("  edge [\n    source %s\n    target %s\n  ]\n", from, to))
+					gmlFile.WriteString(fmt.Sprintf("  edge [\n    source %s\n    target %s\n  ]\n", node(nodeMap, from), node(nodeMap, to)))
 				}
 			}
 			continue
 		default:
-			fdatFile, err := os.Open(FdatDir + "/" + from + FdatExt)
+			fdatFile, err := os.Open(fdatFilename(from))
 			if err != nil {
 				continue
 			}
@@ -338,7 +426,7 @@ func GMLWriter(handles []string, data interface{}, includeMissingIDs bool, cols
 			for scanner.Scan() {
 				to := scanner.Text()
 				if friendMap[to] != "" || handleMap[to] != "" {
-					gmlFile.WriteString(fmt.Sprintf("  edge [\n    source %s\n    target %s\n  ]\n", from, to))
+					gmlFile.WriteString(fmt.Sprintf("  edge [\n    source %s\n    target %s\n  ]\n", node(nodeMap, from), node(nodeMap, to)))
 				}
 			}
 			if err := scanner.Err(); err != nil {
@@ -350,3 +438,11 @@ func GMLWriter(handles []string, data interface{}, includeMissingIDs bool, cols
 
 	return filename, nil
 }
+
+// node returns the GML node id of identifier id
+func node(nodeMap map[string]string, id string) string {
+	if nodeMap == nil {
+		return id
+	}
+	return nodeMap[id]
+}
@@ -1,8 +1,20 @@
 package util
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestQueryReader(t *testing.T) {
@@ -69,3 +81,152 @@ func TestCSVReader(t *testing.T) {
 		}
 	}
 }
+
+func TestGMLWriter(t *testing.T) {
+	type record struct {
+		ID         string
+		ScreenName string
+		Subject    string
+	}
+
+	Workspace = t.TempDir()
+	defer func() { Workspace = "" }()
+
+	if _, err := FdatWriter("did:plc:a", []string{"did:plc:b", "did:plc:z"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FdatWriter("did:plc:b", []string{}); err != nil {
+		t.Fatal(err)
+	}
+	data := []record{
+		{"did:plc:a", "alice.test", "ego.test"},
+		{"did:plc:b", "bob.test", "ego.test"},
+	}
+	filename, err := GMLWriter([]string{"ego.test"}, data, false, []string{"ID", "ScreenName"}, "ScreenName")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(filename)
+	for _, expected := range []string{
+		"    id 1\n    ID \"did:plc:a\"\n    Label \"alice.test\"\n",
+		"    id 2\n    ID \"did:plc:b\"\n    Label \"bob.test\"\n",
+		"  edge [\n    source 1\n    target 2\n  ]\n",
+	} {
+		if !strings.Contains(string(b), expected) {
+			t.Fatalf("GMLWriter: expected %q in %s", expected, b)
+		}
+	}
+	if strings.Count(string(b), "edge [") != 1 {
+		t.Fatalf("GMLWriter: expected one edge in %s", b)
+	}
+}
+
+func TestFdatWriterConcurrent(t *testing.T) {
+	Workspace = t.TempDir()
+	defer func() { Workspace = "" }()
+
+	ids := []string{"1", "2", "3"}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := FdatWriter(fmt.Sprint(i%4), ids); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	files, _ := ioutil.ReadDir(WorkspacePath(FdatDir))
+	if len(files) != 4 {
+		t.Fatalf("expected 4 files, actual %d", len(files))
+	}
+	for _, f := range files {
+		b, _ := ioutil.ReadFile(WorkspacePath(filepath.Join(FdatDir, f.Name())))
+		if string(b) != "1\n2\n3\n" {
+			t.Errorf("%s: unexpected %q", f.Name(), b)
+		}
+	}
+}
+
+func TestWorkspace(t *testing.T) {
+	type record struct {
+		ID         string
+		ScreenName string
+		Subject    string
+	}
+
+	dir, err := ioutil.TempDir("", "nucoll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	defer func() { Workspace, SharedFdatDir, Network = "", "", "" }()
+
+	// two collections share the friends files of bob
+	SharedFdatDir, Network = "shared", "twitter"
+	for _, ws := range []string{"one", "two"} {
+		Workspace = ws
+		os.Mkdir(ws, 0755)
+		if _, err := CSVWriter("ego", DatExt, false, []record{{"2", "bob", "ego"}}); err != nil {
+			t.Fatal(err)
+		}
+		var data []record
+		if err := CSVReader("ego", DatExt, &data); err != nil || len(data) != 1 {
+			t.Fatalf("%s: expected ego%s read back, actual %v (%v)", ws, DatExt, data, err)
+		}
+	}
+	if _, err := FdatWriter("2", []string{"1"}); err != nil {
+		t.Fatal(err)
+	}
+	Workspace = "one"
+	if !FdatExists("2") {
+		t.Error("expected friends file shared by workspaces")
+	}
+	// but not with accounts of another network numbered alike
+	Network = "github"
+	if FdatExists("2") {
+		t.Error("friends file shared across networks")
+	}
+	for _, name := range []string{filepath.Join("one", "ego"+DatExt), filepath.Join("two", "ego"+DatExt), filepath.Join("shared", "twitter", "2"+FdatExt)} {
+		if _, err := os.Stat(name); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(FdatDir); !os.IsNotExist(err) {
+		t.Errorf("expected no %s directory outside workspaces, actual %v", FdatDir, err)
+	}
+}
+
+func TestDownloadImage(t *testing.T) {
+	png := []byte{0x89, 'P', 'N', 'G', 0xff, 0x00}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stalled.png" {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	}))
+	defer srv.Close()
+	Workspace = t.TempDir()
+	defer func() { Workspace = "" }()
+
+	filename, err := DownloadImage(context.Background(), "did:plc:a", srv.URL+"/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filename); err != nil || !bytes.Equal(b, png) || filepath.Base(filename) != "did_plc_a.png" {
+		t.Errorf("unexpected %s %q (%v)", filename, b, err)
+	}
+
+	// a stalled download stops once the run is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := DownloadImage(ctx, "b", srv.URL+"/stalled.png"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected cancellation, actual %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// RecordDir and ReplayDir are given on the command line to record HTTP exchanges
+// to a directory or to serve them back from it instead of calling the network
+var RecordDir, ReplayDir string
+
+// Redacted replaces credentials in recorded exchanges
+const Redacted = "REDACTED"
+
+// secretFields matches JSON members of responses which carry credentials
+var secretFields = regexp.MustCompile(`"(access_token|accessJwt|refreshJwt|token)"(\s*):(\s*)"[^"]*"`)
+
+// secretParams matches form-encoded credentials such as OAuth tokens
+var secretParams = regexp.MustCompile(`\b(oauth_token|oauth_token_secret)=[^&]*`)
+
+// unsafePath matches characters replaced when naming exchanges after their path
+var unsafePath = regexp.MustCompile(`[^A-Za-z0-9.]+`)
+
+// secretHeaders are redacted before exchanges are written
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Exchange is a request and its response as stored in a record directory
+// request bodies are left out since they may hold passwords
+// binary response bodies such as images are kept in RawBody instead of Body
+type Exchange struct {
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	RequestHeader  http.Header `json:"request_header"`
+	StatusCode     int         `json:"status_code"`
+	Status         string      `json:"status"`
+	ResponseHeader http.Header `json:"response_header"`
+	Body           string      `json:"body"`
+	RawBody        []byte      `json:"raw_body,omitempty"`
+}
+
+// tape names exchanges after their request, numbering repeated ones in order
+// so a replay serves identical requests the responses they got when recorded
+type tape struct {
+	Dir    string
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// next returns the file of the next exchange for req
+// requests are keyed on method, path and query so the base URL may differ between runs
+func (tp *tape) next(req *http.Request) string {
+	key := req.Method + " " + req.URL.RequestURI()
+	tp.mu.Lock()
+	tp.counts[key]++
+	n := tp.counts[key]
+	tp.mu.Unlock()
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	slug := strings.Trim(unsafePath.ReplaceAllString(req.URL.Path, "_"), "_")
+	return filepath.Join(tp.Dir, fmt.Sprintf("%s_%s_%08x_%d.json", req.Method, slug, h.Sum32(), n))
+}
+
+// Recorder is a RoundTripper writing each exchange with credentials redacted
+type Recorder struct {
+	tape
+	Transport http.RoundTripper
+}
+
+// NewRecorder returns a Recorder writing to dir the exchanges made through transport
+func NewRecorder(dir string, transport http.RoundTripper) *Recorder {
+	return &Recorder{tape{Dir: dir, counts: make(map[string]int)}, transport}
+}
+
+// RoundTrip forwards req and records the exchange
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := r.Transport.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	e := Exchange{
+		Method:         req.Method,
+		URL:            req.URL.String(),
+		RequestHeader:  redact(req.Header),
+		StatusCode:     res.StatusCode,
+		Status:         res.Status,
+		ResponseHeader: redact(res.Header),
+	}
+	if utf8.Valid(body) {
+		e.Body = secretParams.ReplaceAllString(secretFields.ReplaceAllString(string(body), `"$1"$2:$3"`+Redacted+`"`), "$1="+Redacted)
+	} else {
+		e.RawBody = body
+	}
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(r.next(req), b, 0644); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// redact returns a copy of h without credentials
+func redact(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range secretHeaders {
+		if v := h.Get(k); v != "" {
+			scheme := strings.SplitN(v, " ", 2)[0]
+			if scheme == v || k == "Cookie" || k == "Set-Cookie" {
+				h.Set(k, Redacted)
+			} else {
+				h.Set(k, scheme+" "+Redacted)
+			}
+		}
+	}
+	return h
+}
+
+// Replayer is a RoundTripper serving exchanges written by a Recorder
+type Replayer struct {
+	tape
+}
+
+// NewReplayer returns a Replayer serving the exchanges recorded in dir
+func NewReplayer(dir string) *Replayer {
+	return &Replayer{tape{Dir: dir, counts: make(map[string]int)}}
+}
+
+// RoundTrip returns the recorded response to req
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var e Exchange
+
+	filename := r.next(req)
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recorded response to %s %s in %s", req.Method, req.URL.RequestURI(), r.Dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("invalid exchange %s: %w", filename, err)
+	}
+	body := []byte(e.Body)
+	if e.RawBody != nil {
+		body = e.RawBody
+	}
+	return &http.Response{
+		Status:        e.Status,
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.ResponseHeader,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+var (
+	transport     http.RoundTripper
+	transportOnce sync.Once
+)
+
+// Transport returns the RoundTripper backends send requests through: a Replayer when ReplayDir is set,
+// a Recorder when RecordDir is set or a transport with timeouts, shared so exchanges are numbered once per run
+func Transport() http.RoundTripper {
+	transportOnce.Do(func() {
+		switch {
+		case ReplayDir != "":
+			transport = NewReplayer(ReplayDir)
+		case RecordDir != "":
+			transport = NewRecorder(RecordDir, HTTPTransport())
+		default:
+			transport = HTTPTransport()
+		}
+	})
+	return transport
+}
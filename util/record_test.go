@@ -0,0 +1,74 @@
+package util
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("x-rate-limit-remaining", "14")
+		if r.URL.Path == "/oauth2/token" {
+			w.Write([]byte(`{"token_type":"bearer","access_token":"s3cret"}`))
+			return
+		}
+		if r.URL.Path == "/avatar.png" {
+			w.Write([]byte{0x89, 'P', 'N', 'G', 0xff, 0xfe, 0x00})
+			return
+		}
+		w.Write([]byte(r.URL.RawQuery + " " + strings.Repeat("x", calls)))
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "nucoll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	get := func(rt http.RoundTripper, path string) string {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		res, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return string(b)
+	}
+	paths := []string{"/oauth2/token", "/ids?cursor=-1", "/ids?cursor=-1", "/ids?cursor=2", "/avatar.png"}
+	var recorded []string
+	rec := NewRecorder(dir, http.DefaultTransport)
+	for _, p := range paths {
+		recorded = append(recorded, get(rec, p))
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != len(paths) {
+		t.Fatalf("expected %d exchanges, actual %v", len(paths), files)
+	}
+	for _, f := range files {
+		b, _ := ioutil.ReadFile(f)
+		if strings.Contains(string(b), "s3cret") {
+			t.Errorf("%s holds the token: %s", f, b)
+		}
+	}
+
+	srv.Close()
+	rep := NewReplayer(dir)
+	for i, p := range paths[1:] {
+		if actual := get(rep, p); actual != recorded[i+1] {
+			t.Errorf("replay of %s: expected %q, actual %q", p, recorded[i+1], actual)
+		}
+	}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/ids?cursor=3", nil)
+	if _, err := rep.RoundTrip(req); err == nil {
+		t.Error("unrecorded request replayed")
+	}
+}
@@ -0,0 +1,164 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Retry is the policy applied to transient failures, i.e. network errors and 500, 502, 503,
+// 504 or 429 responses which are not handled as throttling, of idempotent requests
+// attempts stop after MaxAttempts or once MaxElapsed has passed since the first one
+type Retry struct {
+	MaxAttempts int
+	MaxElapsed  time.Duration
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetry lets an overnight run ride out short outages
+var DefaultRetry = Retry{MaxAttempts: 8, MaxElapsed: 30 * time.Minute, BaseDelay: 2 * time.Second, MaxDelay: 5 * time.Minute}
+
+// RetryError is returned once attempts are exhausted, it wraps the last failure
+type RetryError struct {
+	Method   string
+	Path     string
+	Attempts int
+	Elapsed  time.Duration
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%s %s: giving up after %d attempts in %s: %s", e.Method, e.Path, e.Attempts, e.Elapsed.Round(time.Second), e.Err)
+}
+
+// Unwrap returns the last failure
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// permanent marks a failure another attempt would not fix
+type permanent struct {
+	error
+}
+
+// Unwrap returns the failure
+func (p permanent) Unwrap() error {
+	return p.error
+}
+
+// Permanent wraps err so it is returned at once instead of being retried
+// the typed error remains available to errors.As
+func Permanent(err error) error {
+	return permanent{err}
+}
+
+// Idempotent reports whether req can be sent again without side effects
+// other methods qualify when the request carries an Idempotency-Key header and its body can be replayed
+func Idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" && (req.Body == nil || req.GetBody != nil)
+}
+
+// Transient reports whether the outcome of a round trip is worth another attempt
+// failures caused by the request context being done or marked Permanent are final
+func Transient(req *http.Request, res *http.Response, err error) bool {
+	if req.Context().Err() != nil || errors.As(err, new(permanent)) {
+		return false
+	}
+	if res == nil {
+		return err != nil
+	}
+	switch res.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return false
+}
+
+// Backoff returns the delay before retrying after the given failed attempt
+// it grows exponentially from BaseDelay up to MaxDelay with full jitter
+func (r Retry) Backoff(attempt int) time.Duration {
+	d := r.MaxDelay
+	if attempt < 32 && r.BaseDelay<<uint(attempt-1) < r.MaxDelay {
+		d = r.BaseDelay << uint(attempt-1)
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// Do calls send until it succeeds, fails permanently or the policy gives up
+// bodies of failed responses are closed before trying again
+func (r Retry) Do(req *http.Request, send func() (*http.Response, error)) (*http.Response, error) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		res, err := send()
+		if !Transient(req, res, err) || !Idempotent(req) {
+			return res, err
+		}
+		if err == nil {
+			err = errors.New(res.Status)
+		}
+		delay := r.Backoff(attempt)
+		if attempt >= r.MaxAttempts || time.Since(start)+delay > r.MaxElapsed {
+			if res != nil {
+				res.Body.Close()
+			}
+			return nil, &RetryError{Method: req.Method, Path: req.URL.Path, Attempts: attempt, Elapsed: time.Since(start), Err: err}
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+		log.Printf("%s %s: %s; retrying in %s\n", req.Method, req.URL.Path, err, delay.Round(time.Millisecond))
+		if err := Sleep(req, delay); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// retrySettings as stored in the retry object of a network section, durations such as "30m"
+type retrySettings struct {
+	MaxAttempts int    `json:"max_attempts"`
+	MaxElapsed  string `json:"max_elapsed"`
+}
+
+// Retry returns DefaultRetry overridden by the retry settings of the network section
+func (c *NucollConfig) Retry(network string) (Retry, error) {
+	var section struct {
+		Retry *retrySettings `json:"retry"`
+	}
+
+	r := DefaultRetry
+	if err := c.Section(network, &section); err != nil {
+		return r, fmt.Errorf("invalid retry settings for %s: %w", network, err)
+	}
+	s := section.Retry
+	if s == nil {
+		return r, nil
+	}
+	if s.MaxAttempts > 0 {
+		r.MaxAttempts = s.MaxAttempts
+	}
+	if s.MaxElapsed != "" {
+		d, err := time.ParseDuration(s.MaxElapsed)
+		if err != nil {
+			return r, fmt.Errorf("invalid retry settings for %s: %w", network, err)
+		}
+		r.MaxElapsed = d
+	}
+	return r, nil
+}
@@ -0,0 +1,153 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var quickRetry = Retry{MaxAttempts: 3, MaxElapsed: time.Minute, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+// failing answers the first failures requests with status
+func failing(failures int, status int) *httptest.Server {
+	calls := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+}
+
+func TestRetryDo(t *testing.T) {
+	for _, c := range []struct {
+		method    string
+		failures  int
+		status    int
+		attempts  int
+		succeeded bool
+	}{
+		{http.MethodGet, 2, http.StatusBadGateway, 3, true},
+		{http.MethodGet, 3, http.StatusServiceUnavailable, 3, false},
+		{http.MethodGet, 1, http.StatusNotFound, 1, true},
+		{http.MethodPost, 1, http.StatusInternalServerError, 1, true},
+	} {
+		srv := failing(c.failures, c.status)
+		attempts := 0
+		req, _ := http.NewRequest(c.method, srv.URL+"/x", strings.NewReader("body"))
+		res, err := quickRetry.Do(req, func() (*http.Response, error) {
+			attempts++
+			return http.DefaultTransport.RoundTrip(req)
+		})
+		srv.Close()
+		if attempts != c.attempts {
+			t.Errorf("%s after %d x %d: expected %d attempts, actual %d", c.method, c.failures, c.status, c.attempts, attempts)
+		}
+		if c.succeeded {
+			if err != nil {
+				t.Errorf("%s after %d x %d: unexpected %v", c.method, c.failures, c.status, err)
+			} else {
+				res.Body.Close()
+			}
+			continue
+		}
+		var re *RetryError
+		if !errors.As(err, &re) || re.Attempts != c.attempts || !strings.Contains(err.Error(), "503") {
+			t.Errorf("%s after %d x %d: expected final error, actual %v", c.method, c.failures, c.status, err)
+		}
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	var pe *os.PathError
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/x", nil)
+	attempts := 0
+	_, err := quickRetry.Do(req, func() (*http.Response, error) {
+		attempts++
+		return nil, Permanent(&os.PathError{Op: "open", Path: "x", Err: os.ErrNotExist})
+	})
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt, actual %d", attempts)
+	}
+	if !errors.As(err, &pe) || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the underlying error, actual %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	r := Retry{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	for attempt, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 60: 5 * time.Second} {
+		for i := 0; i < 20; i++ {
+			if d := r.Backoff(attempt); d < 0 || d > max {
+				t.Fatalf("Backoff(%d): %s out of [0, %s]", attempt, d, max)
+			}
+		}
+	}
+}
+
+func TestThrottledWithoutReset(t *testing.T) {
+	res := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+	rl := RateLimit{Remaining: "x-rate-limit-remaining", Reset: "x-rate-limit-reset"}
+	if throttled, err := rl.Throttled(res); throttled || err != nil {
+		t.Errorf("expected a plain failure, actual %t (%v)", throttled, err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch {
+		case calls == 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.Header.Get("Authorization") == "renewed":
+			w.Write([]byte("ok"))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	var rl RateLimit
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	var status *StatusError
+	if _, err := rl.Send(http.DefaultTransport, req, nil); !errors.As(err, &status) || status.StatusCode != http.StatusUnauthorized || calls != 2 {
+		t.Fatalf("expected 401 once the window reset, actual %v after %d calls", err, calls)
+	}
+	renewals := 0
+	res, err := rl.Send(http.DefaultTransport, req, func(res *http.Response) (bool, error) {
+		renewals++
+		req.Header.Set("Authorization", "renewed")
+		return true, nil
+	})
+	if err != nil || renewals != 1 {
+		t.Fatalf("expected the renewed request to pass, actual %d renewals (%v)", renewals, err)
+	}
+	res.Body.Close()
+}
+
+func TestRetryConfig(t *testing.T) {
+	var config NucollConfig
+	if err := json.Unmarshal([]byte(`{"networks":{"twitter":{"retry":{"max_attempts":3,"max_elapsed":"2h"}},"github":{"retry":{"max_elapsed":"soon"}}}}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	r, err := config.Retry("twitter")
+	if err != nil || r.MaxAttempts != 3 || r.MaxElapsed != 2*time.Hour || r.BaseDelay != DefaultRetry.BaseDelay {
+		t.Errorf("twitter: unexpected %+v (%v)", r, err)
+	}
+	if r, err := config.Retry("mastodon"); err != nil || r != DefaultRetry {
+		t.Errorf("mastodon: expected defaults, actual %+v (%v)", r, err)
+	}
+	if _, err := config.Retry("github"); err == nil {
+		t.Error("github: invalid duration accepted")
+	}
+}
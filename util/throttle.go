@@ -0,0 +1,108 @@
+package util
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimit names the response headers a service uses to announce its rate limit window
+// ParseReset converts the reset header to a time, Unix seconds when nil
+type RateLimit struct {
+	Remaining  string
+	Reset      string
+	ParseReset func(string) (time.Time, error)
+}
+
+// UnixReset parses reset headers holding seconds since epoch
+func UnixReset(s string) (time.Time, error) {
+	x, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(x, 0), nil
+}
+
+// Throttled checks if res was rejected because the rate limit window is exhausted
+// in which case it closes the body and sleeps until the window resets or the request is canceled
+// 403 only counts when no requests remain as GitHub uses it for its primary limit
+// responses without a usable reset time are left to the retry policy
+func (rl RateLimit) Throttled(res *http.Response) (bool, error) {
+	switch res.StatusCode {
+	case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+	case http.StatusForbidden:
+		if res.Header.Get(rl.Remaining) != "0" && res.Header.Get("Retry-After") == "" {
+			return false, nil
+		}
+	default:
+		return false, nil
+	}
+	var win time.Time
+	if after, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		win = time.Now().Add(time.Duration(after) * time.Second)
+	} else {
+		parse := rl.ParseReset
+		if parse == nil {
+			parse = UnixReset
+		}
+		reset, err := parse(res.Header.Get(rl.Reset))
+		if err != nil {
+			return false, nil
+		}
+		win = reset.Add(5 * time.Second)
+	}
+	res.Body.Close()
+	log.Printf("response code %d received; waiting until %s to resume", res.StatusCode, win.Local().Format("15:04:05"))
+	return true, Sleep(res.Request, time.Until(win))
+}
+
+// Send passes req to t, waiting whenever the rate limit window is exhausted, and returns a StatusError
+// for any other status than 200 OK; rejected, when given, sees those responses first and reports
+// whether req should be sent again, e.g. once an expired token was renewed
+func (rl RateLimit) Send(t http.RoundTripper, req *http.Request, rejected func(*http.Response) (bool, error)) (*http.Response, error) {
+	for {
+		res, err := t.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		throttled, err := rl.Throttled(res)
+		if err != nil {
+			return nil, err
+		}
+		if throttled {
+			continue
+		}
+		if res.StatusCode == http.StatusOK {
+			return res, nil
+		}
+		retry := false
+		if rejected != nil {
+			retry, err = rejected(res)
+		}
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if !retry {
+			return nil, &StatusError{StatusCode: res.StatusCode, Status: res.Status}
+		}
+	}
+}
+
+// Sleep pauses for d unless the context of req is done first
+func Sleep(req *http.Request, d time.Duration) error {
+	ctx := context.Background()
+	if req != nil {
+		ctx = req.Context()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,17 @@
+// Package utiltest provides helpers shared by the tests of the backends
+package utiltest
+
+import (
+	"testing"
+
+	"github.com/jdevoo/nucoll/util"
+)
+
+// InWorkspace makes an empty directory removed after the test the workspace of commands
+func InWorkspace(t *testing.T) string {
+	t.Helper()
+	ws := util.Workspace
+	util.Workspace = t.TempDir()
+	t.Cleanup(func() { util.Workspace = ws })
+	return util.Workspace
+}
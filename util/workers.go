@@ -0,0 +1,64 @@
+package util
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// ForEach calls fn for indexes 0 to n-1 from up to workers goroutines
+// messages returned by fn are logged in index order so logs read as those of a sequential run
+// the first error in index order cancels the remaining calls and is returned once all workers are done
+func ForEach(ctx context.Context, n int, workers int, fn func(ctx context.Context, i int) (string, error)) error {
+	type result struct {
+		msg string
+		err error
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]chan result, n)
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := 0; i < n; i++ {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				for ; i < n; i++ {
+					results[i] <- result{err: ctx.Err()}
+				}
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				msg, err := fn(ctx, i)
+				results[i] <- result{msg, err}
+			}
+		}()
+	}
+	defer wg.Wait()
+	for i := 0; i < n; i++ {
+		r := <-results[i]
+		if r.err != nil {
+			cancel()
+			return r.err
+		}
+		if r.msg != "" {
+			log.Println(r.msg)
+		}
+	}
+	return nil
+}
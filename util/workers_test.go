@@ -0,0 +1,54 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForEach(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+
+	err := ForEach(context.Background(), 6, 3, func(ctx context.Context, i int) (string, error) {
+		// later indexes finish first
+		time.Sleep(time.Duration(6-i) * time.Millisecond)
+		if i == 2 {
+			return "", nil
+		}
+		return fmt.Sprint("processed ", i), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "processed 0\nprocessed 1\nprocessed 3\nprocessed 4\nprocessed 5\n"; buf.String() != expected {
+		t.Errorf("expected %q, actual %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	failure := errors.New("failure")
+	err = ForEach(context.Background(), 100, 4, func(ctx context.Context, i int) (string, error) {
+		if i == 1 {
+			return "", failure
+		}
+		if err := Sleep(nil, time.Millisecond); err != nil {
+			return "", err
+		}
+		return fmt.Sprint("processed ", i), ctx.Err()
+	})
+	if err != failure {
+		t.Errorf("expected the first error, actual %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); lines[0] != "processed 0" || len(lines) != 1 {
+		t.Errorf("expected only the reports preceding the error, actual %q", buf.String())
+	}
+}